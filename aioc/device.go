@@ -0,0 +1,430 @@
+package aioc
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sstallion/go-hid"
+)
+
+// DefaultTimeout bounds every operation of a newly opened device
+const DefaultTimeout = 2 * time.Second
+
+// Device is an opened AIOC. It is safe for concurrent use: each operation
+// is a transaction that holds the handle for its request and response, and
+// an advisory lock file keyed by the serial number keeps transactions of
+// other processes using the same AIOC from interleaving with it.
+//
+// Every operation runs under the device timeout (see SetTimeout) and, for
+// the Context variants, the context. hidapi calls cannot be interrupted, so
+// an operation that times out or is cancelled keeps running in the
+// background and the handle is marked broken: later operations fail with
+// ErrBroken instead of queueing behind the hung call, and the caller is
+// expected to Close the handle and open the device again.
+type Device struct {
+	device *hid.Device
+	info   *hid.DeviceInfo
+	lock   *lockFile     // nil where flock or a lock file is unavailable
+	busy   chan struct{} // held while a transaction is in flight
+
+	mu      sync.Mutex
+	timeout time.Duration
+	gpio    CM108GPIO     // GPIO levels last written through this handle
+	broken  error         // cause of the first timeout or cancellation
+	caps    *Capabilities // cached by CapabilitiesContext
+}
+
+// Open opens the first AIOC with the given VID/PID
+func Open(vid, pid uint16) (*Device, error) {
+	device, err := hid.OpenFirst(vid, pid)
+	if err != nil {
+		return nil, openError(vid, pid, "", err)
+	}
+	return newDevice(device)
+}
+
+// OpenSerial opens the AIOC with the given VID/PID and USB serial number,
+// for setups with several AIOCs attached
+func OpenSerial(vid, pid uint16, serial string) (*Device, error) {
+	device, err := hid.Open(vid, pid, serial)
+	if err != nil {
+		return nil, openError(vid, pid, serial, err)
+	}
+	return newDevice(device)
+}
+
+// openError classifies a failed hid open. hidapi only reports a message,
+// so the device list and the device node are checked to tell a missing
+// device from one the user may not open.
+func openError(vid, pid uint16, serial string, err error) error {
+	var found []*hid.DeviceInfo
+	hid.Enumerate(vid, pid, func(info *hid.DeviceInfo) error {
+		if serial == "" || info.SerialNbr == serial {
+			found = append(found, info)
+		}
+		return nil
+	})
+	if len(found) == 0 {
+		if serial != "" {
+			return fmt.Errorf("%w: no device 0x%04x:0x%04x with serial %s", ErrNotFound, vid, pid, serial)
+		}
+		return fmt.Errorf("%w: no device 0x%04x:0x%04x", ErrNotFound, vid, pid)
+	}
+	if strings.Contains(strings.ToLower(err.Error()), "permission") {
+		return fmt.Errorf("%w: %v", ErrPermission, err)
+	}
+	for _, info := range found {
+		if !strings.HasPrefix(info.Path, "/dev/") {
+			continue
+		}
+		f, perr := os.OpenFile(info.Path, os.O_RDWR, 0)
+		if perr == nil {
+			f.Close()
+		} else if errors.Is(perr, os.ErrPermission) {
+			return fmt.Errorf("%w: cannot open %s", ErrPermission, info.Path)
+		}
+	}
+	return fmt.Errorf("failed to open device: %w", err)
+}
+
+// newDevice wraps an opened HID device after checking that it is an AIOC
+func newDevice(device *hid.Device) (*Device, error) {
+	info, err := device.GetDeviceInfo()
+	if err != nil {
+		device.Close()
+		return nil, fmt.Errorf("failed to get device info: %w", err)
+	}
+	key := info.SerialNbr
+	if key == "" {
+		key = info.Path
+	}
+	// Without a lock file the device still works, only without protection
+	// from other processes
+	lock := openDeviceLock(info.VendorID, info.ProductID, key)
+	d := &Device{device: device, info: info, lock: lock, busy: make(chan struct{}, 1), timeout: DefaultTimeout}
+
+	magic, err := d.Read(RegMAGIC)
+	if err != nil {
+		d.Close()
+		if errors.Is(err, ErrTimeout) || errors.Is(err, ErrBusy) {
+			return nil, fmt.Errorf("failed to read magic: %w", err)
+		}
+		// Firmware without the register interface rejects the feature
+		// report or answers with a shorter one. Such firmware cannot change
+		// its USB IDs, so under other IDs the device is not an AIOC.
+		if info.VendorID != VendorID || info.ProductID != ProductID {
+			return nil, &NotAIOCError{Err: err}
+		}
+		return nil, fmt.Errorf("%w: failed to read magic: %w", ErrUnsupportedFirmware, err)
+	}
+	if DecodeMagic(magic) != Magic {
+		d.Close()
+		return nil, &NotAIOCError{Magic: DecodeMagic(magic)}
+	}
+
+	return d, nil
+}
+
+// SetTimeout sets the deadline applied to each operation, on top of any
+// context deadline. Zero disables it.
+func (d *Device) SetTimeout(timeout time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.timeout = timeout
+}
+
+// Broken returns the error that broke the handle, or nil if it is usable
+func (d *Device) Broken() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.broken
+}
+
+// Close closes the device. If a timed out operation is still stuck in
+// hidapi the handle is closed once that call returns.
+func (d *Device) Close() error {
+	select {
+	case d.busy <- struct{}{}:
+		d.lock.close()
+		return d.device.Close()
+	default:
+		go func() {
+			d.busy <- struct{}{}
+			d.lock.close()
+			d.device.Close()
+		}()
+		return nil
+	}
+}
+
+// do runs fn, which makes the hidapi calls of one operation, bounded by ctx
+// and the device timeout
+func (d *Device) do(ctx context.Context, op string, fn func() error) error {
+	if err := d.Broken(); err != nil {
+		return fmt.Errorf("%s: %w", op, ErrBroken)
+	}
+	d.mu.Lock()
+	timeout := d.timeout
+	d.mu.Unlock()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	// Nothing has been sent yet, so giving up while waiting for the
+	// handle or the lock leaves the device untouched
+	select {
+	case d.busy <- struct{}{}:
+	case <-ctx.Done():
+		return fmt.Errorf("%s: %w", op, ErrBusy)
+	}
+	if err := d.lock.acquire(ctx); err != nil {
+		<-d.busy
+		if ctx.Err() != nil {
+			return fmt.Errorf("%s: %w (locked by another process)", op, ErrBusy)
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		defer func() { <-d.busy }()
+		defer d.lock.release()
+		done <- fn()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return d.abandon(op, ctx.Err())
+	}
+}
+
+// abandon marks the handle broken after op did not complete
+func (d *Device) abandon(op string, cause error) error {
+	err := fmt.Errorf("%s: %w", op, cause)
+	if errors.Is(cause, context.DeadlineExceeded) {
+		err = fmt.Errorf("%s: %w", op, ErrTimeout)
+	}
+	d.mu.Lock()
+	if d.broken == nil {
+		d.broken = err
+	}
+	d.mu.Unlock()
+	return err
+}
+
+// Read reads a 32-bit value from a register
+func (d *Device) Read(address Register) (uint32, error) {
+	return d.ReadContext(context.Background(), address)
+}
+
+// ReadContext reads a 32-bit value from a register, giving up when ctx is
+// done
+func (d *Device) ReadContext(ctx context.Context, address Register) (uint32, error) {
+	var value uint32
+	err := d.do(ctx, "read "+address.String(), func() error {
+		// Request: [report ID 0, NONE, address, 0x00000000]
+		request := make([]byte, 7)
+		request[1] = uint8(CmdNONE)
+		request[2] = uint8(address)
+
+		if _, err := d.device.SendFeatureReport(request); err != nil {
+			return fmt.Errorf("failed to send feature report: %w", err)
+		}
+
+		response := make([]byte, 7)
+		n, err := d.device.GetFeatureReport(response)
+		if err != nil {
+			return fmt.Errorf("failed to get feature report: %w", err)
+		}
+		if n < 7 {
+			return fmt.Errorf("%w: got %d bytes, expected 7", ErrShortRead, n)
+		}
+		value = binary.LittleEndian.Uint32(response[3:7])
+		return nil
+	})
+	return value, err
+}
+
+// Write writes a 32-bit value to a register. The value is lost on
+// power-down unless it is stored with CmdSTORE.
+func (d *Device) Write(address Register, value uint32) error {
+	return d.WriteContext(context.Background(), address, value)
+}
+
+// WriteContext writes a 32-bit value to a register, giving up when ctx is
+// done
+func (d *Device) WriteContext(ctx context.Context, address Register, value uint32) error {
+	return d.do(ctx, "write "+address.String(), func() error {
+		data := make([]byte, 7)
+		data[1] = uint8(CmdWRITESTROBE)
+		data[2] = uint8(address)
+		binary.LittleEndian.PutUint32(data[3:7], value)
+
+		if _, err := d.device.SendFeatureReport(data); err != nil {
+			return fmt.Errorf("failed to write register: %w", err)
+		}
+		return nil
+	})
+}
+
+// SendCommand sends a command to the device
+func (d *Device) SendCommand(cmd Command) error {
+	return d.SendCommandContext(context.Background(), cmd)
+}
+
+// SendCommandContext sends a command to the device, giving up when ctx is
+// done
+func (d *Device) SendCommandContext(ctx context.Context, cmd Command) error {
+	return d.do(ctx, fmt.Sprintf("command 0x%02x", uint8(cmd)), func() error {
+		data := make([]byte, 7)
+		data[1] = uint8(cmd)
+
+		if _, err := d.device.SendFeatureReport(data); err != nil {
+			return fmt.Errorf("failed to send command: %w", err)
+		}
+		return nil
+	})
+}
+
+// ReadRegisters reads the given registers, or all known registers if none
+// are given
+func (d *Device) ReadRegisters(regs ...Register) (map[Register]uint32, error) {
+	return d.ReadRegistersContext(context.Background(), regs...)
+}
+
+// ReadRegistersContext is ReadRegisters with a context covering all reads
+func (d *Device) ReadRegistersContext(ctx context.Context, regs ...Register) (map[Register]uint32, error) {
+	if len(regs) == 0 {
+		regs = Registers
+	}
+	vals := make(map[Register]uint32, len(regs))
+	for _, reg := range regs {
+		val, err := d.ReadContext(ctx, reg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", reg, err)
+		}
+		vals[reg] = val
+	}
+	return vals, nil
+}
+
+// ReadFoxhuntMessage reads the foxhunt message
+func (d *Device) ReadFoxhuntMessage() (string, error) {
+	var vals [4]uint32
+	for i, reg := range FoxhuntMessageRegisters {
+		val, err := d.Read(reg)
+		if err != nil {
+			return "", err
+		}
+		vals[i] = val
+	}
+	return DecodeFoxhuntMessage(vals), nil
+}
+
+// WriteFoxhuntMessage writes the foxhunt message, up to 16 bytes
+func (d *Device) WriteFoxhuntMessage(msg string) error {
+	vals, err := EncodeFoxhuntMessage(msg)
+	if err != nil {
+		return err
+	}
+	for i, reg := range FoxhuntMessageRegisters {
+		if err := d.Write(reg, vals[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetPTTState keys or unkeys a PTT channel (PTTChannel1 or PTTChannel2)
+// through its CM108 GPIO
+func (d *Device) SetPTTState(channel int, on bool) error {
+	return d.SetPTTStateContext(context.Background(), channel, on)
+}
+
+// SetPTTStateContext is SetPTTState giving up when ctx is done. If it
+// fails with ErrTimeout the PTT state is unknown.
+func (d *Device) SetPTTStateContext(ctx context.Context, channel int, on bool) error {
+	gpio := CM108GPIO(1 << (channel - 1))
+	state := CM108GPIO(0)
+	if on {
+		state = gpio
+	}
+	if err := d.SetCM108GPIOContext(ctx, gpio, state); err != nil {
+		return fmt.Errorf("failed to write PTT state: %w", err)
+	}
+	return nil
+}
+
+// SetCM108GPIO sets the CM108 GPIOs selected by mask to the levels in state
+// with a single HID output report. GPIOs outside mask are left untouched.
+func (d *Device) SetCM108GPIO(mask, state CM108GPIO) error {
+	return d.SetCM108GPIOContext(context.Background(), mask, state)
+}
+
+// SetCM108GPIOContext is SetCM108GPIO giving up when ctx is done
+func (d *Device) SetCM108GPIOContext(ctx context.Context, mask, state CM108GPIO) error {
+	mask &= CM108GPIOAll
+	return d.do(ctx, "write GPIO", func() error {
+		data := []byte{0, 0, uint8(state & mask), uint8(mask), 0}
+		n, err := d.device.Write(data)
+		if err != nil {
+			return fmt.Errorf("failed to write GPIO state: %w", err)
+		}
+		if n != len(data) {
+			return fmt.Errorf("incomplete write: wrote %d bytes, expected %d", n, len(data))
+		}
+		d.mu.Lock()
+		d.gpio = d.gpio&^mask | state&mask
+		d.mu.Unlock()
+		return nil
+	})
+}
+
+// CM108GPIOState returns the commanded GPIO levels: those last written
+// through this handle, not read from the device. The AIOC does not report
+// its GPIO outputs back over HID, neither in its input report nor through
+// GET_REPORT, so changes made by other processes are not visible here.
+func (d *Device) CM108GPIOState() CM108GPIO {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.gpio
+}
+
+// GetManufacturer returns the manufacturer string
+func (d *Device) GetManufacturer() (string, error) {
+	var s string
+	err := d.do(context.Background(), "read manufacturer", func() (err error) {
+		s, err = d.device.GetMfrStr()
+		return err
+	})
+	return s, err
+}
+
+// GetProduct returns the product string
+func (d *Device) GetProduct() (string, error) {
+	var s string
+	err := d.do(context.Background(), "read product", func() (err error) {
+		s, err = d.device.GetProductStr()
+		return err
+	})
+	return s, err
+}
+
+// GetSerialNumber returns the serial number string
+func (d *Device) GetSerialNumber() (string, error) {
+	var s string
+	err := d.do(context.Background(), "read serial number", func() (err error) {
+		s, err = d.device.GetSerialNbr()
+		return err
+	})
+	return s, err
+}
@@ -0,0 +1,29 @@
+// Package aioc controls AIOC (All-In-One-Cable) adapters over USB HID.
+//
+// An AIOC exposes its configuration as 32-bit registers that are read and
+// written with HID feature reports. Writes take effect immediately but are
+// lost on power-down unless they are stored to flash with CmdSTORE. The
+// CM108 compatible GPIOs used for PTT are driven with HID output reports.
+// Registers with bitfields have typed helpers that decode a raw value and
+// encode it again, and DeviceState covers the whole configuration.
+//
+// The package uses github.com/sstallion/go-hid, so callers must call
+// hid.Init before opening a device and hid.Exit when done.
+//
+// Operations are bounded by DefaultTimeout, which SetTimeout changes, and
+// the Context variants also give up when the context is done. After a
+// timeout or cancellation the handle is broken and every further operation
+// fails with ErrBroken, so a supervisor only has to watch for ErrTimeout
+// and ErrBroken to know when to reopen the device. Other failures are
+// reported with sentinel errors that can be tested with errors.Is.
+//
+// A Device may be shared by several goroutines, e.g. one keying PTT while
+// another polls registers. Each operation is one transaction; sequences
+// such as a read-modify-write are not atomic. Transactions also take an
+// flock(2) on a lock file in /run/lock (or the temporary directory) named
+// after the device's serial number, so several processes can use the same
+// AIOC. If neither is writable the lock file goes in the user's cache
+// directory, and if that fails too the device is opened without one. A
+// transaction that cannot get the device before its deadline fails with
+// ErrBusy and leaves the handle usable.
+package aioc
@@ -0,0 +1,94 @@
+package aioc
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrNotFound is returned by Open when no device with the requested
+	// VID/PID (and serial number) is attached
+	ErrNotFound = errors.New("device not found")
+
+	// ErrPermission is returned by Open when the device is attached but the
+	// user may not open it, typically because the udev rule is missing
+	ErrPermission = errors.New("permission denied")
+
+	// ErrNotAIOC is returned by Open when the device does not report the
+	// AIOC magic value. The error is a *NotAIOCError holding the magic, or
+	// the read error if a device with other than the default USB IDs
+	// rejected the read.
+	ErrNotAIOC = errors.New("not an AIOC device")
+
+	// ErrUnsupportedFirmware is returned when the firmware does not
+	// implement a feature, such as the register interface itself
+	ErrUnsupportedFirmware = errors.New("unsupported firmware")
+
+	// ErrShortRead is returned when the device answers with a truncated
+	// feature report
+	ErrShortRead = errors.New("short read")
+
+	// ErrUnknownFirmware is returned by Capabilities.Check when the device
+	// does not report its firmware version and probing could not show that
+	// the feature is present
+	ErrUnknownFirmware = errors.New("unknown firmware")
+
+	// ErrTimeout is returned when an operation does not complete within
+	// the device timeout or the context deadline. The handle is broken
+	// afterwards.
+	ErrTimeout = errors.New("device did not respond in time")
+
+	// ErrBusy is returned when another transaction, in this process or in
+	// another one, holds the device until the deadline. The handle stays
+	// usable.
+	ErrBusy = errors.New("device busy")
+
+	// ErrBroken is returned by every operation on a handle whose earlier
+	// operation timed out or was cancelled. Close it and open the device
+	// again.
+	ErrBroken = errors.New("device handle broken by an earlier timeout")
+)
+
+// NotAIOCError is returned by Open when the device is not an AIOC, e.g. a
+// CM108 sound card sharing its USB IDs
+type NotAIOCError struct {
+	Magic string // MAGIC register as read from the device
+	Err   error  // why the MAGIC register could not be read, or nil
+}
+
+func (e *NotAIOCError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%v: failed to read magic: %v", ErrNotAIOC, e.Err)
+	}
+	return fmt.Sprintf("%v: unexpected magic %q", ErrNotAIOC, e.Magic)
+}
+
+// Is makes errors.Is(err, ErrNotAIOC) match
+func (e *NotAIOCError) Is(target error) bool {
+	return target == ErrNotAIOC
+}
+
+// Unwrap returns the read error, if any
+func (e *NotAIOCError) Unwrap() error {
+	return e.Err
+}
+
+// RangeError is returned by the Encode helpers when a field does not fit
+// its bitfield
+type RangeError struct {
+	Field string
+	Value int
+	Max   int
+}
+
+func (e *RangeError) Error() string {
+	return fmt.Sprintf("%s %d out of range 0-%d", e.Field, e.Value, e.Max)
+}
+
+// checkRange returns a *RangeError if value is not within 0..max
+func checkRange(field string, value, max int) error {
+	if value < 0 || value > max {
+		return &RangeError{Field: field, Value: value, Max: max}
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package aioc_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rampa069/aioc-util/aioc"
+	"github.com/sstallion/go-hid"
+)
+
+// The examples using a device have no output to check, since they need an
+// AIOC; they are compiled but not run.
+
+func Example() {
+	if err := hid.Init(); err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer hid.Exit()
+
+	dev, err := aioc.Open(aioc.VendorID, aioc.ProductID)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer dev.Close()
+
+	// Route PTT1 to CM108 GPIO3 and virtual PTT, and keep it over a power
+	// cycle
+	src, err := aioc.ParsePTTSource("CM108GPIO3|VPTT")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if err := dev.Write(aioc.RegAIOCIOMUX0, uint32(src)); err != nil {
+		fmt.Println(err)
+		return
+	}
+	if err := dev.SendCommand(aioc.CmdSTORE); err != nil {
+		fmt.Println(err)
+	}
+}
+
+func ExampleOpen() {
+	dev, err := aioc.Open(aioc.VendorID, aioc.ProductID)
+	var notAIOC *aioc.NotAIOCError
+	switch {
+	case errors.Is(err, aioc.ErrNotFound):
+		fmt.Println("not plugged in")
+	case errors.Is(err, aioc.ErrPermission):
+		fmt.Println("udev rule missing")
+	case errors.As(err, &notAIOC):
+		fmt.Printf("found a device with magic %q\n", notAIOC.Magic)
+	case err != nil:
+		fmt.Println(err)
+	default:
+		dev.Close()
+	}
+}
+
+func ExampleDevice_WriteState() {
+	dev, err := aioc.Open(aioc.VendorID, aioc.ProductID)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer dev.Close()
+
+	state, err := dev.ReadState()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	want := state
+	want.Audio.RXGain = aioc.RXGain4X
+	want.VPTT.Timeout = 300 * time.Millisecond
+	for _, c := range aioc.Diff(state, want) {
+		fmt.Println(c)
+	}
+	// Only the changed registers are written
+	if err := dev.WriteState(want); err != nil {
+		fmt.Println(err)
+	}
+}
+
+func ExampleDevice_ReadContext() {
+	dev, err := aioc.Open(aioc.VendorID, aioc.ProductID)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer dev.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	val, err := dev.ReadContext(ctx, aioc.RegAUDIORX)
+	if errors.Is(err, aioc.ErrTimeout) || errors.Is(err, aioc.ErrBroken) {
+		// The cable is hung: close and reopen it
+		fmt.Println(err)
+		return
+	}
+	fmt.Println("RX gain:", aioc.RXGain(val))
+}
+
+func ExampleParseRegister() {
+	for _, s := range []string{"audio_rx", "0x72", "0x12zz"} {
+		reg, err := aioc.ParseRegister(s)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		fmt.Printf("%s at 0x%02x\n", reg, uint8(reg))
+	}
+	// Output:
+	// AUDIO_RX at 0x72
+	// AUDIO_RX at 0x72
+	// unknown register: 0x12zz
+}
+
+func ExampleRegister_Describe() {
+	fmt.Println(aioc.RegUSBID.Describe(aioc.EncodeUSBID(aioc.VendorID, aioc.ProductID)))
+	fmt.Println(aioc.RegAUDIORX.Describe(uint32(aioc.RXGain4X)))
+	// Output:
+	// VID 0x1209, PID 0x7388
+	// RX gain 4x
+}
+
+func ExampleFoxhuntCtrl_Encode() {
+	val, err := aioc.FoxhuntCtrl{Volume: 32768, WPM: 20, Interval: 60}.Encode()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("0x%08x %+v\n", val, aioc.DecodeFoxhuntCtrl(val))
+
+	_, err = aioc.FoxhuntCtrl{WPM: 300}.Encode()
+	fmt.Println(err)
+	// Output:
+	// 0x8000143c {Volume:32768 WPM:20 Interval:60}
+	// wpm 300 out of range 0-255
+}
+
+func ExampleEncodeFoxhuntMessage() {
+	vals, err := aioc.EncodeFoxhuntMessage("VVV DE N0CALL")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for i, reg := range aioc.FoxhuntMessageRegisters {
+		fmt.Printf("%s 0x%08x\n", reg, vals[i])
+	}
+	fmt.Printf("%q\n", aioc.DecodeFoxhuntMessage(vals))
+	// Output:
+	// FOXHUNT_MSG0 0x20565656
+	// FOXHUNT_MSG1 0x4e204544
+	// FOXHUNT_MSG2 0x4c414330
+	// FOXHUNT_MSG3 0x0000004c
+	// "VVV DE N0CALL"
+}
+
+func ExampleEncodeTimeout() {
+	val, err := aioc.EncodeTimeout(250 * time.Millisecond)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println(val, aioc.DecodeTimeout(val))
+	// Output:
+	// 250 250ms
+}
+
+func ExampleDiff() {
+	var from aioc.DeviceState
+	to := from
+	to.Audio.RXGain = aioc.RXGain2X
+	to.Foxhunt.Message = "TEST"
+	for _, c := range aioc.Diff(from, to) {
+		fmt.Println(c)
+	}
+	// Output:
+	// Audio.RXGain: 1x -> 2x
+	// Foxhunt.Message: "" -> "TEST"
+}
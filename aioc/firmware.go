@@ -0,0 +1,248 @@
+package aioc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// FirmwareVersion is an AIOC firmware release. The zero value means the
+// version is unknown.
+type FirmwareVersion struct {
+	Major, Minor, Patch int
+}
+
+// ParseFirmwareVersion parses "1.4", "v1.4.0" and similar
+func ParseFirmwareVersion(s string) (FirmwareVersion, error) {
+	parts := strings.Split(strings.TrimPrefix(strings.TrimSpace(s), "v"), ".")
+	if len(parts) < 2 || len(parts) > 3 {
+		return FirmwareVersion{}, fmt.Errorf("invalid firmware version %q", s)
+	}
+	var n [3]int
+	for i, p := range parts {
+		v, err := strconv.Atoi(p)
+		if err != nil || v < 0 {
+			return FirmwareVersion{}, fmt.Errorf("invalid firmware version %q", s)
+		}
+		n[i] = v
+	}
+	return FirmwareVersion{n[0], n[1], n[2]}, nil
+}
+
+// DecodeBCDDevice returns the firmware version encoded in the USB
+// bcdDevice field as 0xMMmp (major, minor, patch in BCD)
+func DecodeBCDDevice(bcd uint16) FirmwareVersion {
+	digit := func(shift uint) int { return int(bcd>>shift) & 0xF }
+	return FirmwareVersion{
+		Major: digit(12)*10 + digit(8),
+		Minor: digit(4),
+		Patch: digit(0),
+	}
+}
+
+// Known reports whether the version is set
+func (v FirmwareVersion) Known() bool {
+	return v != FirmwareVersion{}
+}
+
+// AtLeast reports whether v is o or a later release
+func (v FirmwareVersion) AtLeast(o FirmwareVersion) bool {
+	if v.Major != o.Major {
+		return v.Major > o.Major
+	}
+	if v.Minor != o.Minor {
+		return v.Minor > o.Minor
+	}
+	return v.Patch >= o.Patch
+}
+
+func (v FirmwareVersion) String() string {
+	if !v.Known() {
+		return "unknown"
+	}
+	return fmt.Sprintf("v%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// Capability is a firmware feature that aioc-util can use
+type Capability int
+
+// Capabilities in the order they were added to the firmware
+const (
+	CapRegisters Capability = iota // configuration registers over HID feature reports
+	CapVPTT                        // virtual PTT
+	CapVCOS                        // virtual COS
+	CapAudio                       // RX gain and TX boost
+	CapFoxhunt                     // foxhunt beacon
+)
+
+// capabilities describes each capability: the first firmware release
+// known to work with aioc-util, and registers that read as non-zero on
+// firmware implementing it (their defaults are non-zero)
+var capabilities = []struct {
+	cap   Capability
+	name  string
+	since FirmwareVersion
+	probe []Register
+}{
+	{CapRegisters, "registers", FirmwareVersion{1, 3, 0}, []Register{RegMAGIC}},
+	{CapVPTT, "vptt", FirmwareVersion{1, 3, 0}, []Register{RegVPTTLVLCTRL, RegVPTTTIMCTRL}},
+	{CapVCOS, "vcos", FirmwareVersion{1, 3, 0}, []Register{RegVCOSLVLCTRL, RegVCOSTIMCTRL}},
+	// The audio registers came with the register interface, and their
+	// defaults are zero, so CapAudio follows CapRegisters
+	{CapAudio, "audio", FirmwareVersion{1, 3, 0}, nil},
+	{CapFoxhunt, "foxhunt", FirmwareVersion{1, 4, 0}, []Register{RegFOXHUNTCTRL}},
+}
+
+// AllCapabilities lists every capability
+var AllCapabilities = []Capability{CapRegisters, CapVPTT, CapVCOS, CapAudio, CapFoxhunt}
+
+func (c Capability) String() string {
+	if c >= 0 && int(c) < len(capabilities) {
+		return capabilities[c].name
+	}
+	return fmt.Sprintf("capability(%d)", int(c))
+}
+
+// Since returns the first firmware release providing the capability
+func (c Capability) Since() FirmwareVersion {
+	if c >= 0 && int(c) < len(capabilities) {
+		return capabilities[c].since
+	}
+	return FirmwareVersion{}
+}
+
+// Support is whether the firmware provides a capability
+type Support int
+
+// Support levels. Unknown is reported when the version cannot be read and
+// probing was inconclusive.
+const (
+	Unknown Support = iota
+	Supported
+	Unsupported
+)
+
+func (s Support) String() string {
+	switch s {
+	case Supported:
+		return "yes"
+	case Unsupported:
+		return "no"
+	}
+	return "unknown"
+}
+
+// Capabilities is the feature set of a connected AIOC
+type Capabilities struct {
+	Version FirmwareVersion // from bcdDevice, zero if unknown
+	Probed  bool            // support was derived from register probing
+	support map[Capability]Support
+}
+
+// Support returns whether the firmware provides c
+func (c Capabilities) Support(capability Capability) Support {
+	return c.support[capability]
+}
+
+// Check returns nil if c provides capability, an error wrapping
+// ErrUnsupportedFirmware if it does not, and one wrapping
+// ErrUnknownFirmware if that cannot be told
+func (c Capabilities) Check(capability Capability) error {
+	switch c.support[capability] {
+	case Supported:
+		return nil
+	case Unsupported:
+		if c.Version.Known() {
+			return fmt.Errorf("%w: %s needs firmware %s or later, the device runs %s",
+				ErrUnsupportedFirmware, capability, capability.Since(), c.Version)
+		}
+		return fmt.Errorf("%w: %s is not available on this device", ErrUnsupportedFirmware, capability)
+	}
+	return fmt.Errorf("%w: cannot tell if %s (firmware %s or later) is available",
+		ErrUnknownFirmware, capability, capability.Since())
+}
+
+// FirmwareVersion returns the firmware version reported in the USB
+// bcdDevice field, or the zero version if it does not hold one
+func (d *Device) FirmwareVersion() FirmwareVersion {
+	v := DecodeBCDDevice(d.info.ReleaseNbr)
+	// A bcdDevice below 1.1 is taken as not holding a version
+	if !v.AtLeast(FirmwareVersion{1, 1, 0}) {
+		return FirmwareVersion{}
+	}
+	return v
+}
+
+// BCDDevice returns the raw USB bcdDevice field
+func (d *Device) BCDDevice() uint16 {
+	return d.info.ReleaseNbr
+}
+
+// USBID returns the VID and PID the device enumerated with
+func (d *Device) USBID() (vid, pid uint16) {
+	return d.info.VendorID, d.info.ProductID
+}
+
+// Capabilities returns the features of the connected firmware. They follow
+// from the firmware version when the device reports one; otherwise the
+// registers of each feature are probed, which can prove a feature present
+// but not absent.
+func (d *Device) Capabilities() (Capabilities, error) {
+	return d.CapabilitiesContext(context.Background())
+}
+
+// CapabilitiesContext is Capabilities with a context covering the probes.
+// The result is cached, so the device is probed at most once.
+func (d *Device) CapabilitiesContext(ctx context.Context) (Capabilities, error) {
+	d.mu.Lock()
+	cached := d.caps
+	d.mu.Unlock()
+	if cached != nil {
+		return *cached, nil
+	}
+	c, err := d.capabilities(ctx)
+	if err == nil {
+		d.mu.Lock()
+		d.caps = &c
+		d.mu.Unlock()
+	}
+	return c, err
+}
+
+// capabilities derives the capabilities from the version or by probing
+func (d *Device) capabilities(ctx context.Context) (Capabilities, error) {
+	c := Capabilities{Version: d.FirmwareVersion(), support: make(map[Capability]Support)}
+	if c.Version.Known() {
+		for _, info := range capabilities {
+			c.support[info.cap] = Unsupported
+			if c.Version.AtLeast(info.since) {
+				c.support[info.cap] = Supported
+			}
+		}
+		return c, nil
+	}
+
+	c.Probed = true
+	for _, info := range capabilities {
+		for _, reg := range info.probe {
+			val, err := d.ReadContext(ctx, reg)
+			if err != nil {
+				if errors.Is(err, ErrTimeout) || errors.Is(err, ErrBroken) || errors.Is(err, ErrBusy) {
+					return c, err
+				}
+				continue
+			}
+			if val != 0 {
+				c.support[info.cap] = Supported
+				break
+			}
+		}
+	}
+	// Open has already checked the magic through the register interface,
+	// and the audio registers are part of it
+	c.support[CapRegisters] = Supported
+	c.support[CapAudio] = Supported
+	return c, nil
+}
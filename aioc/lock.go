@@ -0,0 +1,80 @@
+package aioc
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// lockPollInterval is how often a transaction retries a lock held by
+// another process
+const lockPollInterval = 5 * time.Millisecond
+
+// sharedLockDirs are the directories for the per-device lock files,
+// best first. /run/lock is preferred over the temporary directory, which
+// systemd may make private to a service.
+var sharedLockDirs = []string{"/run/lock", os.TempDir()}
+
+// lockName returns the lock file name for a device, keyed by its serial
+// number or, for devices without one, by its HID path
+func lockName(vid, pid uint16, key string) string {
+	clean := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r == '-' {
+			return r
+		}
+		return '_'
+	}, key)
+	return fmt.Sprintf("aioc-%04x-%04x-%s.lock", vid, pid, clean)
+}
+
+// openDeviceLock opens the lock file of a device in the first writable
+// directory where that works. The per-user cache directory is the last
+// resort: it only keeps the user's own processes apart. If no lock file
+// can be opened nil is returned, and transactions are only serialised
+// within the process.
+func openDeviceLock(vid, pid uint16, key string) *lockFile {
+	name := lockName(vid, pid, key)
+	for _, dir := range sharedLockDirs {
+		if !dirWritable(dir) {
+			continue
+		}
+		if l, err := openLock(filepath.Join(dir, name)); err == nil {
+			return l
+		}
+	}
+	cache, err := os.UserCacheDir()
+	if err != nil {
+		return nil
+	}
+	dir := filepath.Join(cache, "aioc-util")
+	if os.MkdirAll(dir, 0o700) != nil || !dirWritable(dir) {
+		return nil
+	}
+	l, _ := openLock(filepath.Join(dir, name))
+	return l
+}
+
+// acquire takes the cross-process lock, retrying until ctx is done. A nil
+// lock always succeeds.
+func (l *lockFile) acquire(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	for {
+		ok, err := l.tryLock()
+		if err != nil {
+			return fmt.Errorf("failed to lock %s: %w", l.path, err)
+		}
+		if ok {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package aioc
+
+// lockFile is unused on platforms without flock(2): transactions are only
+// serialised within the process
+type lockFile struct {
+	path string
+}
+
+func openLock(path string) (*lockFile, error) {
+	return nil, nil
+}
+
+func dirWritable(dir string) bool { return false }
+
+func (l *lockFile) tryLock() (bool, error) { return true, nil }
+func (l *lockFile) release()               {}
+func (l *lockFile) close()                 {}
@@ -0,0 +1,70 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package aioc
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"syscall"
+)
+
+// lockFile is an advisory flock(2) lock shared by every process using the
+// same device
+type lockFile struct {
+	path string
+	f    *os.File
+}
+
+// openLock opens (creating it if needed) the lock file at path. Read
+// access is enough for flock, so a file created by another user still
+// works. An existing file is opened without O_CREAT: with
+// fs.protected_regular set, O_CREAT on another user's file in a sticky
+// directory such as /run/lock fails with EACCES.
+func openLock(path string) (*lockFile, error) {
+	f, err := os.OpenFile(path, os.O_RDONLY, 0)
+	if errors.Is(err, fs.ErrNotExist) {
+		f, err = os.OpenFile(path, os.O_RDONLY|os.O_CREATE|os.O_EXCL, 0o666)
+		if errors.Is(err, fs.ErrExist) {
+			// Another process created it first
+			f, err = os.OpenFile(path, os.O_RDONLY, 0)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &lockFile{path: path, f: f}, nil
+}
+
+// dirWritable reports whether the process may create files in dir
+func dirWritable(dir string) bool {
+	fi, err := os.Stat(dir)
+	if err != nil || !fi.IsDir() {
+		return false
+	}
+	const wOK = 0x2 // W_OK of access(2)
+	return syscall.Access(dir, wOK) == nil
+}
+
+// tryLock takes the lock without blocking and reports whether it succeeded
+func (l *lockFile) tryLock() (bool, error) {
+	err := syscall.Flock(int(l.f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// release drops the lock
+func (l *lockFile) release() {
+	if l != nil {
+		syscall.Flock(int(l.f.Fd()), syscall.LOCK_UN)
+	}
+}
+
+// close closes the lock file, dropping the lock if it is held
+func (l *lockFile) close() {
+	if l != nil {
+		l.f.Close()
+	}
+}
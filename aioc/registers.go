@@ -0,0 +1,147 @@
+package aioc
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Register is the address of a 32-bit configuration register
+type Register uint8
+
+// Register addresses
+const (
+	RegMAGIC        Register = 0x00
+	RegUSBID        Register = 0x08
+	RegAIOCIOMUX0   Register = 0x24
+	RegAIOCIOMUX1   Register = 0x25
+	RegCM108IOMUX0  Register = 0x44
+	RegCM108IOMUX1  Register = 0x45
+	RegCM108IOMUX2  Register = 0x46
+	RegCM108IOMUX3  Register = 0x47
+	RegSERIALCTRL   Register = 0x60
+	RegSERIALIOMUX0 Register = 0x64
+	RegSERIALIOMUX1 Register = 0x65
+	RegSERIALIOMUX2 Register = 0x66
+	RegSERIALIOMUX3 Register = 0x67
+	RegAUDIORX      Register = 0x72
+	RegAUDIOTX      Register = 0x78
+	RegVPTTLVLCTRL  Register = 0x82
+	RegVPTTTIMCTRL  Register = 0x84
+	RegVCOSLVLCTRL  Register = 0x92
+	RegVCOSTIMCTRL  Register = 0x94
+	RegFOXHUNTCTRL  Register = 0xA0
+	RegFOXHUNTMSG0  Register = 0xA2
+	RegFOXHUNTMSG1  Register = 0xA3
+	RegFOXHUNTMSG2  Register = 0xA4
+	RegFOXHUNTMSG3  Register = 0xA5
+)
+
+// Registers lists all known registers in address order
+var Registers = []Register{
+	RegMAGIC, RegUSBID,
+	RegAIOCIOMUX0, RegAIOCIOMUX1,
+	RegCM108IOMUX0, RegCM108IOMUX1, RegCM108IOMUX2, RegCM108IOMUX3,
+	RegSERIALCTRL, RegSERIALIOMUX0, RegSERIALIOMUX1, RegSERIALIOMUX2, RegSERIALIOMUX3,
+	RegAUDIORX, RegAUDIOTX,
+	RegVPTTLVLCTRL, RegVPTTTIMCTRL, RegVCOSLVLCTRL, RegVCOSTIMCTRL,
+	RegFOXHUNTCTRL, RegFOXHUNTMSG0, RegFOXHUNTMSG1, RegFOXHUNTMSG2, RegFOXHUNTMSG3,
+}
+
+// registerNames are the register names used by the AIOC firmware
+var registerNames = map[Register]string{
+	RegMAGIC:        "MAGIC",
+	RegUSBID:        "USBID",
+	RegAIOCIOMUX0:   "AIOC_IOMUX0",
+	RegAIOCIOMUX1:   "AIOC_IOMUX1",
+	RegCM108IOMUX0:  "CM108_IOMUX0",
+	RegCM108IOMUX1:  "CM108_IOMUX1",
+	RegCM108IOMUX2:  "CM108_IOMUX2",
+	RegCM108IOMUX3:  "CM108_IOMUX3",
+	RegSERIALCTRL:   "SERIAL_CTRL",
+	RegSERIALIOMUX0: "SERIAL_IOMUX0",
+	RegSERIALIOMUX1: "SERIAL_IOMUX1",
+	RegSERIALIOMUX2: "SERIAL_IOMUX2",
+	RegSERIALIOMUX3: "SERIAL_IOMUX3",
+	RegAUDIORX:      "AUDIO_RX",
+	RegAUDIOTX:      "AUDIO_TX",
+	RegVPTTLVLCTRL:  "VPTT_LVLCTRL",
+	RegVPTTTIMCTRL:  "VPTT_TIMCTRL",
+	RegVCOSLVLCTRL:  "VCOS_LVLCTRL",
+	RegVCOSTIMCTRL:  "VCOS_TIMCTRL",
+	RegFOXHUNTCTRL:  "FOXHUNT_CTRL",
+	RegFOXHUNTMSG0:  "FOXHUNT_MSG0",
+	RegFOXHUNTMSG1:  "FOXHUNT_MSG1",
+	RegFOXHUNTMSG2:  "FOXHUNT_MSG2",
+	RegFOXHUNTMSG3:  "FOXHUNT_MSG3",
+}
+
+// String returns the firmware name of the register, or its address for
+// unknown registers
+func (r Register) String() string {
+	if name, ok := registerNames[r]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%02x", uint8(r))
+}
+
+// ParseRegister parses a register name such as "AUDIO_RX" (case
+// insensitive) or an address such as "0x72"
+func ParseRegister(s string) (Register, error) {
+	name := strings.ToUpper(strings.TrimSpace(s))
+	for r, n := range registerNames {
+		if n == name {
+			return r, nil
+		}
+	}
+	if hex, ok := strings.CutPrefix(name, "0X"); ok {
+		if addr, err := strconv.ParseUint(hex, 16, 8); err == nil {
+			return Register(addr), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown register: %s", s)
+}
+
+// Describe decodes a raw register value into a human readable form. Values
+// of registers without a known layout are shown in hex.
+func (r Register) Describe(val uint32) string {
+	switch r {
+	case RegMAGIC:
+		return fmt.Sprintf("%q", DecodeMagic(val))
+	case RegUSBID:
+		vid, pid := DecodeUSBID(val)
+		return fmt.Sprintf("VID 0x%04x, PID 0x%04x", vid, pid)
+	case RegAIOCIOMUX0, RegAIOCIOMUX1:
+		return PTTSource(val).String()
+	case RegCM108IOMUX0, RegCM108IOMUX1, RegCM108IOMUX2, RegCM108IOMUX3:
+		return CM108ButtonSource(val).String()
+	case RegAUDIORX:
+		return "RX gain " + RXGain(val).String()
+	case RegAUDIOTX:
+		return "TX boost " + TXBoost(val).String()
+	case RegVPTTLVLCTRL, RegVCOSLVLCTRL:
+		return fmt.Sprintf("threshold %d", DecodeThreshold(val))
+	case RegVPTTTIMCTRL, RegVCOSTIMCTRL:
+		return fmt.Sprintf("timeout %s", DecodeTimeout(val))
+	case RegFOXHUNTCTRL:
+		c := DecodeFoxhuntCtrl(val)
+		return fmt.Sprintf("volume %d, %d WPM, interval %d s", c.Volume, c.WPM, c.Interval)
+	case RegFOXHUNTMSG0, RegFOXHUNTMSG1, RegFOXHUNTMSG2, RegFOXHUNTMSG3:
+		b := []byte{byte(val), byte(val >> 8), byte(val >> 16), byte(val >> 24)}
+		return fmt.Sprintf("%q", strings.TrimRight(string(b), "\x00"))
+	}
+	return fmt.Sprintf("0x%08x", val)
+}
+
+// Command is a command sent in the command byte of a feature report
+type Command uint8
+
+// Commands
+const (
+	CmdNONE        Command = 0x00
+	CmdWRITESTROBE Command = 0x01
+	CmdDEFAULTS    Command = 0x10
+	CmdREBOOT      Command = 0x20
+	CmdRECALL      Command = 0x40
+	CmdSTORE       Command = 0x80
+)
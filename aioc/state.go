@@ -0,0 +1,391 @@
+package aioc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// USBIdentity is the VID/PID the AIOC enumerates with (USBID)
+type USBIdentity struct {
+	VID, PID uint16
+}
+
+// PTTRouting selects the inputs keying each PTT output (AIOC_IOMUX0/1)
+type PTTRouting struct {
+	PTT1, PTT2 PTTSource
+}
+
+// CM108Buttons selects the inputs pressing each CM108 HID button
+// (CM108_IOMUX0-3)
+type CM108Buttons struct {
+	VolUp, VolDown, PlayMute, RecordMute CM108ButtonSource
+}
+
+// SerialConfig holds the SERIAL_CTRL and SERIAL_IOMUX0-3 registers. Their
+// layout is not documented, so the raw values are kept.
+type SerialConfig struct {
+	Ctrl  uint32
+	IOMUX [4]uint32
+}
+
+// LevelDetector is the threshold and tail time of the virtual PTT or
+// virtual COS detector (VPTT_*/VCOS_* LVLCTRL and TIMCTRL)
+type LevelDetector struct {
+	Threshold int
+	Timeout   time.Duration
+}
+
+// AudioConfig holds the AUDIO_RX and AUDIO_TX registers
+type AudioConfig struct {
+	RXGain  RXGain
+	TXBoost TXBoost
+}
+
+// Foxhunt holds FOXHUNT_CTRL and the FOXHUNT_MSG registers
+type Foxhunt struct {
+	FoxhuntCtrl
+	Message string
+}
+
+// DeviceState is the configuration of an AIOC, decoded from its registers.
+// DeviceState values are comparable with ==.
+type DeviceState struct {
+	USB     USBIdentity
+	PTT     PTTRouting
+	Buttons CM108Buttons
+	Serial  SerialConfig
+	VPTT    LevelDetector
+	VCOS    LevelDetector
+	Audio   AudioConfig
+	Foxhunt Foxhunt
+}
+
+// stateRegisters are the registers making up a DeviceState
+var stateRegisters = []Register{
+	RegUSBID,
+	RegAIOCIOMUX0, RegAIOCIOMUX1,
+	RegCM108IOMUX0, RegCM108IOMUX1, RegCM108IOMUX2, RegCM108IOMUX3,
+	RegSERIALCTRL, RegSERIALIOMUX0, RegSERIALIOMUX1, RegSERIALIOMUX2, RegSERIALIOMUX3,
+	RegAUDIORX, RegAUDIOTX,
+	RegVPTTLVLCTRL, RegVPTTTIMCTRL, RegVCOSLVLCTRL, RegVCOSTIMCTRL,
+	RegFOXHUNTCTRL, RegFOXHUNTMSG0, RegFOXHUNTMSG1, RegFOXHUNTMSG2, RegFOXHUNTMSG3,
+}
+
+// DecodeState builds a DeviceState from register values, e.g. those
+// returned by ReadRegisters. Missing registers decode as zero.
+func DecodeState(vals map[Register]uint32) DeviceState {
+	var s DeviceState
+	s.USB.VID, s.USB.PID = DecodeUSBID(vals[RegUSBID])
+	s.PTT = PTTRouting{PTTSource(vals[RegAIOCIOMUX0]), PTTSource(vals[RegAIOCIOMUX1])}
+	s.Buttons = CM108Buttons{
+		CM108ButtonSource(vals[RegCM108IOMUX0]), CM108ButtonSource(vals[RegCM108IOMUX1]),
+		CM108ButtonSource(vals[RegCM108IOMUX2]), CM108ButtonSource(vals[RegCM108IOMUX3]),
+	}
+	s.Serial.Ctrl = vals[RegSERIALCTRL]
+	s.Serial.IOMUX = [4]uint32{vals[RegSERIALIOMUX0], vals[RegSERIALIOMUX1], vals[RegSERIALIOMUX2], vals[RegSERIALIOMUX3]}
+	s.Audio = AudioConfig{RXGain(vals[RegAUDIORX]), TXBoost(vals[RegAUDIOTX])}
+	s.VPTT = LevelDetector{DecodeThreshold(vals[RegVPTTLVLCTRL]), DecodeTimeout(vals[RegVPTTTIMCTRL])}
+	s.VCOS = LevelDetector{DecodeThreshold(vals[RegVCOSLVLCTRL]), DecodeTimeout(vals[RegVCOSTIMCTRL])}
+	s.Foxhunt.FoxhuntCtrl = DecodeFoxhuntCtrl(vals[RegFOXHUNTCTRL])
+	var msg [4]uint32
+	for i, reg := range FoxhuntMessageRegisters {
+		msg[i] = vals[reg]
+	}
+	s.Foxhunt.Message = DecodeFoxhuntMessage(msg)
+	return s
+}
+
+// Registers encodes the state into register values from scratch: every
+// field is validated and bits no field covers are zero. WriteState uses
+// RegistersFrom instead, which keeps them.
+func (s DeviceState) Registers() (map[Register]uint32, error) {
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	vals := map[Register]uint32{
+		RegUSBID:        EncodeUSBID(s.USB.VID, s.USB.PID),
+		RegAIOCIOMUX0:   uint32(s.PTT.PTT1),
+		RegAIOCIOMUX1:   uint32(s.PTT.PTT2),
+		RegCM108IOMUX0:  uint32(s.Buttons.VolUp),
+		RegCM108IOMUX1:  uint32(s.Buttons.VolDown),
+		RegCM108IOMUX2:  uint32(s.Buttons.PlayMute),
+		RegCM108IOMUX3:  uint32(s.Buttons.RecordMute),
+		RegSERIALCTRL:   s.Serial.Ctrl,
+		RegSERIALIOMUX0: s.Serial.IOMUX[0],
+		RegSERIALIOMUX1: s.Serial.IOMUX[1],
+		RegSERIALIOMUX2: s.Serial.IOMUX[2],
+		RegSERIALIOMUX3: s.Serial.IOMUX[3],
+		RegAUDIORX:      uint32(s.Audio.RXGain),
+		RegAUDIOTX:      uint32(s.Audio.TXBoost),
+	}
+	// Validate has checked the ranges, so the encoders cannot fail
+	vals[RegVPTTLVLCTRL], _ = EncodeThreshold(s.VPTT.Threshold)
+	vals[RegVPTTTIMCTRL], _ = EncodeTimeout(s.VPTT.Timeout)
+	vals[RegVCOSLVLCTRL], _ = EncodeThreshold(s.VCOS.Threshold)
+	vals[RegVCOSTIMCTRL], _ = EncodeTimeout(s.VCOS.Timeout)
+	vals[RegFOXHUNTCTRL], _ = s.Foxhunt.Encode()
+	msg, _ := EncodeFoxhuntMessage(s.Foxhunt.Message)
+	for i, reg := range FoxhuntMessageRegisters {
+		vals[reg] = msg[i]
+	}
+	return vals, nil
+}
+
+// RegistersFrom encodes the state on top of the register values base, e.g.
+// those read from the device. A register whose fields equal their decoded
+// base value keeps its raw base value, and a changed register keeps the
+// base bits no field covers, so a state read from the device writes back
+// unchanged. Only the changed fields are validated.
+func (s DeviceState) RegistersFrom(base map[Register]uint32) (map[Register]uint32, error) {
+	cur := DecodeState(base)
+	if err := s.changes(cur).Validate(); err != nil {
+		return nil, err
+	}
+	vals := make(map[Register]uint32, len(stateRegisters))
+	for _, reg := range stateRegisters {
+		vals[reg] = base[reg]
+	}
+	// Validate has checked the changed fields and decoded ones always fit,
+	// so the encoders cannot fail
+	set := func(reg Register, mask, val uint32) {
+		vals[reg] = base[reg]&^mask | val&mask
+	}
+	// These fields hold the whole raw register value, so an unchanged one
+	// encodes to its base value
+	const all = 0xFFFFFFFF
+	set(RegUSBID, all, EncodeUSBID(s.USB.VID, s.USB.PID))
+	set(RegAIOCIOMUX0, all, uint32(s.PTT.PTT1))
+	set(RegAIOCIOMUX1, all, uint32(s.PTT.PTT2))
+	set(RegCM108IOMUX0, all, uint32(s.Buttons.VolUp))
+	set(RegCM108IOMUX1, all, uint32(s.Buttons.VolDown))
+	set(RegCM108IOMUX2, all, uint32(s.Buttons.PlayMute))
+	set(RegCM108IOMUX3, all, uint32(s.Buttons.RecordMute))
+	set(RegSERIALCTRL, all, s.Serial.Ctrl)
+	for i, reg := range []Register{RegSERIALIOMUX0, RegSERIALIOMUX1, RegSERIALIOMUX2, RegSERIALIOMUX3} {
+		set(reg, all, s.Serial.IOMUX[i])
+	}
+	set(RegAUDIORX, all, uint32(s.Audio.RXGain))
+	set(RegAUDIOTX, all, uint32(s.Audio.TXBoost))
+	ctrl, _ := s.Foxhunt.Encode()
+	set(RegFOXHUNTCTRL, all, ctrl)
+	for _, det := range []struct {
+		new, cur         LevelDetector
+		lvlctrl, timctrl Register
+	}{{s.VPTT, cur.VPTT, RegVPTTLVLCTRL, RegVPTTTIMCTRL}, {s.VCOS, cur.VCOS, RegVCOSLVLCTRL, RegVCOSTIMCTRL}} {
+		if det.new.Threshold != det.cur.Threshold {
+			val, _ := EncodeThreshold(det.new.Threshold)
+			set(det.lvlctrl, thresholdMask, val)
+		}
+		if det.new.Timeout != det.cur.Timeout {
+			val, _ := EncodeTimeout(det.new.Timeout)
+			set(det.timctrl, timeoutMask, val)
+		}
+	}
+	// The bytes after the NUL are not part of the message, so an unchanged
+	// message keeps them
+	if s.Foxhunt.Message != cur.Foxhunt.Message {
+		msg, _ := EncodeFoxhuntMessage(s.Foxhunt.Message)
+		for i, reg := range FoxhuntMessageRegisters {
+			set(reg, all, msg[i])
+		}
+	}
+	return vals, nil
+}
+
+// changes returns s with the fields equal to those of cur reset to zero,
+// which is always valid, so that Validate only checks the changed ones
+func (s DeviceState) changes(cur DeviceState) DeviceState {
+	var c DeviceState
+	if s.PTT.PTT1 != cur.PTT.PTT1 {
+		c.PTT.PTT1 = s.PTT.PTT1
+	}
+	if s.PTT.PTT2 != cur.PTT.PTT2 {
+		c.PTT.PTT2 = s.PTT.PTT2
+	}
+	if s.Buttons.VolUp != cur.Buttons.VolUp {
+		c.Buttons.VolUp = s.Buttons.VolUp
+	}
+	if s.Buttons.VolDown != cur.Buttons.VolDown {
+		c.Buttons.VolDown = s.Buttons.VolDown
+	}
+	if s.Buttons.PlayMute != cur.Buttons.PlayMute {
+		c.Buttons.PlayMute = s.Buttons.PlayMute
+	}
+	if s.Buttons.RecordMute != cur.Buttons.RecordMute {
+		c.Buttons.RecordMute = s.Buttons.RecordMute
+	}
+	if s.Audio.RXGain != cur.Audio.RXGain {
+		c.Audio.RXGain = s.Audio.RXGain
+	}
+	if s.Audio.TXBoost != cur.Audio.TXBoost {
+		c.Audio.TXBoost = s.Audio.TXBoost
+	}
+	if s.VPTT != cur.VPTT {
+		c.VPTT = s.VPTT
+	}
+	if s.VCOS != cur.VCOS {
+		c.VCOS = s.VCOS
+	}
+	if s.Foxhunt.FoxhuntCtrl != cur.Foxhunt.FoxhuntCtrl {
+		c.Foxhunt.FoxhuntCtrl = s.Foxhunt.FoxhuntCtrl
+	}
+	if s.Foxhunt.Message != cur.Foxhunt.Message {
+		c.Foxhunt.Message = s.Foxhunt.Message
+	}
+	return c
+}
+
+// Validate checks that every field has a value the firmware accepts. All
+// problems are reported, joined into one error.
+func (s DeviceState) Validate() error {
+	var errs []error
+	check := func(err error) {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	var known PTTSource
+	for _, flag := range PTTSources {
+		known |= flag
+	}
+	for i, src := range []PTTSource{s.PTT.PTT1, s.PTT.PTT2} {
+		if src&^known != 0 {
+			check(fmt.Errorf("PTT%d source has unknown bits 0x%08x", i+1, uint32(src&^known)))
+		}
+	}
+	for i, src := range []CM108ButtonSource{s.Buttons.VolUp, s.Buttons.VolDown, s.Buttons.PlayMute, s.Buttons.RecordMute} {
+		const known = CM108ButtonSourceIN1 | CM108ButtonSourceIN2 | CM108ButtonSourceVCOS
+		if src&^known != 0 {
+			check(fmt.Errorf("CM108 button %d source has unknown bits 0x%08x", i+1, uint32(src&^known)))
+		}
+	}
+	if s.Audio.RXGain.String() == "unknown" {
+		check(fmt.Errorf("invalid RX gain 0x%08x", uint32(s.Audio.RXGain)))
+	}
+	if s.Audio.TXBoost.String() == "unknown" {
+		check(fmt.Errorf("invalid TX boost 0x%08x", uint32(s.Audio.TXBoost)))
+	}
+	for _, det := range []struct {
+		name string
+		LevelDetector
+	}{{"VPTT", s.VPTT}, {"VCOS", s.VCOS}} {
+		if _, err := EncodeThreshold(det.Threshold); err != nil {
+			check(fmt.Errorf("%s: %w", det.name, err))
+		}
+		if _, err := EncodeTimeout(det.Timeout); err != nil {
+			check(fmt.Errorf("%s: %w", det.name, err))
+		}
+		if det.Timeout%time.Millisecond != 0 {
+			check(fmt.Errorf("%s: timeout %s is not a whole number of milliseconds", det.name, det.Timeout))
+		}
+	}
+	if _, err := s.Foxhunt.Encode(); err != nil {
+		check(fmt.Errorf("foxhunt: %w", err))
+	}
+	if _, err := EncodeFoxhuntMessage(s.Foxhunt.Message); err != nil {
+		check(fmt.Errorf("foxhunt: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+// Equal reports whether two states configure the device identically
+func (s DeviceState) Equal(o DeviceState) bool {
+	return s == o
+}
+
+// Change is one field that differs between two states
+type Change struct {
+	Field    string // e.g. "PTT.PTT1" or "Foxhunt.WPM"
+	Old, New string
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s: %s -> %s", c.Field, c.Old, c.New)
+}
+
+// fields lists the state as named, formatted values in a fixed order
+func (s DeviceState) fields() [][2]string {
+	return [][2]string{
+		{"USB.VID", fmt.Sprintf("0x%04x", s.USB.VID)},
+		{"USB.PID", fmt.Sprintf("0x%04x", s.USB.PID)},
+		{"PTT.PTT1", s.PTT.PTT1.String()},
+		{"PTT.PTT2", s.PTT.PTT2.String()},
+		{"Buttons.VolUp", s.Buttons.VolUp.String()},
+		{"Buttons.VolDown", s.Buttons.VolDown.String()},
+		{"Buttons.PlayMute", s.Buttons.PlayMute.String()},
+		{"Buttons.RecordMute", s.Buttons.RecordMute.String()},
+		{"Serial.Ctrl", fmt.Sprintf("0x%08x", s.Serial.Ctrl)},
+		{"Serial.IOMUX0", fmt.Sprintf("0x%08x", s.Serial.IOMUX[0])},
+		{"Serial.IOMUX1", fmt.Sprintf("0x%08x", s.Serial.IOMUX[1])},
+		{"Serial.IOMUX2", fmt.Sprintf("0x%08x", s.Serial.IOMUX[2])},
+		{"Serial.IOMUX3", fmt.Sprintf("0x%08x", s.Serial.IOMUX[3])},
+		{"VPTT.Threshold", fmt.Sprint(s.VPTT.Threshold)},
+		{"VPTT.Timeout", s.VPTT.Timeout.String()},
+		{"VCOS.Threshold", fmt.Sprint(s.VCOS.Threshold)},
+		{"VCOS.Timeout", s.VCOS.Timeout.String()},
+		{"Audio.RXGain", s.Audio.RXGain.String()},
+		{"Audio.TXBoost", s.Audio.TXBoost.String()},
+		{"Foxhunt.Volume", fmt.Sprint(s.Foxhunt.Volume)},
+		{"Foxhunt.WPM", fmt.Sprint(s.Foxhunt.WPM)},
+		{"Foxhunt.Interval", fmt.Sprint(s.Foxhunt.Interval)},
+		{"Foxhunt.Message", fmt.Sprintf("%q", s.Foxhunt.Message)},
+	}
+}
+
+// Diff returns the fields that differ from one state to another, in a
+// fixed order
+func Diff(from, to DeviceState) []Change {
+	var changes []Change
+	of, nf := from.fields(), to.fields()
+	for i := range of {
+		if of[i][1] != nf[i][1] {
+			changes = append(changes, Change{Field: of[i][0], Old: of[i][1], New: nf[i][1]})
+		}
+	}
+	return changes
+}
+
+// ReadState reads and decodes the device configuration
+func (d *Device) ReadState() (DeviceState, error) {
+	return d.ReadStateContext(context.Background())
+}
+
+// ReadStateContext is ReadState with a context covering all reads
+func (d *Device) ReadStateContext(ctx context.Context) (DeviceState, error) {
+	vals, err := d.ReadRegistersContext(ctx, stateRegisters...)
+	if err != nil {
+		return DeviceState{}, err
+	}
+	return DecodeState(vals), nil
+}
+
+// WriteState writes the registers whose fields differ from the device's
+// current configuration, validating only those fields; see RegistersFrom.
+// Like Write, the changes are lost on power-down unless stored with
+// CmdSTORE. A changed USB identity takes effect after a reboot.
+func (d *Device) WriteState(s DeviceState) error {
+	return d.WriteStateContext(context.Background(), s)
+}
+
+// WriteStateContext is WriteState with a context covering all transfers
+func (d *Device) WriteStateContext(ctx context.Context, s DeviceState) error {
+	have, err := d.ReadRegistersContext(ctx, stateRegisters...)
+	if err != nil {
+		return err
+	}
+	want, err := s.RegistersFrom(have)
+	if err != nil {
+		return fmt.Errorf("invalid state: %w", err)
+	}
+	for _, reg := range stateRegisters {
+		if have[reg] == want[reg] {
+			continue
+		}
+		if err := d.WriteContext(ctx, reg, want[reg]); err != nil {
+			return fmt.Errorf("failed to write %s: %w", reg, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package aioc
+
+import (
+	"testing"
+	"time"
+)
+
+// rawState holds register values with bits outside the modelled fields set
+// and values Validate rejects
+var rawState = map[Register]uint32{
+	RegUSBID:        EncodeUSBID(VendorID, ProductID),
+	RegAIOCIOMUX0:   uint32(PTTSourceCM108GPIO3) | 0x00800000,
+	RegAIOCIOMUX1:   uint32(PTTSourceCM108GPIO4),
+	RegCM108IOMUX0:  uint32(CM108ButtonSourceIN1) | 0x00000001,
+	RegSERIALCTRL:   0x12345678,
+	RegSERIALIOMUX1: 0x00000100,
+	RegAUDIORX:      0x00000007,
+	RegAUDIOTX:      uint32(TXBoostON),
+	RegVPTTLVLCTRL:  0xABCD0040,
+	RegVPTTTIMCTRL:  0x00010032,
+	RegVCOSLVLCTRL:  0x00000020,
+	RegVCOSTIMCTRL:  0x00000064,
+	RegFOXHUNTCTRL:  0x00801401,
+	RegFOXHUNTMSG0:  0x00004F4D, // "MO" NUL followed by a stray byte
+	RegFOXHUNTMSG1:  0x11223344,
+}
+
+func TestRegistersFromUnchanged(t *testing.T) {
+	s := DecodeState(rawState)
+	if err := s.Validate(); err == nil {
+		t.Fatalf("test state is valid, expected unknown bits to be rejected")
+	}
+	vals, err := s.RegistersFrom(rawState)
+	if err != nil {
+		t.Fatalf("RegistersFrom: %v", err)
+	}
+	for _, reg := range stateRegisters {
+		if vals[reg] != rawState[reg] {
+			t.Errorf("%s: 0x%08x, want 0x%08x", reg, vals[reg], rawState[reg])
+		}
+	}
+}
+
+func TestRegistersFromChanged(t *testing.T) {
+	s := DecodeState(rawState)
+	s.VPTT.Threshold = 0x80
+	s.PTT.PTT2 = PTTSourceVPTT
+	vals, err := s.RegistersFrom(rawState)
+	if err != nil {
+		t.Fatalf("RegistersFrom: %v", err)
+	}
+	want := map[Register]uint32{
+		RegVPTTLVLCTRL: 0xABCD0080, // bits above the threshold kept
+		RegAIOCIOMUX1:  uint32(PTTSourceVPTT),
+		RegAIOCIOMUX0:  rawState[RegAIOCIOMUX0],
+		RegAUDIORX:     rawState[RegAUDIORX],
+		RegFOXHUNTMSG1: rawState[RegFOXHUNTMSG1],
+	}
+	for reg, w := range want {
+		if vals[reg] != w {
+			t.Errorf("%s: 0x%08x, want 0x%08x", reg, vals[reg], w)
+		}
+	}
+
+	s.Foxhunt.Message = "TOM"
+	if vals, err = s.RegistersFrom(rawState); err != nil {
+		t.Fatalf("RegistersFrom: %v", err)
+	}
+	if got := DecodeFoxhuntMessage([4]uint32{vals[RegFOXHUNTMSG0], vals[RegFOXHUNTMSG1], vals[RegFOXHUNTMSG2], vals[RegFOXHUNTMSG3]}); got != "TOM" || vals[RegFOXHUNTMSG1] != 0 {
+		t.Errorf("message %q, FOXHUNT_MSG1 0x%08x", got, vals[RegFOXHUNTMSG1])
+	}
+}
+
+func TestRegistersFromInvalidChange(t *testing.T) {
+	s := DecodeState(rawState)
+	s.Audio.RXGain = 9
+	s.VCOS.Timeout = 1500 * time.Microsecond
+	if _, err := s.RegistersFrom(rawState); err == nil {
+		t.Errorf("invalid changed fields accepted")
+	}
+}
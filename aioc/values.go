@@ -0,0 +1,371 @@
+package aioc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Default USB IDs of the AIOC
+const (
+	VendorID  = 0x1209
+	ProductID = 0x7388
+)
+
+// Magic is the value of the MAGIC register of every AIOC
+const Magic = "AIOC"
+
+// DecodeMagic returns the MAGIC register as a string
+func DecodeMagic(val uint32) string {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, val)
+	return string(b)
+}
+
+// DecodeUSBID splits the USBID register into VID (bits 15-0) and PID
+// (bits 31-16)
+func DecodeUSBID(val uint32) (vid, pid uint16) {
+	return uint16(val), uint16(val >> 16)
+}
+
+// EncodeUSBID packs a VID and PID into the USBID register
+func EncodeUSBID(vid, pid uint16) uint32 {
+	return uint32(pid)<<16 | uint32(vid)
+}
+
+// PTTSource is the value of the AIOC_IOMUX registers: the set of inputs
+// that key a PTT output
+type PTTSource uint32
+
+// PTT sources
+const (
+	PTTSourceNONE          PTTSource = 0x00000000
+	PTTSourceCM108GPIO1    PTTSource = 0x00000001
+	PTTSourceCM108GPIO2    PTTSource = 0x00000002
+	PTTSourceCM108GPIO3    PTTSource = 0x00000004
+	PTTSourceCM108GPIO4    PTTSource = 0x00000008
+	PTTSourceSERIALDTR     PTTSource = 0x00000100
+	PTTSourceSERIALRTS     PTTSource = 0x00000200
+	PTTSourceSERIALDTRNRTS PTTSource = 0x00000400
+	PTTSourceSERIALNDTRRTS PTTSource = 0x00000800
+	PTTSourceVPTT          PTTSource = 0x00001000
+)
+
+// pttSourceNames lists the PTT source flags in display order
+var pttSourceNames = []struct {
+	src  PTTSource
+	name string
+}{
+	{PTTSourceCM108GPIO1, "CM108GPIO1"},
+	{PTTSourceCM108GPIO2, "CM108GPIO2"},
+	{PTTSourceCM108GPIO3, "CM108GPIO3"},
+	{PTTSourceCM108GPIO4, "CM108GPIO4"},
+	{PTTSourceSERIALDTR, "SERIALDTR"},
+	{PTTSourceSERIALRTS, "SERIALRTS"},
+	{PTTSourceSERIALDTRNRTS, "SERIALDTRNRTS"},
+	{PTTSourceSERIALNDTRRTS, "SERIALNDTRRTS"},
+	{PTTSourceVPTT, "VPTT"},
+}
+
+// PTTSources lists every PTT source flag
+var PTTSources = []PTTSource{
+	PTTSourceCM108GPIO1, PTTSourceCM108GPIO2, PTTSourceCM108GPIO3, PTTSourceCM108GPIO4,
+	PTTSourceSERIALDTR, PTTSourceSERIALRTS, PTTSourceSERIALDTRNRTS, PTTSourceSERIALNDTRRTS,
+	PTTSourceVPTT,
+}
+
+// ParsePTTSource parses a list of PTT source names separated by "|", such
+// as "CM108GPIO1|SERIALDTR"
+func ParsePTTSource(s string) (PTTSource, error) {
+	if s == "" {
+		return 0, nil
+	}
+	var result PTTSource
+	for _, p := range strings.Split(s, "|") {
+		p = strings.TrimSpace(p)
+		if p == "NONE" {
+			continue
+		}
+		found := false
+		for _, n := range pttSourceNames {
+			if n.name == p {
+				result |= n.src
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("unknown PTT source: %s", p)
+		}
+	}
+	return result, nil
+}
+
+// String returns the source names joined by "|", "NONE" for no source, or
+// the raw value if no known flag is set
+func (src PTTSource) String() string {
+	if src == PTTSourceNONE {
+		return "NONE"
+	}
+	var parts []string
+	for _, n := range pttSourceNames {
+		if src&n.src != 0 {
+			parts = append(parts, n.name)
+		}
+	}
+	if len(parts) == 0 {
+		return fmt.Sprintf("0x%08x", uint32(src))
+	}
+	return strings.Join(parts, "|")
+}
+
+// CM108ButtonSource is the value of the CM108_IOMUX registers: the set of
+// inputs that press a CM108 HID button
+type CM108ButtonSource uint32
+
+// CM108 button sources
+const (
+	CM108ButtonSourceNONE CM108ButtonSource = 0x00000000
+	CM108ButtonSourceIN1  CM108ButtonSource = 0x00010000
+	CM108ButtonSourceIN2  CM108ButtonSource = 0x00020000
+	CM108ButtonSourceVCOS CM108ButtonSource = 0x01000000
+)
+
+// cm108ButtonSourceNames lists the button source flags in display order
+var cm108ButtonSourceNames = []struct {
+	src  CM108ButtonSource
+	name string
+}{
+	{CM108ButtonSourceIN1, "IN1"},
+	{CM108ButtonSourceIN2, "IN2"},
+	{CM108ButtonSourceVCOS, "VCOS"},
+}
+
+// ParseCM108ButtonSource parses a list of button source names separated by
+// "|", such as "IN2|VCOS"
+func ParseCM108ButtonSource(s string) (CM108ButtonSource, error) {
+	if s == "" {
+		return 0, nil
+	}
+	var result CM108ButtonSource
+	for _, p := range strings.Split(s, "|") {
+		p = strings.TrimSpace(p)
+		if p == "NONE" {
+			continue
+		}
+		found := false
+		for _, n := range cm108ButtonSourceNames {
+			if n.name == p {
+				result |= n.src
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("unknown button source: %s", p)
+		}
+	}
+	return result, nil
+}
+
+// String returns the source names joined by "|", "NONE" for no source, or
+// the raw value if no known flag is set
+func (src CM108ButtonSource) String() string {
+	if src == CM108ButtonSourceNONE {
+		return "NONE"
+	}
+	var parts []string
+	for _, n := range cm108ButtonSourceNames {
+		if src&n.src != 0 {
+			parts = append(parts, n.name)
+		}
+	}
+	if len(parts) == 0 {
+		return fmt.Sprintf("0x%08x", uint32(src))
+	}
+	return strings.Join(parts, "|")
+}
+
+// RXGain is the value of the AUDIO_RX register
+type RXGain uint32
+
+// RX gain steps, 6 dB apart
+const (
+	RXGain1X  RXGain = 0x00000000
+	RXGain2X  RXGain = 0x00000001
+	RXGain4X  RXGain = 0x00000002
+	RXGain8X  RXGain = 0x00000003
+	RXGain16X RXGain = 0x00000004
+)
+
+// String returns the gain as "1x" to "16x", or "unknown"
+func (g RXGain) String() string {
+	if g > RXGain16X {
+		return "unknown"
+	}
+	return fmt.Sprintf("%dx", 1<<g)
+}
+
+// ParseRXGain parses an RX gain name such as "4x"
+func ParseRXGain(s string) (RXGain, error) {
+	for g := RXGain1X; g <= RXGain16X; g++ {
+		if g.String() == strings.ToLower(s) {
+			return g, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid RX gain %q, use 1x, 2x, 4x, 8x or 16x", s)
+}
+
+// TXBoost is the value of the AUDIO_TX register
+type TXBoost uint32
+
+// TX boost settings
+const (
+	TXBoostOFF TXBoost = 0x00000000
+	TXBoostON  TXBoost = 0x00000100
+)
+
+// String returns "on", "off" or "unknown"
+func (b TXBoost) String() string {
+	switch b {
+	case TXBoostOFF:
+		return "off"
+	case TXBoostON:
+		return "on"
+	}
+	return "unknown"
+}
+
+// ParseTXBoost parses "on" or "off"
+func ParseTXBoost(s string) (TXBoost, error) {
+	switch strings.ToLower(s) {
+	case "off":
+		return TXBoostOFF, nil
+	case "on":
+		return TXBoostON, nil
+	}
+	return 0, fmt.Errorf("invalid TX boost %q, use on or off", s)
+}
+
+// Threshold and timeout fields of the VPTT/VCOS LVLCTRL and TIMCTRL
+// registers
+const (
+	thresholdMask = 0xFFFF
+	timeoutMask   = 0xFFFF
+)
+
+// DecodeThreshold returns the level threshold of a VPTT_LVLCTRL or
+// VCOS_LVLCTRL value, on the absolute 16-bit sample value
+func DecodeThreshold(val uint32) int {
+	return int(val & thresholdMask)
+}
+
+// EncodeThreshold returns the LVLCTRL value for a level threshold
+func EncodeThreshold(threshold int) (uint32, error) {
+	if err := checkRange("threshold", threshold, thresholdMask); err != nil {
+		return 0, err
+	}
+	return uint32(threshold), nil
+}
+
+// DecodeTimeout returns the tail time of a VPTT_TIMCTRL or VCOS_TIMCTRL
+// value
+func DecodeTimeout(val uint32) time.Duration {
+	return time.Duration(val&timeoutMask) * time.Millisecond
+}
+
+// EncodeTimeout returns the TIMCTRL value for a tail time, in whole
+// milliseconds
+func EncodeTimeout(d time.Duration) (uint32, error) {
+	ms := int(d.Milliseconds())
+	if err := checkRange("timeout in ms", ms, timeoutMask); err != nil {
+		return 0, err
+	}
+	return uint32(ms), nil
+}
+
+// FoxhuntCtrl is the decoded FOXHUNT_CTRL register
+type FoxhuntCtrl struct {
+	Volume   int // bits 31-16
+	WPM      int // bits 15-8
+	Interval int // bits 7-0, seconds between transmissions, 0 disables
+}
+
+// DecodeFoxhuntCtrl splits FOXHUNT_CTRL into its fields
+func DecodeFoxhuntCtrl(val uint32) FoxhuntCtrl {
+	return FoxhuntCtrl{
+		Volume:   int((val >> 16) & 0xFFFF),
+		WPM:      int((val >> 8) & 0xFF),
+		Interval: int(val & 0xFF),
+	}
+}
+
+// Encode packs the fields into FOXHUNT_CTRL, rejecting values that do not
+// fit their bitfields
+func (c FoxhuntCtrl) Encode() (uint32, error) {
+	if err := checkRange("volume", c.Volume, 0xFFFF); err != nil {
+		return 0, err
+	}
+	if err := checkRange("wpm", c.WPM, 0xFF); err != nil {
+		return 0, err
+	}
+	if err := checkRange("interval", c.Interval, 0xFF); err != nil {
+		return 0, err
+	}
+	return uint32(c.Volume)<<16 | uint32(c.WPM)<<8 | uint32(c.Interval), nil
+}
+
+// FoxhuntMessageRegisters hold the foxhunt message, four bytes each
+var FoxhuntMessageRegisters = [4]Register{RegFOXHUNTMSG0, RegFOXHUNTMSG1, RegFOXHUNTMSG2, RegFOXHUNTMSG3}
+
+// FoxhuntMessageSize is the maximum length of the foxhunt message
+const FoxhuntMessageSize = 16
+
+// EncodeFoxhuntMessage splits a message into the FOXHUNT_MSG register
+// values, padding it with NULs
+func EncodeFoxhuntMessage(msg string) ([4]uint32, error) {
+	var vals [4]uint32
+	if err := checkRange("message length", len(msg), FoxhuntMessageSize); err != nil {
+		return vals, err
+	}
+	buf := make([]byte, FoxhuntMessageSize)
+	copy(buf, msg)
+	for i := range vals {
+		vals[i] = binary.LittleEndian.Uint32(buf[i*4:])
+	}
+	return vals, nil
+}
+
+// DecodeFoxhuntMessage joins the FOXHUNT_MSG register values into the NUL
+// terminated message
+func DecodeFoxhuntMessage(vals [4]uint32) string {
+	buf := make([]byte, FoxhuntMessageSize)
+	for i, v := range vals {
+		binary.LittleEndian.PutUint32(buf[i*4:], v)
+	}
+	for i, b := range buf {
+		if b == 0 {
+			return string(buf[:i])
+		}
+	}
+	return string(buf)
+}
+
+// PTT channels for SetPTTState: the CM108 GPIO number keyed by PTT1/PTT2
+const (
+	PTTChannel1 = 3
+	PTTChannel2 = 4
+)
+
+// CM108GPIO is a set of CM108 GPIO bits as used in the HID output report
+type CM108GPIO uint8
+
+// CM108 GPIOs
+const (
+	CM108GPIO1   CM108GPIO = 0x01
+	CM108GPIO2   CM108GPIO = 0x02
+	CM108GPIO3   CM108GPIO = 0x04
+	CM108GPIO4   CM108GPIO = 0x08
+	CM108GPIOAll CM108GPIO = 0x0F
+)
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/rampa069/aioc-util/aioc"
+	"github.com/rampa069/aioc-util/morse"
+)
+
+// ardfMessages are the standard ARDF identifiers of foxes 1 to 5
+var ardfMessages = []string{"MOE", "MOI", "MOS", "MOH", "MO5"}
+
+// ardfFox is one AIOC taking part in the ARDF cycle
+type ardfFox struct {
+	serial  string
+	message string
+	dev     *aioc.Device // nil in dry-run mode
+	active  uint32       // FOXHUNT_CTRL while the fox is in its slot
+	idle    uint32       // FOXHUNT_CTRL outside its slot (interval 0)
+}
+
+// setActive enables or disables the beacon by rewriting FOXHUNT_CTRL. The
+// change is not stored to flash.
+func (f *ardfFox) setActive(on bool) error {
+	ctrl := f.idle
+	if on {
+		ctrl = f.active
+	}
+	return f.dev.Write(aioc.RegFOXHUNTCTRL, ctrl)
+}
+
+// ardfInterval returns the FOXHUNT_CTRL interval that keeps a fox sending
+// message back to back at wpm, leaving one second between repetitions
+func ardfInterval(message string, wpm int) (int, error) {
+	d, err := morse.Duration(message, wpm)
+	if err != nil {
+		return 0, err
+	}
+	interval := int(math.Ceil(d.Seconds())) + 1
+	if interval > 0xFF {
+		return 0, fmt.Errorf("message '%s' takes %.1f s at %d WPM, too long for the interval register", message, d.Seconds(), wpm)
+	}
+	return interval, nil
+}
+
+// runARDF implements "aioc-util ardf"
+func runARDF(args []string) {
+	fs := flag.NewFlagSet("ardf", flag.ExitOnError)
+	serials := fs.String("serials", "", "Comma separated USB serial numbers of the foxes, in transmit order")
+	messages := fs.String("messages", "", "Comma separated fox messages (default: MOE,MOI,MOS,MOH,MO5)")
+	slot := fs.Duration("slot", time.Minute, "Transmit slot of each fox")
+	start := fs.String("start", "", "Start of the first cycle, HH:MM[:SS] or RFC 3339 (default: next full minute)")
+	cycles := fs.Int("cycles", 0, "Number of cycles to run (0 runs until interrupted)")
+	wpm := fs.Int("wpm", 10, "Morse speed in words per minute (1-255)")
+	volume := fs.Int("volume", 32000, "Foxhunt volume (0-65535)")
+	dryRun := fs.Bool("dry-run", false, "Print the timeline without touching any device")
+	openUSB := fs.String("open-usb", "", "USB VID and PID of the foxes (format: VID,PID)")
+	fs.Parse(args)
+
+	if *serials == "" {
+		fmt.Fprintf(os.Stderr, "--serials is required\n")
+		os.Exit(1)
+	}
+	serialList := strings.Split(*serials, ",")
+	// An empty serial would open whichever AIOC comes first, possibly
+	// another fox, and a repeated one would put a fox in two slots
+	seen := make(map[string]bool)
+	for i, serial := range serialList {
+		serial = strings.TrimSpace(serial)
+		if serial == "" {
+			fmt.Fprintf(os.Stderr, "Empty serial number for fox %d in --serials\n", i+1)
+			os.Exit(1)
+		}
+		if seen[serial] {
+			fmt.Fprintf(os.Stderr, "Serial number %s appears more than once in --serials\n", serial)
+			os.Exit(1)
+		}
+		seen[serial] = true
+		serialList[i] = serial
+	}
+	messageList := ardfMessages
+	if *messages != "" {
+		messageList = strings.Split(*messages, ",")
+	}
+	if len(serialList) > len(messageList) {
+		fmt.Fprintf(os.Stderr, "%d foxes but only %d messages, use --messages\n", len(serialList), len(messageList))
+		os.Exit(1)
+	}
+	if *slot < time.Second {
+		fmt.Fprintf(os.Stderr, "Invalid --slot value: %s\n", *slot)
+		os.Exit(1)
+	}
+	// A speed of 0 would never send, and FOXHUNT_CTRL holds 8 bits
+	if *wpm < 1 || *wpm > 0xFF {
+		fmt.Fprintf(os.Stderr, "Invalid --wpm value: %d (use 1-255)\n", *wpm)
+		os.Exit(1)
+	}
+
+	now := time.Now()
+	startTime := now.Truncate(time.Minute).Add(time.Minute)
+	if *start != "" {
+		var err error
+		startTime, err = parseClockTime(*start, now)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid --start value: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	foxes := make([]*ardfFox, len(serialList))
+	for i, serial := range serialList {
+		msg, warnings, err := validateFoxhuntMessage(strings.TrimSpace(messageList[i]))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid message for fox %d: %v\n", i+1, err)
+			os.Exit(1)
+		}
+		// ARDF identifiers are not callsigns, only report real problems
+		for _, w := range warnings {
+			if !strings.Contains(w, "callsign") {
+				fmt.Fprintf(os.Stderr, "Warning: fox %d: %s\n", i+1, w)
+			}
+		}
+		interval, err := ardfInterval(msg, *wpm)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Fox %d: %v\n", i+1, err)
+			os.Exit(1)
+		}
+		active, err := aioc.FoxhuntCtrl{Volume: *volume, WPM: *wpm, Interval: interval}.Encode()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid foxhunt settings: %v\n", err)
+			os.Exit(1)
+		}
+		idle, _ := aioc.FoxhuntCtrl{Volume: *volume, WPM: *wpm}.Encode()
+		foxes[i] = &ardfFox{serial: serial, message: msg, active: active, idle: idle}
+	}
+
+	cycle := *slot * time.Duration(len(foxes))
+	fmt.Printf("ARDF cycle: %d foxes, %s slots, %s per cycle, starting %s\n",
+		len(foxes), *slot, cycle, startTime.Format("2006-01-02 15:04:05"))
+
+	if *dryRun {
+		shown := *cycles
+		if shown == 0 {
+			shown = 1
+		}
+		for c := 0; c < shown; c++ {
+			for i, fox := range foxes {
+				from := startTime.Add(time.Duration(c)*cycle + time.Duration(i)*(*slot))
+				fmt.Printf("  %s - %s  fox %d (%s) sends '%s' every %d s\n",
+					from.Format("15:04:05"), from.Add(*slot).Format("15:04:05"), i+1, fox.serial, fox.message,
+					aioc.DecodeFoxhuntCtrl(fox.active).Interval)
+			}
+		}
+		return
+	}
+
+	vid, pid := aioc.VendorID, aioc.ProductID
+	if *openUSB != "" {
+		var err error
+		vid, pid, err = parseUSBPair(*openUSB)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid --open-usb value: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	disableAll := func() {
+		for i, fox := range foxes {
+			if fox.dev == nil {
+				continue
+			}
+			if err := fox.setActive(false); err != nil {
+				log.Printf("Failed to disable fox %d: %v", i+1, err)
+			}
+			fox.dev.Close()
+		}
+	}
+
+	for i, fox := range foxes {
+		dev, err := aioc.OpenSerial(uint16(vid), uint16(pid), fox.serial)
+		if err != nil {
+			disableAll()
+			fmt.Fprintf(os.Stderr, "Could not open fox %d: %v\n", i+1, err)
+			printOpenHint(err)
+			os.Exit(1)
+		}
+		fox.dev = dev
+		caps, err := dev.Capabilities()
+		if err == nil {
+			err = caps.Check(aioc.CapFoxhunt)
+		}
+		if errors.Is(err, aioc.ErrUnknownFirmware) {
+			log.Printf("Warning: fox %d: %v", i+1, err)
+		} else if err != nil {
+			disableAll()
+			fmt.Fprintf(os.Stderr, "Fox %d: %v\n", i+1, err)
+			os.Exit(1)
+		}
+		if err := dev.WriteFoxhuntMessage(fox.message); err != nil {
+			disableAll()
+			fmt.Fprintf(os.Stderr, "Failed to program fox %d: %v\n", i+1, err)
+			os.Exit(1)
+		}
+		if err := fox.setActive(false); err != nil {
+			disableAll()
+			fmt.Fprintf(os.Stderr, "Failed to disable fox %d: %v\n", i+1, err)
+			os.Exit(1)
+		}
+		log.Printf("Fox %d (%s) programmed with '%s'", i+1, fox.serial, fox.message)
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+
+	current := -1
+	for n := 0; *cycles == 0 || n < *cycles*len(foxes); n++ {
+		slotStart := startTime.Add(time.Duration(n) * (*slot))
+		idx := n % len(foxes)
+
+		// Skip slots that are already over, e.g. when started late
+		if time.Now().After(slotStart.Add(*slot)) {
+			continue
+		}
+
+		select {
+		case <-time.After(time.Until(slotStart)):
+		case sig := <-sigs:
+			log.Printf("Received %s, disabling all foxes", sig)
+			disableAll()
+			return
+		}
+
+		if current >= 0 {
+			if err := foxes[current].setActive(false); err != nil {
+				log.Printf("Failed to disable fox %d: %v", current+1, err)
+			}
+		}
+		if err := foxes[idx].setActive(true); err != nil {
+			log.Printf("Failed to enable fox %d: %v", idx+1, err)
+		} else {
+			log.Printf("Fox %d (%s) on air with '%s'", idx+1, foxes[idx].serial, foxes[idx].message)
+		}
+		current = idx
+	}
+
+	// Let the last slot run to its end
+	select {
+	case <-time.After(time.Until(startTime.Add(time.Duration(*cycles) * cycle))):
+	case <-sigs:
+	}
+	log.Printf("ARDF run finished, disabling all foxes")
+	disableAll()
+}
@@ -0,0 +1,432 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/rampa069/aioc-util/aioc"
+	"github.com/rampa069/aioc-util/dsp"
+	"github.com/rampa069/aioc-util/wav"
+)
+
+// rxGains lists the RX gain steps in increasing order, 6 dB apart
+var rxGains = []aioc.RXGain{aioc.RXGain1X, aioc.RXGain2X, aioc.RXGain4X, aioc.RXGain8X, aioc.RXGain16X}
+
+// audioCommands are the subcommands of "aioc-util audio"
+var audioCommands = map[string]func([]string){
+	"analyze": runAudioAnalyze,
+	"tone":    runAudioTone,
+}
+
+// runAudio implements "aioc-util audio"
+func runAudio(args []string) {
+	if len(args) > 0 {
+		if run, ok := audioCommands[args[0]]; ok {
+			run(args[1:])
+			return
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Usage: aioc-util audio <command> [options]\n\n")
+	fmt.Fprintf(os.Stderr, "Commands:\n")
+	fmt.Fprintf(os.Stderr, "  analyze   Measure a capture and recommend RX gain and TX boost\n")
+	fmt.Fprintf(os.Stderr, "  tone      Generate calibration test signals, optionally playing them keyed\n")
+	os.Exit(1)
+}
+
+// readAudioFile reads a WAV file, or raw S16_LE PCM with the given rate and
+// channel count if the file has no RIFF header
+func readAudioFile(path string, rate, channels int) (int, []int16, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	if magic, _ := r.Peek(4); string(magic) == "RIFF" {
+		return wav.Read(r)
+	}
+	samples, err := wav.ReadRaw(r, channels)
+	return rate, samples, err
+}
+
+// audioAdvice is the result of recommendAudio
+type audioAdvice struct {
+	gain  aioc.RXGain
+	boost aioc.TXBoost
+	notes []string
+}
+
+// recommendAudio picks the RX gain that brings the peak of a capture taken
+// at gain closest to target dBFS without going over. In loopback mode
+// (AIOC output fed back to its input) TX boost is toggled when the RX gain
+// range is not enough.
+func recommendAudio(st dsp.Stats, gain aioc.RXGain, boost aioc.TXBoost, loopback bool, target float64) audioAdvice {
+	a := audioAdvice{gain: gain, boost: boost}
+	current := int(gain)
+
+	var shift int
+	switch {
+	case st.ClipFraction() > 0.01:
+		shift = -2
+		a.notes = append(a.notes, "heavy clipping, the true peak is unknown: capture again after changing the gain")
+	case st.Clipped > 0:
+		shift = -1
+		a.notes = append(a.notes, "the capture clips, capture again after changing the gain")
+	case st.Peak == 0:
+		a.notes = append(a.notes, "the capture is silent")
+		return a
+	default:
+		shift = int(math.Floor((target - dsp.DBFS(float64(st.Peak))) / 6.02))
+	}
+
+	want := current + shift
+	switch {
+	case want > len(rxGains)-1:
+		if loopback && boost == aioc.TXBoostOFF {
+			a.boost = aioc.TXBoostON
+			a.notes = append(a.notes, "the level is too low even at 16x, enable TX boost and capture again")
+		} else {
+			a.notes = append(a.notes, "the level is too low even at 16x, raise the audio level at the source")
+		}
+		want = len(rxGains) - 1
+	case want < 0:
+		if loopback && boost == aioc.TXBoostON {
+			a.boost = aioc.TXBoostOFF
+			a.notes = append(a.notes, "the level is too high even at 1x, disable TX boost and capture again")
+		} else {
+			a.notes = append(a.notes, "the level is too high even at 1x, lower the audio level at the source")
+		}
+		want = 0
+	}
+	a.gain = rxGains[want]
+
+	if snr := dsp.DBFS(st.RMS) - dsp.DBFS(st.NoiseFloor); st.NoiseFloor > 0 && snr < 20 {
+		a.notes = append(a.notes, fmt.Sprintf("the noise floor is only %.1f dB below the average level", snr))
+	}
+	if math.Abs(st.DC) > 0.01*dsp.FullScale {
+		a.notes = append(a.notes, fmt.Sprintf("DC offset of %.0f, check the audio wiring", st.DC))
+	}
+	return a
+}
+
+// runAudioAnalyze implements "aioc-util audio analyze"
+func runAudioAnalyze(args []string) {
+	fs := flag.NewFlagSet("audio analyze", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: aioc-util audio analyze [options] FILE\n\n")
+		fmt.Fprintf(fs.Output(), "FILE is a WAV file or raw S16_LE PCM, e.g. from\n")
+		fmt.Fprintf(fs.Output(), "  arecord -D plughw:CARD=AllInOneCable -f S16_LE -r 48000 -d 30 capture.wav\n\n")
+		fs.PrintDefaults()
+	}
+	rate := fs.Int("rate", 48000, "Sample rate of raw PCM input")
+	channels := fs.Int("channels", 1, "Channel count of raw PCM input")
+	gainName := fs.String("gain", "", "RX gain used for the capture (default: read from device)")
+	boostName := fs.String("tx-boost", "", "TX boost (on or off) used for a --loopback capture (default: read from device)")
+	target := fs.Float64("target", -6, "Peak level to aim for in dBFS")
+	loopback := fs.Bool("loopback", false, "The capture is the AIOC's own TX audio looped back, consider TX boost")
+	apply := fs.Bool("apply", false, "Write the recommended settings to the device")
+	store := fs.Bool("store", false, "Store the settings into flash after --apply")
+	openUSB := fs.String("open-usb", "", "USB VID and PID to use when opening (format: VID,PID)")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	path := fs.Arg(0)
+	sampleRate, samples, err := readAudioFile(path, *rate, *channels)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read %s: %v\n", path, err)
+		os.Exit(1)
+	}
+	if len(samples) == 0 {
+		fmt.Fprintf(os.Stderr, "%s contains no audio\n", path)
+		os.Exit(1)
+	}
+
+	st := dsp.Measure(samples, sampleRate)
+	fmt.Printf("%s: %.2f s at %d Hz\n", path, float64(len(samples))/float64(sampleRate), sampleRate)
+	fmt.Printf("Peak: %d (%.1f dBFS)\n", st.Peak, dsp.DBFS(float64(st.Peak)))
+	fmt.Printf("RMS: %.0f (%.1f dBFS)\n", st.RMS, dsp.DBFS(st.RMS))
+	fmt.Printf("Noise floor: %.0f (%.1f dBFS)\n", st.NoiseFloor, dsp.DBFS(st.NoiseFloor))
+	fmt.Printf("Clipping: %d samples (%.3f%%)\n", st.Clipped, st.ClipFraction()*100)
+
+	var dev *aioc.Device
+	device := func() *aioc.Device {
+		if dev == nil {
+			dev = openDevice(*openUSB)
+			requireCapability(dev, aioc.CapAudio)
+		}
+		return dev
+	}
+	defer func() {
+		if dev != nil {
+			dev.Close()
+		}
+	}()
+
+	var gain aioc.RXGain
+	if *gainName != "" {
+		gain, err = aioc.ParseRXGain(*gainName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+	} else {
+		val, err := device().Read(aioc.RegAUDIORX)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read AUDIO_RX: %v\n", err)
+			os.Exit(1)
+		}
+		gain = aioc.RXGain(val)
+		if gain.String() == "unknown" {
+			fmt.Fprintf(os.Stderr, "Unknown AUDIO_RX value %08x, use --gain\n", val)
+			os.Exit(1)
+		}
+	}
+	boost := aioc.TXBoostOFF
+	switch {
+	case *boostName == "on":
+		boost = aioc.TXBoostON
+	case *boostName != "" && *boostName != "off":
+		fmt.Fprintf(os.Stderr, "Invalid --tx-boost value: %s\n", *boostName)
+		os.Exit(1)
+	case *boostName == "" && *loopback:
+		val, err := device().Read(aioc.RegAUDIOTX)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read AUDIO_TX: %v\n", err)
+			os.Exit(1)
+		}
+		boost = aioc.TXBoost(val)
+	}
+
+	advice := recommendAudio(st, gain, boost, *loopback, *target)
+	fmt.Printf("\nCaptured at RX gain %s, recommended RX gain: %s\n", gain, advice.gain)
+	if *loopback {
+		fmt.Printf("TX boost: %s, recommended: %s\n", onOff(boost == aioc.TXBoostON), onOff(advice.boost == aioc.TXBoostON))
+	}
+	for _, n := range advice.notes {
+		fmt.Printf("Note: %s\n", n)
+	}
+
+	if !*apply {
+		return
+	}
+	fmt.Printf("Setting Audio RX gain to %s\n", advice.gain)
+	if err := device().Write(aioc.RegAUDIORX, uint32(advice.gain)); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write AUDIO_RX: %v\n", err)
+		os.Exit(1)
+	}
+	if advice.boost != boost {
+		fmt.Printf("Setting Audio TX boost to %s\n", onOff(advice.boost == aioc.TXBoostON))
+		if err := device().Write(aioc.RegAUDIOTX, uint32(advice.boost)); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write AUDIO_TX: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if *store {
+		fmt.Println("Storing...")
+		if err := device().SendCommand(aioc.CmdSTORE); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to store settings: %v\n", err)
+			os.Exit(1)
+		}
+	}
+}
+
+// toneDefaults are the default frequencies of each test signal
+var toneDefaults = map[string][2]float64{
+	"tone":     {1000, 0},
+	"two-tone": {700, 1900},
+	"afsk":     {1200, 2200},
+	"sweep":    {300, 3000},
+}
+
+// playCommand builds the player command line, replacing "{}" with path or
+// appending path if there is no placeholder
+func playCommand(player, path string) []string {
+	args := strings.Fields(player)
+	for i, a := range args {
+		if a == "{}" {
+			args[i] = path
+			return args
+		}
+	}
+	return append(args, path)
+}
+
+// runAudioTone implements "aioc-util audio tone"
+func runAudioTone(args []string) {
+	fs := flag.NewFlagSet("audio tone", flag.ExitOnError)
+	kind := fs.String("type", "tone", "Signal: tone, two-tone, afsk (alternating mark/space) or sweep")
+	freq := fs.Float64("freq", 0, "Tone, first two-tone, mark or sweep start frequency in Hz (default depends on --type)")
+	freq2 := fs.Float64("freq2", 0, "Second two-tone, space or sweep end frequency in Hz (default depends on --type)")
+	baud := fs.Float64("baud", 1200, "AFSK bit rate")
+	level := fs.Float64("level", -6, "Peak level in dBFS")
+	duration := fs.Duration("duration", 10*time.Second, "Length of the signal")
+	rate := fs.Int("rate", 48000, "Sample rate in Hz (the AIOC runs at 48000)")
+	out := fs.String("out", "", "Output file; .raw writes headerless S16_LE PCM, anything else WAV")
+	play := fs.String("play", "", "Player command, e.g. \"aplay -D plughw:CARD=AllInOneCable\" ({} is replaced by the file)")
+	channel := fs.Int("channel", 0, "Key this PTT channel (1 or 2) while playing")
+	lead := fs.Duration("lead", 200*time.Millisecond, "Time between keying PTT and starting the player")
+	txTimeout := fs.Duration("tx-timeout", 0, "Stop the player and release PTT after this long (default: duration plus 10s)")
+	openUSB := fs.String("open-usb", "", "USB VID and PID to use when opening (format: VID,PID)")
+	fs.Parse(args)
+
+	defaults, ok := toneDefaults[*kind]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown --type value: %s\n", *kind)
+		os.Exit(1)
+	}
+	if *freq == 0 {
+		*freq = defaults[0]
+	}
+	if *freq2 == 0 {
+		*freq2 = defaults[1]
+	}
+	nyquist := float64(*rate) / 2
+	if *rate < 8000 || *freq <= 0 || *freq >= nyquist || (*kind != "tone" && (*freq2 <= 0 || *freq2 >= nyquist)) {
+		fmt.Fprintf(os.Stderr, "Frequencies must be between 0 and %.0f Hz\n", nyquist)
+		os.Exit(1)
+	}
+	if *level > 0 {
+		fmt.Fprintf(os.Stderr, "Invalid --level value: %.1f dBFS is above full scale\n", *level)
+		os.Exit(1)
+	}
+	if *duration <= 0 {
+		fmt.Fprintf(os.Stderr, "Invalid --duration value: %s\n", *duration)
+		os.Exit(1)
+	}
+	if *out == "" && *play == "" {
+		fmt.Fprintf(os.Stderr, "Use --out and/or --play\n")
+		os.Exit(1)
+	}
+	if *channel != 0 && *play == "" {
+		fmt.Fprintf(os.Stderr, "--channel requires --play\n")
+		os.Exit(1)
+	}
+
+	amp := dsp.Amplitude(*level)
+	var samples []int16
+	switch *kind {
+	case "tone":
+		samples = dsp.Tone(*rate, *duration, *freq, amp)
+		fmt.Printf("Tone: %.0f Hz", *freq)
+	case "two-tone":
+		samples = dsp.TwoTone(*rate, *duration, *freq, *freq2, amp)
+		fmt.Printf("Two-tone: %.0f Hz + %.0f Hz, each %.1f dBFS", *freq, *freq2, *level-6.02)
+	case "afsk":
+		samples = dsp.AFSK(*rate, *duration, *freq, *freq2, *baud, amp)
+		fmt.Printf("AFSK: %.0f/%.0f Hz alternating at %.0f baud", *freq, *freq2, *baud)
+	case "sweep":
+		samples = dsp.Sweep(*rate, *duration, *freq, *freq2, amp)
+		fmt.Printf("Sweep: %.0f Hz to %.0f Hz", *freq, *freq2)
+	}
+	dsp.Fade(samples, *rate, 5*time.Millisecond)
+	fmt.Printf(", %.1f dBFS peak, %s at %d Hz\n", *level, *duration, *rate)
+
+	// removeTemp deletes the temporary file played when there is no --out.
+	// os.Exit skips deferred calls, so it is also called before each exit.
+	path := *out
+	removeTemp := func() {}
+	if path == "" {
+		f, err := os.CreateTemp("", "aioc-tone-*.wav")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to create temporary file: %v\n", err)
+			os.Exit(1)
+		}
+		f.Close()
+		path = f.Name()
+		removeTemp = func() { os.Remove(path) }
+		defer removeTemp()
+	}
+	if strings.HasSuffix(strings.ToLower(path), ".raw") {
+		f, err := os.Create(path)
+		if err == nil {
+			bw := bufio.NewWriter(f)
+			err = wav.WriteRaw(bw, samples)
+			if err == nil {
+				err = bw.Flush()
+			}
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write %s: %v\n", path, err)
+			removeTemp()
+			os.Exit(1)
+		}
+	} else if err := wav.WriteFile(path, *rate, samples); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write %s: %v\n", path, err)
+		removeTemp()
+		os.Exit(1)
+	}
+	if *out != "" {
+		fmt.Printf("Wrote %s\n", *out)
+	}
+	if *play == "" {
+		return
+	}
+
+	timeout := *txTimeout
+	if timeout == 0 {
+		timeout = *duration + *lead + 10*time.Second
+	}
+	// PTT and the player share one deadline, so the transmitter is not
+	// released before the player is stopped
+	deadline := time.Now().Add(timeout)
+	var keyer *pttKeyer
+	if *channel != 0 {
+		ch, err := pttChannelFromNumber(*channel)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid --channel value: %v\n", err)
+			removeTemp()
+			os.Exit(1)
+		}
+		dev := openDevice(*openUSB)
+		defer dev.Close()
+		keyer = newPTTKeyer(dev, ch, time.Until(deadline))
+		releaseOnSignal(keyer, removeTemp)
+		if err := keyer.Set(true); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to key PTT%d: %v\n", *channel, err)
+			removeTemp()
+			os.Exit(1)
+		}
+		fmt.Printf("PTT%d on\n", *channel)
+		time.Sleep(*lead)
+	}
+
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	argv := playCommand(*play, path)
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err := cmd.Run()
+
+	if keyer != nil {
+		if kerr := keyer.Close(); kerr != nil {
+			fmt.Fprintf(os.Stderr, "Failed to release PTT%d: %v\n", *channel, kerr)
+		} else {
+			fmt.Printf("PTT%d off\n", *channel)
+		}
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		fmt.Fprintf(os.Stderr, "Player stopped after %s\n", timeout)
+		removeTemp()
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Player failed: %v\n", err)
+		removeTemp()
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kenwood mode number for USB as used by the MD and IF commands
+const kenwoodModeUSB = 2
+
+// kenwoodTS2000ID is the radio ID answered to "ID;"
+const kenwoodTS2000ID = "019"
+
+// catSession answers a minimal Kenwood TS-2000 CAT dialect. Only PTT is
+// real; the frequency is remembered so clients that poll it stay happy.
+type catSession struct {
+	ptt  *pttKeyer
+	freq int64
+	mode int
+	buf  []byte
+}
+
+func newCATSession(ptt *pttKeyer) *catSession {
+	return &catSession{ptt: ptt, freq: 14070000, mode: kenwoodModeUSB}
+}
+
+// Feed consumes raw bytes from the client and returns the replies for every
+// complete ";"-terminated command
+func (s *catSession) Feed(data []byte) []byte {
+	s.buf = append(s.buf, data...)
+
+	var out []byte
+	for {
+		idx := bytes.IndexByte(s.buf, ';')
+		if idx < 0 {
+			break
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(string(s.buf[:idx])))
+		s.buf = s.buf[idx+1:]
+		if cmd == "" {
+			continue
+		}
+		out = append(out, s.handle(cmd)...)
+	}
+
+	// Protect against clients that never send a terminator
+	if len(s.buf) > 256 {
+		s.buf = s.buf[:0]
+	}
+	return out
+}
+
+// handle executes a single command (without the trailing ";") and returns
+// the reply, which is empty for set commands
+func (s *catSession) handle(cmd string) string {
+	op, arg := cmd, ""
+	if len(cmd) > 2 {
+		op, arg = cmd[:2], cmd[2:]
+	}
+
+	switch op {
+	case "ID":
+		return "ID" + kenwoodTS2000ID + ";"
+	case "TX":
+		return s.setPTT(true)
+	case "RX":
+		return s.setPTT(false)
+	case "IF":
+		tx := 0
+		if s.ptt.Keyed() {
+			tx = 1
+		}
+		// IF P1..P15: frequency, step, RIT/XIT offset and flags, memory
+		// channel, TX/RX, mode, VFO, scan, split, tone, tone number, shift
+		return fmt.Sprintf("IF%011d     %+05d%d%d%d%02d%d%d%d%d%d%d%02d%d;",
+			s.freq, 0, 0, 0, 0, 0, tx, s.mode, 0, 0, 0, 0, 0, 0)
+	case "FA":
+		if arg == "" {
+			return fmt.Sprintf("FA%011d;", s.freq)
+		}
+		freq, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			return "?;"
+		}
+		s.freq = freq
+		return ""
+	case "MD":
+		if arg == "" {
+			return fmt.Sprintf("MD%d;", s.mode)
+		}
+		mode, err := strconv.Atoi(arg)
+		if err != nil {
+			return "?;"
+		}
+		s.mode = mode
+		return ""
+	case "PS":
+		// Power status: the "radio" is always on
+		if arg == "" {
+			return "PS1;"
+		}
+		return ""
+	case "AI":
+		// Auto information is never sent
+		if arg == "" {
+			return "AI0;"
+		}
+		return ""
+	}
+	return "?;"
+}
+
+func (s *catSession) setPTT(on bool) string {
+	if err := s.ptt.Set(on); err != nil {
+		log.Printf("Failed to set PTT %s: %v", onOff(on), err)
+		return "?;"
+	}
+	log.Printf("PTT %s", onOff(on))
+	return ""
+}
+
+// runCAT implements "aioc-util cat"
+func runCAT(args []string) {
+	fs := flag.NewFlagSet("cat", flag.ExitOnError)
+	link := fs.String("link", "", "Create a symlink to the pty at this path (e.g. /tmp/aioc-cat)")
+	channel := fs.Int("channel", 1, "PTT channel to key: 1 or 2")
+	txTimeout := fs.Duration("tx-timeout", 3*time.Minute, "Release PTT after it has been keyed this long (0 disables)")
+	openUSB := fs.String("open-usb", "", "USB VID and PID to use when opening (format: VID,PID)")
+	fs.Parse(args)
+
+	ch, err := pttChannelFromNumber(*channel)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid --channel value: %v\n", err)
+		os.Exit(1)
+	}
+
+	master, slave, err := openPTY()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create pty: %v\n", err)
+		os.Exit(1)
+	}
+	defer master.Close()
+	defer slave.Close()
+
+	if *link != "" {
+		// Only a stale link from an earlier run is replaced, never a file
+		fi, err := os.Lstat(*link)
+		switch {
+		case err == nil && fi.Mode()&os.ModeSymlink == 0:
+			fmt.Fprintf(os.Stderr, "Refusing to replace %s: it exists and is not a symlink\n", *link)
+			os.Exit(1)
+		case err == nil:
+			os.Remove(*link)
+		case !errors.Is(err, os.ErrNotExist):
+			fmt.Fprintf(os.Stderr, "Failed to check %s: %v\n", *link, err)
+			os.Exit(1)
+		}
+		if err := os.Symlink(slave.Name(), *link); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to create symlink %s: %v\n", *link, err)
+			os.Exit(1)
+		}
+		defer os.Remove(*link)
+	}
+
+	dev := openDevice(*openUSB)
+	defer dev.Close()
+
+	ptt := newPTTKeyer(dev, ch, *txTimeout)
+	releaseOnSignal(ptt, func() {
+		if *link != "" {
+			os.Remove(*link)
+		}
+	})
+
+	port := slave.Name()
+	if *link != "" {
+		port = *link
+	}
+	log.Printf("Kenwood TS-2000 CAT emulator on %s (PTT%d, tx timeout %s)", port, *channel, *txTimeout)
+
+	session := newCATSession(ptt)
+	buf := make([]byte, 256)
+	for {
+		n, err := master.Read(buf)
+		if err != nil {
+			ptt.Close()
+			fmt.Fprintf(os.Stderr, "Failed to read from pty: %v\n", err)
+			os.Exit(1)
+		}
+		if reply := session.Feed(buf[:n]); len(reply) > 0 {
+			if _, err := master.Write(reply); err != nil {
+				log.Printf("Failed to write reply: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rampa069/aioc-util/aioc"
+	"github.com/rampa069/aioc-util/vdetect"
+	"github.com/rampa069/aioc-util/wav"
+)
+
+// detectorCommand describes "aioc-util vptt simulate" or "aioc-util vcos
+// simulate": the register set of the detector and the parts of the command
+// that differ between the two
+type detectorCommand struct {
+	name           string // "vptt" or "vcos"
+	capability     aioc.Capability
+	lvlReg, timReg aioc.Register
+	verb           string // "keyed" or "opened"
+
+	wavHelp, recommendHelp, bridgeHelp string
+	bridge                             time.Duration // default of --bridge
+
+	// flags adds the command's own flags
+	flags func(fs *flag.FlagSet)
+	// activity returns the level above which audio counts as speech or
+	// carrier. It exits if recommend is set and no recommendation is
+	// possible.
+	activity func(levels vdetect.Levels, recommend bool) int
+	// recommend searches for the detector suiting the recording
+	recommend func(samples []int16, rate int, levels vdetect.Levels, activity int, bridge time.Duration) vdetect.Detector
+}
+
+// runDetectorSimulate implements the simulate subcommand of c
+func runDetectorSimulate(c detectorCommand, args []string) {
+	fs := flag.NewFlagSet(c.name+" simulate", flag.ExitOnError)
+	wavFile := fs.String("wav", "", c.wavHelp)
+	lvlctrl := fs.String("lvlctrl", "", c.lvlReg.String()+" value to simulate (hex or decimal, default: read from device)")
+	timctrl := fs.String("timctrl", "", c.timReg.String()+" value to simulate (hex or decimal, default: read from device)")
+	recommend := fs.Bool("recommend", false, c.recommendHelp)
+	bridge := fs.Duration("bridge", c.bridge, c.bridgeHelp)
+	width := fs.Int("width", 72, "Width of the timeline")
+	apply := fs.Bool("apply", false, "Write the recommended values to the device")
+	store := fs.Bool("store", false, "Store the settings into flash after --apply")
+	openUSB := fs.String("open-usb", "", "USB VID and PID to use when opening (format: VID,PID)")
+	c.flags(fs)
+	fs.Parse(args)
+
+	if *wavFile == "" {
+		fmt.Fprintf(os.Stderr, "--wav is required\n")
+		os.Exit(1)
+	}
+	if *apply && !*recommend {
+		fmt.Fprintf(os.Stderr, "--apply requires --recommend\n")
+		os.Exit(1)
+	}
+	rate, samples, levels := loadDetectorWAV(*wavFile)
+	activity := c.activity(levels, *recommend)
+
+	var dev *aioc.Device
+	device := func() *aioc.Device {
+		if dev == nil {
+			dev = openDevice(*openUSB)
+			requireCapability(dev, c.capability)
+		}
+		return dev
+	}
+	defer func() {
+		if dev != nil {
+			dev.Close()
+		}
+	}()
+
+	if *lvlctrl != "" || *timctrl != "" || !*recommend {
+		d, err := detectorFromArgs(*lvlctrl, *timctrl, c.lvlReg, c.timReg, device)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		detectorReport("Simulated", c.verb, d, samples, rate, levels, activity, *bridge, *width)
+	}
+
+	if !*recommend {
+		return
+	}
+
+	d := c.recommend(samples, rate, levels, activity, *bridge)
+	detectorReport("Recommended", c.verb, d, samples, rate, levels, activity, *bridge, *width)
+	newLvl, newTim, err := d.Registers()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Use: --%s-lvlctrl 0x%x --%s-timctrl %d\n", c.name, newLvl, c.name, newTim)
+
+	if !*apply {
+		return
+	}
+	fmt.Printf("Setting %s to 0x%x and %s to %d\n", c.lvlReg, newLvl, c.timReg, newTim)
+	if err := device().Write(c.lvlReg, newLvl); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write %s: %v\n", c.lvlReg, err)
+		os.Exit(1)
+	}
+	if err := device().Write(c.timReg, newTim); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write %s: %v\n", c.timReg, err)
+		os.Exit(1)
+	}
+	if *store {
+		fmt.Println("Storing...")
+		if err := device().SendCommand(aioc.CmdSTORE); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to store settings: %v\n", err)
+			os.Exit(1)
+		}
+	}
+}
+
+// loadDetectorWAV reads a recording for the VPTT/VCOS simulators and prints
+// its noise and signal levels. It exits on error.
+func loadDetectorWAV(path string) (int, []int16, vdetect.Levels) {
+	rate, samples, err := wav.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read %s: %v\n", path, err)
+		os.Exit(1)
+	}
+	if len(samples) == 0 {
+		fmt.Fprintf(os.Stderr, "%s contains no audio\n", path)
+		os.Exit(1)
+	}
+
+	levels := vdetect.Analyze(samples, rate, 5*time.Millisecond)
+	fmt.Printf("%s: %.2f s at %d Hz\n", path, float64(len(samples))/float64(rate), rate)
+	fmt.Printf("Noise level: %d (%.1f dBFS), signal level: %d (%.1f dBFS)\n",
+		levels.Noise, vdetect.DB(levels.Noise), levels.Signal, vdetect.DB(levels.Signal))
+	return rate, samples, levels
+}
+
+// detectorFromArgs builds the detector to simulate from LVLCTRL/TIMCTRL
+// arguments. Empty arguments are read from the registers of the device.
+func detectorFromArgs(lvlArg, timArg string, lvlReg, timReg aioc.Register, device func() *aioc.Device) (vdetect.Detector, error) {
+	var vals [2]uint32
+	for i, arg := range []string{lvlArg, timArg} {
+		reg := []aioc.Register{lvlReg, timReg}[i]
+		if arg == "" {
+			val, err := device().Read(reg)
+			if err != nil {
+				return vdetect.Detector{}, fmt.Errorf("failed to read register 0x%02x: %w", uint8(reg), err)
+			}
+			vals[i] = val
+			continue
+		}
+		val, err := parseHexOrDec(arg)
+		if err != nil || val < 0 {
+			return vdetect.Detector{}, fmt.Errorf("invalid register value: %s", arg)
+		}
+		vals[i] = uint32(val)
+	}
+	return vdetect.FromRegisters(vals[0], vals[1]), nil
+}
+
+// detectorReport prints the intervals of a detector run, using verb
+// ("keyed", "opened") for the output turning on. Audio louder than activity
+// counts as the start of speech or carrier when measuring onset clipping;
+// drops shorter than bridge are reported as chatter.
+func detectorReport(label, verb string, d vdetect.Detector, samples []int16, rate int, levels vdetect.Levels, activity int, bridge time.Duration, width int) {
+	lvlctrl, timctrl, _ := d.Registers()
+	fmt.Printf("\n%s: threshold %d (%.1f dBFS), tail %s (LVLCTRL=0x%x, TIMCTRL=%d)\n",
+		label, d.Threshold, vdetect.DB(d.Threshold), d.Tail, lvlctrl, timctrl)
+
+	intervals := d.Run(samples, rate)
+	if len(intervals) == 0 {
+		fmt.Printf("Never %s\n", verb)
+		return
+	}
+	// The tail may run past the end of the recording
+	total := max(time.Duration(int64(len(samples))*int64(time.Second)/int64(rate)), intervals[len(intervals)-1].End)
+
+	var on, worstClip time.Duration
+	chatter := 0
+	for i, iv := range intervals {
+		clip := levels.OnsetClip(iv, activity).Round(time.Millisecond)
+		worstClip = max(worstClip, clip)
+		on += iv.Duration()
+		fmt.Printf("  %8.3f s - %8.3f s  %7.3f s", iv.Start.Seconds(), iv.End.Seconds(), iv.Duration().Seconds())
+		if clip > 0 {
+			fmt.Printf("  onset clipped by %s", clip)
+		}
+		if i > 0 && iv.Start-intervals[i-1].End < bridge {
+			fmt.Printf("  dropped for %s", (iv.Start - intervals[i-1].End).Round(time.Millisecond))
+			chatter++
+		}
+		fmt.Println()
+	}
+	fmt.Printf("  |%s|\n", vdetect.Timeline(intervals, total, width))
+	fmt.Printf("%s %d times, %.2f s of %.2f s (%.0f%%), held %s after the audio ends\n",
+		strings.ToUpper(verb[:1])+verb[1:], len(intervals), on.Seconds(), total.Seconds(), 100*on.Seconds()/total.Seconds(), d.Tail)
+	if worstClip > 0 {
+		fmt.Printf("Worst onset clip: %s\n", worstClip)
+	}
+	if chatter > 0 {
+		fmt.Printf("Dropped %d times for less than %s\n", chatter, bridge)
+	}
+}
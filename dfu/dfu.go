@@ -0,0 +1,383 @@
+// Package dfu flashes firmware over USB DFU 1.1 with the STMicroelectronics
+// DfuSe extensions used by the STM32 system bootloader, which is how AIOC
+// firmware is updated.
+//
+// The protocol runs over a Transport carrying class requests to the DFU
+// interface. OpenUSB provides one for real hardware (Linux only) and
+// NewSimulator one backed by memory, so every step can be exercised without
+// a device.
+package dfu
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Request is a DFU class request
+type Request uint8
+
+// DFU class requests
+const (
+	ReqDetach    Request = 0
+	ReqDnload    Request = 1
+	ReqUpload    Request = 2
+	ReqGetStatus Request = 3
+	ReqClrStatus Request = 4
+	ReqGetState  Request = 5
+	ReqAbort     Request = 6
+)
+
+// State is the state of the DFU state machine
+type State uint8
+
+// DFU states
+const (
+	StateAppIdle           State = 0
+	StateAppDetach         State = 1
+	StateIdle              State = 2
+	StateDnloadSync        State = 3
+	StateDnbusy            State = 4
+	StateDnloadIdle        State = 5
+	StateManifestSync      State = 6
+	StateManifest          State = 7
+	StateManifestWaitReset State = 8
+	StateUploadIdle        State = 9
+	StateError             State = 10
+)
+
+var stateNames = map[State]string{
+	StateAppIdle:           "appIDLE",
+	StateAppDetach:         "appDETACH",
+	StateIdle:              "dfuIDLE",
+	StateDnloadSync:        "dfuDNLOAD-SYNC",
+	StateDnbusy:            "dfuDNBUSY",
+	StateDnloadIdle:        "dfuDNLOAD-IDLE",
+	StateManifestSync:      "dfuMANIFEST-SYNC",
+	StateManifest:          "dfuMANIFEST",
+	StateManifestWaitReset: "dfuMANIFEST-WAIT-RESET",
+	StateUploadIdle:        "dfuUPLOAD-IDLE",
+	StateError:             "dfuERROR",
+}
+
+func (s State) String() string {
+	if name, ok := stateNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("state(%d)", uint8(s))
+}
+
+// Status is the bStatus field of a GETSTATUS response
+type Status uint8
+
+// DFU status codes
+const (
+	StatusOK             Status = 0x00
+	StatusErrTarget      Status = 0x01
+	StatusErrFile        Status = 0x02
+	StatusErrWrite       Status = 0x03
+	StatusErrErase       Status = 0x04
+	StatusErrCheckErased Status = 0x05
+	StatusErrProg        Status = 0x06
+	StatusErrVerify      Status = 0x07
+	StatusErrAddress     Status = 0x08
+	StatusErrNotDone     Status = 0x09
+	StatusErrFirmware    Status = 0x0A
+	StatusErrVendor      Status = 0x0B
+	StatusErrUSBR        Status = 0x0C
+	StatusErrPOR         Status = 0x0D
+	StatusErrUnknown     Status = 0x0E
+	StatusErrStalledPkt  Status = 0x0F
+)
+
+var statusNames = map[Status]string{
+	StatusOK:             "OK",
+	StatusErrTarget:      "file is not for this device",
+	StatusErrFile:        "file fails vendor checks",
+	StatusErrWrite:       "cannot write memory",
+	StatusErrErase:       "cannot erase memory",
+	StatusErrCheckErased: "memory not erased",
+	StatusErrProg:        "program memory failed",
+	StatusErrVerify:      "verify failed",
+	StatusErrAddress:     "address out of range",
+	StatusErrNotDone:     "download incomplete",
+	StatusErrFirmware:    "firmware corrupt",
+	StatusErrVendor:      "vendor error",
+	StatusErrUSBR:        "unexpected USB reset",
+	StatusErrPOR:         "unexpected power on reset",
+	StatusErrUnknown:     "unknown error",
+	StatusErrStalledPkt:  "request stalled",
+}
+
+func (s Status) String() string {
+	if name, ok := statusNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("status 0x%02x", uint8(s))
+}
+
+// StatusResponse is the decoded GETSTATUS response
+type StatusResponse struct {
+	Status      Status
+	PollTimeout time.Duration // time to wait before the next GETSTATUS
+	State       State
+}
+
+// StatusError is returned when the device reports a failed operation
+type StatusError struct {
+	Op     string
+	Status Status
+	State  State
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s: %s (state %s)", e.Op, e.Status, e.State)
+}
+
+// ErrUnexpectedState is returned when the device is not in the state the
+// protocol requires
+var ErrUnexpectedState = errors.New("unexpected DFU state")
+
+// Errors returned by transports
+var (
+	ErrStall        = errors.New("request stalled")
+	ErrDisconnected = errors.New("device disconnected")
+)
+
+// Transport carries DFU class requests to the DFU interface of a device
+type Transport interface {
+	// ControlOut sends a host-to-device class request with data
+	ControlOut(req Request, value uint16, data []byte) error
+	// ControlIn sends a device-to-host class request and returns up to
+	// length bytes of response
+	ControlIn(req Request, value uint16, length int) ([]byte, error)
+	// TransferSize is the largest DNLOAD/UPLOAD block the device accepts
+	TransferSize() int
+	Close() error
+}
+
+// Resetter is implemented by transports to real devices. DFU 1.1 devices
+// that do not detach by themselves wait for a USB reset after DETACH.
+type Resetter interface {
+	// WillDetach reports bitWillDetach of the DFU functional descriptor
+	WillDetach() bool
+	// Reset resets the USB device
+	Reset() error
+}
+
+// Client runs the DFU protocol over a Transport
+type Client struct {
+	t Transport
+	// Sleep waits between status polls; tests and simulations may replace
+	// it to run without delays
+	Sleep func(time.Duration)
+}
+
+// NewClient returns a client for t
+func NewClient(t Transport) *Client {
+	return &Client{t: t, Sleep: time.Sleep}
+}
+
+// GetStatus reads the device status
+func (c *Client) GetStatus() (StatusResponse, error) {
+	b, err := c.t.ControlIn(ReqGetStatus, 0, 6)
+	if err != nil {
+		return StatusResponse{}, fmt.Errorf("GETSTATUS failed: %w", err)
+	}
+	if len(b) < 6 {
+		return StatusResponse{}, fmt.Errorf("GETSTATUS returned %d bytes, expected 6", len(b))
+	}
+	poll := uint32(b[1]) | uint32(b[2])<<8 | uint32(b[3])<<16
+	return StatusResponse{
+		Status:      Status(b[0]),
+		PollTimeout: time.Duration(poll) * time.Millisecond,
+		State:       State(b[4]),
+	}, nil
+}
+
+// GetState reads the device state without changing it
+func (c *Client) GetState() (State, error) {
+	b, err := c.t.ControlIn(ReqGetState, 0, 1)
+	if err != nil {
+		return 0, fmt.Errorf("GETSTATE failed: %w", err)
+	}
+	if len(b) < 1 {
+		return 0, fmt.Errorf("GETSTATE returned no data")
+	}
+	return State(b[0]), nil
+}
+
+// ClearStatus leaves dfuERROR
+func (c *Client) ClearStatus() error {
+	if err := c.t.ControlOut(ReqClrStatus, 0, nil); err != nil {
+		return fmt.Errorf("CLRSTATUS failed: %w", err)
+	}
+	return nil
+}
+
+// Abort returns the device to dfuIDLE
+func (c *Client) Abort() error {
+	if err := c.t.ControlOut(ReqAbort, 0, nil); err != nil {
+		return fmt.Errorf("ABORT failed: %w", err)
+	}
+	return nil
+}
+
+// Detach asks a device in run-time mode to switch to its DFU bootloader
+// within timeout. A device without bitWillDetach is reset afterwards, if
+// the transport implements Resetter.
+func (c *Client) Detach(timeout time.Duration) error {
+	if err := c.t.ControlOut(ReqDetach, uint16(timeout.Milliseconds()), nil); err != nil {
+		return fmt.Errorf("DETACH failed: %w", err)
+	}
+	if r, ok := c.t.(Resetter); ok && !r.WillDetach() {
+		// The device drops off the bus as it resets
+		if err := r.Reset(); err != nil && !errors.Is(err, ErrDisconnected) {
+			return fmt.Errorf("USB reset after DETACH failed: %w", err)
+		}
+	}
+	return nil
+}
+
+// Idle brings the device to dfuIDLE from any error or pending transfer
+func (c *Client) Idle() error {
+	st, err := c.GetStatus()
+	if err != nil {
+		return err
+	}
+	switch st.State {
+	case StateIdle:
+		return nil
+	case StateError:
+		if err := c.ClearStatus(); err != nil {
+			return err
+		}
+	default:
+		if err := c.Abort(); err != nil {
+			return err
+		}
+	}
+	st, err = c.GetStatus()
+	if err != nil {
+		return err
+	}
+	if st.State != StateIdle {
+		return fmt.Errorf("%w: %s, expected %s", ErrUnexpectedState, st.State, StateIdle)
+	}
+	return nil
+}
+
+// wait polls the status after a DNLOAD until the device has finished it
+func (c *Client) wait(op string) error {
+	for {
+		st, err := c.GetStatus()
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		if st.Status != StatusOK {
+			c.ClearStatus()
+			return &StatusError{Op: op, Status: st.Status, State: st.State}
+		}
+		switch st.State {
+		case StateDnbusy, StateDnloadSync:
+			c.Sleep(st.PollTimeout)
+		case StateDnloadIdle, StateIdle:
+			return nil
+		default:
+			return fmt.Errorf("%s: %w: %s", op, ErrUnexpectedState, st.State)
+		}
+	}
+}
+
+// dnload sends one DNLOAD block and waits for the device to process it
+func (c *Client) dnload(op string, block uint16, data []byte) error {
+	if err := c.t.ControlOut(ReqDnload, block, data); err != nil {
+		return fmt.Errorf("%s: DNLOAD failed: %w", op, err)
+	}
+	return c.wait(op)
+}
+
+// dfuseCommand sends a DfuSe command: a DNLOAD to block 0 holding a command
+// byte and a little-endian address
+func (c *Client) dfuseCommand(op string, cmd byte, addr uint32) error {
+	data := make([]byte, 5)
+	data[0] = cmd
+	binary.LittleEndian.PutUint32(data[1:], addr)
+	return c.dnload(fmt.Sprintf("%s 0x%08x", op, addr), 0, data)
+}
+
+// DfuSe command bytes
+const (
+	dfuseSetAddress = 0x21
+	dfuseErase      = 0x41
+)
+
+// SetAddress sets the DfuSe address pointer used by the following data
+// blocks
+func (c *Client) SetAddress(addr uint32) error {
+	return c.dfuseCommand("set address", dfuseSetAddress, addr)
+}
+
+// ErasePage erases the flash page containing addr
+func (c *Client) ErasePage(addr uint32) error {
+	return c.dfuseCommand("erase page", dfuseErase, addr)
+}
+
+// Download writes data at the address pointer in TransferSize blocks.
+// progress, if not nil, is called with the bytes written so far.
+func (c *Client) Download(data []byte, progress func(done int)) error {
+	size := c.t.TransferSize()
+	for off := 0; off < len(data); off += size {
+		end := min(off+size, len(data))
+		if err := c.dnload(fmt.Sprintf("write block %d", off/size), uint16(2+off/size), data[off:end]); err != nil {
+			return err
+		}
+		if progress != nil {
+			progress(end)
+		}
+	}
+	return nil
+}
+
+// Upload reads n bytes starting at addr
+func (c *Client) Upload(addr uint32, n int) ([]byte, error) {
+	if err := c.SetAddress(addr); err != nil {
+		return nil, err
+	}
+	// Uploads start from dfuIDLE
+	if err := c.Abort(); err != nil {
+		return nil, err
+	}
+	// Blocks are addressed in TransferSize units, so every request asks for
+	// a full block and the excess is dropped
+	size := c.t.TransferSize()
+	out := make([]byte, 0, n+size)
+	for block := 0; len(out) < n; block++ {
+		b, err := c.t.ControlIn(ReqUpload, uint16(2+block), size)
+		if err != nil {
+			return nil, fmt.Errorf("read block %d: UPLOAD failed: %w", block, err)
+		}
+		if len(b) == 0 {
+			break
+		}
+		out = append(out, b...)
+	}
+	if err := c.Abort(); err != nil {
+		return nil, err
+	}
+	return out[:min(n, len(out))], nil
+}
+
+// Leave makes the device leave DFU mode and start the firmware at addr.
+// The device resets, so the final status request may fail.
+func (c *Client) Leave(addr uint32) error {
+	if err := c.SetAddress(addr); err != nil {
+		return err
+	}
+	if err := c.t.ControlOut(ReqDnload, 2, nil); err != nil {
+		return fmt.Errorf("leave: DNLOAD failed: %w", err)
+	}
+	c.GetStatus()
+	return nil
+}
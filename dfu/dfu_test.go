@@ -0,0 +1,68 @@
+package dfu
+
+import (
+	"testing"
+	"time"
+)
+
+// runtimeDevice is a Transport for an application's run-time DFU
+// interface, which only accepts DETACH
+type runtimeDevice struct {
+	willDetach bool
+	resetErr   error
+	detaches   int
+	timeout    uint16
+	resets     int
+}
+
+func (d *runtimeDevice) ControlOut(req Request, value uint16, data []byte) error {
+	if req != ReqDetach {
+		return ErrStall
+	}
+	d.detaches++
+	d.timeout = value
+	return nil
+}
+
+func (d *runtimeDevice) ControlIn(req Request, value uint16, length int) ([]byte, error) {
+	return nil, ErrStall
+}
+
+func (d *runtimeDevice) TransferSize() int { return 0 }
+func (d *runtimeDevice) Close() error      { return nil }
+func (d *runtimeDevice) WillDetach() bool  { return d.willDetach }
+
+func (d *runtimeDevice) Reset() error {
+	d.resets++
+	return d.resetErr
+}
+
+func TestDetach(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		willDetach bool
+		resetErr   error
+		resets     int
+		fails      bool
+	}{
+		{"detaches by itself", true, nil, 0, false},
+		{"waits for a reset", false, nil, 1, false},
+		{"disconnects during the reset", false, ErrDisconnected, 1, false},
+		{"reset fails", false, ErrStall, 1, true},
+	} {
+		d := &runtimeDevice{willDetach: tc.willDetach, resetErr: tc.resetErr}
+		err := newTestClient(d).Detach(time.Second)
+		if (err != nil) != tc.fails {
+			t.Errorf("%s: Detach returned %v", tc.name, err)
+		}
+		if d.detaches != 1 || d.timeout != 1000 || d.resets != tc.resets {
+			t.Errorf("%s: %d DETACH (timeout %d ms), %d resets, want 1 (1000 ms), %d",
+				tc.name, d.detaches, d.timeout, d.resets, tc.resets)
+		}
+	}
+
+	// Transports without Resetter, such as the simulator, are not reset
+	if err := newTestClient(&struct{ Transport }{&runtimeDevice{}}).Detach(time.Second); err != nil {
+		t.Errorf("Detach without Resetter returned %v", err)
+	}
+}
@@ -0,0 +1,118 @@
+package dfu
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Stage is a step of Flash, reported to the progress callback
+type Stage string
+
+// Flash stages
+const (
+	StageErase  Stage = "erase"
+	StageWrite  Stage = "write"
+	StageVerify Stage = "verify"
+	StageLeave  Stage = "leave"
+)
+
+// FlashOptions controls Flash
+type FlashOptions struct {
+	Verify bool // read the flash back and compare it with the image
+	Leave  bool // start the new firmware when done
+	// Progress, if not nil, is called with the stage and the units (pages
+	// or bytes) done out of total
+	Progress func(stage Stage, done, total int)
+}
+
+// Flash erases the pages covered by elements, writes them, optionally reads
+// them back and starts the firmware at the layout base
+func (c *Client) Flash(layout Layout, elements []Element, opts FlashOptions) error {
+	progress := opts.Progress
+	if progress == nil {
+		progress = func(Stage, int, int) {}
+	}
+	total := 0
+	for _, e := range elements {
+		if err := layout.Check(e.Address, len(e.Data)); err != nil {
+			return err
+		}
+		total += len(e.Data)
+	}
+
+	if err := c.Idle(); err != nil {
+		return err
+	}
+
+	var pages []uint32
+	seen := make(map[uint32]bool)
+	for _, e := range elements {
+		for _, p := range layout.Pages(e.Address, len(e.Data)) {
+			if !seen[p] {
+				seen[p] = true
+				pages = append(pages, p)
+			}
+		}
+	}
+	for i, p := range pages {
+		if err := c.ErasePage(p); err != nil {
+			return err
+		}
+		progress(StageErase, i+1, len(pages))
+	}
+
+	written := 0
+	for _, e := range elements {
+		if err := c.SetAddress(e.Address); err != nil {
+			return err
+		}
+		err := c.Download(e.Data, func(done int) {
+			progress(StageWrite, written+done, total)
+		})
+		if err != nil {
+			return err
+		}
+		written += len(e.Data)
+	}
+
+	if opts.Verify {
+		// Reading back must start from dfuIDLE
+		if err := c.Abort(); err != nil {
+			return err
+		}
+		verified := 0
+		for _, e := range elements {
+			got, err := c.Upload(e.Address, len(e.Data))
+			if err != nil {
+				return err
+			}
+			if i := mismatch(got, e.Data); i >= 0 {
+				return fmt.Errorf("verify failed at 0x%08x", e.Address+uint32(i))
+			}
+			verified += len(e.Data)
+			progress(StageVerify, verified, total)
+		}
+	}
+
+	if opts.Leave {
+		if err := c.Leave(layout.Base); err != nil {
+			return err
+		}
+		progress(StageLeave, 1, 1)
+	}
+	return nil
+}
+
+// mismatch returns the offset of the first difference between got and
+// want, or -1 if they are equal
+func mismatch(got, want []byte) int {
+	if bytes.Equal(got, want) {
+		return -1
+	}
+	for i := range want {
+		if i >= len(got) || got[i] != want[i] {
+			return i
+		}
+	}
+	return len(want)
+}
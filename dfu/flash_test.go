@@ -0,0 +1,231 @@
+package dfu
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestClient returns a client for a simulated bootloader that does not
+// sleep between status polls
+func newTestClient(t Transport) *Client {
+	c := NewClient(t)
+	c.Sleep = func(time.Duration) {}
+	return c
+}
+
+// pattern returns n bytes that differ from their neighbours and from the
+// erased value
+func pattern(n int, seed byte) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i*7) + seed
+		if b[i] == 0xFF {
+			b[i] = 0
+		}
+	}
+	return b
+}
+
+// corruptUpload is a Transport that flips one byte of the data read back
+type corruptUpload struct {
+	*Simulator
+	offset int
+}
+
+func (c *corruptUpload) ControlIn(req Request, value uint16, length int) ([]byte, error) {
+	b, err := c.Simulator.ControlIn(req, value, length)
+	if req == ReqUpload && value == 2 && err == nil && c.offset < len(b) {
+		b[c.offset] ^= 0x01
+	}
+	return b, err
+}
+
+func TestFlash(t *testing.T) {
+	layout := STM32F302CB
+	sim := NewSimulator(layout)
+	// A page the image does not touch must survive the update
+	untouched := layout.Base + 16*layout.PageSize
+	copy(sim.Memory[untouched-layout.Base:], "keep")
+	// Old data before an element in the same page is erased with it
+	copy(sim.Memory[8*layout.PageSize:], "old")
+
+	elements := []Element{
+		// Three blocks, the last one short, spanning three pages
+		{Address: layout.Base, Data: pattern(2*sim.TransferSize()+100, 1)},
+		// Starts in the middle of a page
+		{Address: layout.Base + 8*layout.PageSize + 512, Data: pattern(700, 2)},
+	}
+	stages := make(map[Stage][2]int)
+	err := newTestClient(sim).Flash(layout, elements, FlashOptions{
+		Verify: true,
+		Leave:  true,
+		Progress: func(stage Stage, done, total int) {
+			stages[stage] = [2]int{done, total}
+		},
+	})
+	if err != nil {
+		t.Fatalf("Flash: %v", err)
+	}
+
+	for i, e := range elements {
+		if got := sim.Read(e.Address, len(e.Data)); !bytes.Equal(got, e.Data) {
+			t.Errorf("element %d not written", i)
+		}
+	}
+	if got := sim.Read(untouched, 4); string(got) != "keep" {
+		t.Errorf("untouched page changed to %q", got)
+	}
+	if got := sim.Read(layout.Base+8*layout.PageSize, 512); !bytes.Equal(got, bytes.Repeat([]byte{0xFF}, 512)) {
+		t.Errorf("start of the second element's page not erased")
+	}
+
+	total := len(elements[0].Data) + len(elements[1].Data)
+	want := map[Stage][2]int{
+		StageErase:  {4, 4},
+		StageWrite:  {total, total},
+		StageVerify: {total, total},
+		StageLeave:  {1, 1},
+	}
+	for stage, w := range want {
+		if stages[stage] != w {
+			t.Errorf("last %s progress %v, want %v", stage, stages[stage], w)
+		}
+	}
+	if !sim.Manifested() {
+		t.Errorf("device did not leave DFU mode")
+	}
+}
+
+func TestFlashWithoutVerifyOrLeave(t *testing.T) {
+	sim := NewSimulator(STM32F302CB)
+	elements := []Element{{Address: STM32F302CB.Base, Data: pattern(300, 3)}}
+	if err := newTestClient(sim).Flash(STM32F302CB, elements, FlashOptions{}); err != nil {
+		t.Fatalf("Flash: %v", err)
+	}
+	if n := sim.Requests[ReqUpload]; n != 0 {
+		t.Errorf("%d UPLOAD requests without Verify", n)
+	}
+	if sim.Manifested() {
+		t.Errorf("device left DFU mode without Leave")
+	}
+}
+
+func TestFlashOutsideLayout(t *testing.T) {
+	sim := NewSimulator(STM32F302CB)
+	elements := []Element{{Address: STM32F302CB.End() - 10, Data: pattern(20, 4)}}
+	if err := newTestClient(sim).Flash(STM32F302CB, elements, FlashOptions{}); err == nil {
+		t.Fatalf("Flash past the end of flash succeeded")
+	}
+	if len(sim.Requests) != 0 {
+		t.Errorf("requests sent for an invalid image: %v", sim.Requests)
+	}
+}
+
+func TestFlashVerifyMismatch(t *testing.T) {
+	sim := NewSimulator(STM32F302CB)
+	elements := []Element{{Address: STM32F302CB.Base, Data: pattern(1000, 5)}}
+	err := newTestClient(&corruptUpload{sim, 123}).Flash(STM32F302CB, elements, FlashOptions{Verify: true, Leave: true})
+	if err == nil {
+		t.Fatalf("Flash succeeded with corrupt read back")
+	}
+	if want := "verify failed at 0x0800007b"; !strings.Contains(err.Error(), want) {
+		t.Errorf("error %q, want %q", err, want)
+	}
+	if sim.Manifested() {
+		t.Errorf("device left DFU mode after a failed verify")
+	}
+}
+
+func TestDownloadNotErased(t *testing.T) {
+	sim := NewSimulator(STM32F302CB)
+	sim.Memory[10] = 0x00
+	c := newTestClient(sim)
+	if err := c.SetAddress(STM32F302CB.Base); err != nil {
+		t.Fatalf("SetAddress: %v", err)
+	}
+	err := c.Download(pattern(64, 6), nil)
+	var serr *StatusError
+	if !errors.As(err, &serr) || serr.Status != StatusErrCheckErased || serr.State != StateError {
+		t.Fatalf("Download to unerased flash returned %v, want %s in %s", err, StatusErrCheckErased, StateError)
+	}
+	// The error status is cleared so the next request is accepted
+	if st, err := c.GetState(); err != nil || st != StateIdle {
+		t.Errorf("state after the error is %s (%v), want %s", st, err, StateIdle)
+	}
+}
+
+func TestStallInError(t *testing.T) {
+	sim := NewSimulator(STM32F302CB)
+	c := newTestClient(sim)
+	// CLRSTATUS is only valid in dfuERROR, so this stalls and enters it
+	if err := c.ClearStatus(); !errors.Is(err, ErrStall) {
+		t.Fatalf("CLRSTATUS in %s returned %v, want %v", StateIdle, err, ErrStall)
+	}
+	if err := c.SetAddress(STM32F302CB.Base); !errors.Is(err, ErrStall) {
+		t.Fatalf("DNLOAD in %s returned %v, want %v", StateError, err, ErrStall)
+	}
+	if st, _ := c.GetState(); st != StateError {
+		t.Errorf("state %s, want %s", st, StateError)
+	}
+}
+
+func TestFlashResumesFromError(t *testing.T) {
+	sim := NewSimulator(STM32F302CB)
+	c := newTestClient(sim)
+	// A previous attempt was interrupted with the device in dfuERROR
+	c.ClearStatus()
+	if st, _ := c.GetState(); st != StateError {
+		t.Fatalf("state %s, want %s", st, StateError)
+	}
+
+	elements := []Element{{Address: STM32F302CB.Base, Data: pattern(5000, 7)}}
+	if err := c.Flash(STM32F302CB, elements, FlashOptions{Verify: true, Leave: true}); err != nil {
+		t.Fatalf("Flash from %s: %v", StateError, err)
+	}
+	if got := sim.Read(elements[0].Address, len(elements[0].Data)); !bytes.Equal(got, elements[0].Data) {
+		t.Errorf("image not written")
+	}
+	if !sim.Manifested() {
+		t.Errorf("device did not leave DFU mode")
+	}
+}
+
+// dirtyFlash is a Transport that writes to the simulated flash behind the
+// bootloader's back just before the first data block, as if the erase had
+// failed
+type dirtyFlash struct {
+	*Simulator
+	done bool
+}
+
+func (d *dirtyFlash) ControlOut(req Request, value uint16, data []byte) error {
+	if req == ReqDnload && value >= 2 && len(data) > 0 && !d.done {
+		d.done = true
+		d.Memory[0] = 0x00
+	}
+	return d.Simulator.ControlOut(req, value, data)
+}
+
+func TestFlashErrorThenRetry(t *testing.T) {
+	sim := NewSimulator(STM32F302CB)
+	elements := []Element{{Address: STM32F302CB.Base, Data: pattern(3000, 8)}}
+	err := newTestClient(&dirtyFlash{Simulator: sim}).Flash(STM32F302CB, elements, FlashOptions{Verify: true, Leave: true})
+	var serr *StatusError
+	if !errors.As(err, &serr) || serr.Status != StatusErrCheckErased {
+		t.Fatalf("Flash returned %v, want %s", err, StatusErrCheckErased)
+	}
+	if sim.Manifested() {
+		t.Fatalf("device left DFU mode after a failed write")
+	}
+
+	// Flashing again erases the page and completes
+	if err := newTestClient(sim).Flash(STM32F302CB, elements, FlashOptions{Verify: true, Leave: true}); err != nil {
+		t.Fatalf("second Flash: %v", err)
+	}
+	if got := sim.Read(elements[0].Address, len(elements[0].Data)); !bytes.Equal(got, elements[0].Data) {
+		t.Errorf("image not written")
+	}
+}
@@ -0,0 +1,318 @@
+package dfu
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"regexp"
+	"strings"
+)
+
+// Format is the container format of a firmware image
+type Format int
+
+// Image formats
+const (
+	FormatBinary Format = iota // raw flash contents, optionally with a DFU suffix
+	FormatDfuSe                // ST DfuSe container
+)
+
+func (f Format) String() string {
+	if f == FormatDfuSe {
+		return "DfuSe"
+	}
+	return "binary"
+}
+
+// Element is a block of data to be written at Address
+type Element struct {
+	Address uint32
+	Data    []byte
+}
+
+// Target is one alternate setting of a DfuSe image
+type Target struct {
+	AltSetting uint8
+	Name       string
+	Elements   []Element
+}
+
+// Suffix is the DFU file suffix
+type Suffix struct {
+	BCDDevice uint16
+	ProductID uint16
+	VendorID  uint16
+	BCDDFU    uint16
+	CRC       uint32
+}
+
+// Image is a parsed firmware image
+type Image struct {
+	Format   Format
+	FileSize int
+	Suffix   *Suffix // nil for a binary without a suffix
+	Targets  []Target
+}
+
+// ErrInvalidImage is wrapped by every image parsing error
+var ErrInvalidImage = errors.New("invalid firmware image")
+
+// DFU suffix and DfuSe container sizes
+const (
+	suffixSize       = 16
+	dfusePrefixSize  = 11
+	dfuseTargetSize  = 274
+	dfuseElementSize = 8
+)
+
+// dfuCRC is the CRC used in DFU suffixes: CRC-32 without the final
+// inversion
+func dfuCRC(data []byte) uint32 {
+	return ^crc32.ChecksumIEEE(data)
+}
+
+// parseSuffix returns the DFU suffix at the end of data, or nil if there is
+// none. A suffix with a wrong CRC is an error.
+func parseSuffix(data []byte) (*Suffix, error) {
+	if len(data) < suffixSize {
+		return nil, nil
+	}
+	s := data[len(data)-suffixSize:]
+	if string(s[8:11]) != "UFD" || s[11] != suffixSize {
+		return nil, nil
+	}
+	suffix := &Suffix{
+		BCDDevice: binary.LittleEndian.Uint16(s[0:]),
+		ProductID: binary.LittleEndian.Uint16(s[2:]),
+		VendorID:  binary.LittleEndian.Uint16(s[4:]),
+		BCDDFU:    binary.LittleEndian.Uint16(s[6:]),
+		CRC:       binary.LittleEndian.Uint32(s[12:]),
+	}
+	if crc := dfuCRC(data[:len(data)-4]); crc != suffix.CRC {
+		return nil, fmt.Errorf("%w: DFU suffix CRC is 0x%08x, computed 0x%08x", ErrInvalidImage, suffix.CRC, crc)
+	}
+	return suffix, nil
+}
+
+// ParseImage parses a DfuSe container, or a raw binary to be written at
+// base. A DFU suffix on either is checked and removed.
+func ParseImage(data []byte, base uint32) (*Image, error) {
+	suffix, err := parseSuffix(data)
+	if err != nil {
+		return nil, err
+	}
+	body := data
+	if suffix != nil {
+		body = data[:len(data)-suffixSize]
+	}
+
+	if !bytes.HasPrefix(body, []byte("DfuSe")) {
+		if len(body) == 0 {
+			return nil, fmt.Errorf("%w: image is empty", ErrInvalidImage)
+		}
+		return &Image{
+			Format:   FormatBinary,
+			FileSize: len(data),
+			Suffix:   suffix,
+			Targets:  []Target{{Elements: []Element{{Address: base, Data: body}}}},
+		}, nil
+	}
+
+	if suffix == nil {
+		return nil, fmt.Errorf("%w: DfuSe image has no DFU suffix", ErrInvalidImage)
+	}
+	img := &Image{Format: FormatDfuSe, FileSize: len(data), Suffix: suffix}
+	if len(body) < dfusePrefixSize {
+		return nil, fmt.Errorf("%w: truncated DfuSe prefix", ErrInvalidImage)
+	}
+	if body[5] != 0x01 {
+		return nil, fmt.Errorf("%w: unsupported DfuSe version %d", ErrInvalidImage, body[5])
+	}
+	if size := binary.LittleEndian.Uint32(body[6:]); int(size) != len(body) {
+		return nil, fmt.Errorf("%w: DfuSe prefix gives size %d, image is %d bytes", ErrInvalidImage, size, len(body))
+	}
+	ntargets := int(body[10])
+	off := dfusePrefixSize
+	for t := 0; t < ntargets; t++ {
+		if len(body)-off < dfuseTargetSize {
+			return nil, fmt.Errorf("%w: truncated prefix of target %d", ErrInvalidImage, t)
+		}
+		p := body[off : off+dfuseTargetSize]
+		if string(p[:6]) != "Target" {
+			return nil, fmt.Errorf("%w: bad signature of target %d", ErrInvalidImage, t)
+		}
+		target := Target{AltSetting: p[6]}
+		if binary.LittleEndian.Uint32(p[7:]) != 0 {
+			target.Name = strings.TrimRight(string(p[11:266]), "\x00")
+		}
+		size := int(binary.LittleEndian.Uint32(p[266:]))
+		nelements := int(binary.LittleEndian.Uint32(p[270:]))
+		off += dfuseTargetSize
+		if size > len(body)-off {
+			return nil, fmt.Errorf("%w: target %d is %d bytes, only %d left", ErrInvalidImage, t, size, len(body)-off)
+		}
+		end := off + size
+		for e := 0; e < nelements; e++ {
+			if end-off < dfuseElementSize {
+				return nil, fmt.Errorf("%w: truncated element %d of target %d", ErrInvalidImage, e, t)
+			}
+			addr := binary.LittleEndian.Uint32(body[off:])
+			n := int(binary.LittleEndian.Uint32(body[off+4:]))
+			off += dfuseElementSize
+			if n > end-off {
+				return nil, fmt.Errorf("%w: element %d of target %d overruns the target", ErrInvalidImage, e, t)
+			}
+			target.Elements = append(target.Elements, Element{Address: addr, Data: body[off : off+n]})
+			off += n
+		}
+		if off != end {
+			return nil, fmt.Errorf("%w: target %d has %d bytes after its elements", ErrInvalidImage, t, end-off)
+		}
+		img.Targets = append(img.Targets, target)
+	}
+	if off != len(body) {
+		return nil, fmt.Errorf("%w: %d bytes after the last target", ErrInvalidImage, len(body)-off)
+	}
+	return img, nil
+}
+
+// Elements returns the elements of alternate setting 0, the internal flash
+func (img *Image) Elements() []Element {
+	var elements []Element
+	for _, t := range img.Targets {
+		if t.AltSetting == 0 {
+			elements = append(elements, t.Elements...)
+		}
+	}
+	return elements
+}
+
+// Size returns the number of bytes in Elements
+func (img *Image) Size() int {
+	n := 0
+	for _, e := range img.Elements() {
+		n += len(e.Data)
+	}
+	return n
+}
+
+// VectorTable returns the initial stack pointer and reset handler stored at
+// addr, where a Cortex-M image starts
+func (img *Image) VectorTable(addr uint32) (sp, reset uint32, ok bool) {
+	b := img.Read(addr, 8)
+	if b == nil {
+		return 0, 0, false
+	}
+	return binary.LittleEndian.Uint32(b), binary.LittleEndian.Uint32(b[4:]), true
+}
+
+// Read returns n bytes of element data at addr, or nil if no single
+// element holds them
+func (img *Image) Read(addr uint32, n int) []byte {
+	for _, e := range img.Elements() {
+		if addr >= e.Address && uint64(addr)+uint64(n) <= uint64(e.Address)+uint64(len(e.Data)) {
+			off := addr - e.Address
+			return e.Data[off : off+uint32(n)]
+		}
+	}
+	return nil
+}
+
+// Validate checks that the image holds data for layout, that every element
+// fits in its flash and that it starts with a vector table for its memory.
+// An image built for another board usually fails the last check.
+func (img *Image) Validate(layout Layout) error {
+	elements := img.Elements()
+	if len(elements) == 0 {
+		return fmt.Errorf("%w: no data for the internal flash", ErrInvalidImage)
+	}
+	var errs []error
+	for i, e := range elements {
+		if len(e.Data) == 0 {
+			errs = append(errs, fmt.Errorf("element %d at 0x%08x is empty", i, e.Address))
+			continue
+		}
+		if err := layout.Check(e.Address, len(e.Data)); err != nil {
+			errs = append(errs, fmt.Errorf("element %d: %w", i, err))
+		}
+		for j := 0; j < i; j++ {
+			o := elements[j]
+			if e.Address < o.Address+uint32(len(o.Data)) && o.Address < e.Address+uint32(len(e.Data)) {
+				errs = append(errs, fmt.Errorf("element %d overlaps element %d", i, j))
+			}
+		}
+	}
+	if sp, reset, ok := img.VectorTable(layout.Base); !ok {
+		errs = append(errs, fmt.Errorf("no vector table at the start of flash (0x%08x)", layout.Base))
+	} else {
+		if sp <= layout.RAMBase || sp > layout.RAMBase+layout.RAMSize {
+			errs = append(errs, fmt.Errorf("initial stack pointer 0x%08x is outside %s RAM (0x%08x-0x%08x)",
+				sp, layout.Name, layout.RAMBase, layout.RAMBase+layout.RAMSize))
+		}
+		// Cortex-M runs Thumb code only, so the handler address is odd
+		if reset&1 == 0 || layout.Check(reset&^1, 2) != nil {
+			errs = append(errs, fmt.Errorf("reset handler 0x%08x is not Thumb code in %s flash", reset, layout.Name))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidImage, err)
+	}
+	return nil
+}
+
+// FindDeviceDescriptor searches the image for the USB device descriptor of
+// vid and pid and returns the address and bcdDevice field of the first one
+func (img *Image) FindDeviceDescriptor(vid, pid uint16) (addr uint32, bcdDevice uint16, ok bool) {
+	pattern := make([]byte, 4)
+	binary.LittleEndian.PutUint16(pattern, vid)
+	binary.LittleEndian.PutUint16(pattern[2:], pid)
+	for _, e := range img.Elements() {
+		for off := 0; off+18 <= len(e.Data); off++ {
+			d := e.Data[off:]
+			// bLength 18, bDescriptorType DEVICE, idVendor and idProduct at 8
+			if d[0] == 18 && d[1] == 0x01 && bytes.Equal(d[8:12], pattern) {
+				return e.Address + uint32(off), binary.LittleEndian.Uint16(d[12:]), true
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// EmbeddedString is a printable string holding a version number, found in
+// the image data
+type EmbeddedString struct {
+	Address uint32
+	Text    string // the whole string
+	Version string // the version number in it
+}
+
+// versionPattern matches version numbers such as v1.4, 1.4.0 or 1.4.0-rc1.
+// Two part numbers need a "v" to stand out from other text.
+var versionPattern = regexp.MustCompile(`\bv\d{1,3}\.\d{1,3}(\.\d{1,3})?([-+][0-9A-Za-z.]+)?\b|\b\d{1,3}\.\d{1,3}\.\d{1,3}([-+][0-9A-Za-z.]+)?\b`)
+
+// FindVersionStrings returns the printable strings of at least four
+// characters in the image that contain a version number
+func (img *Image) FindVersionStrings() []EmbeddedString {
+	var found []EmbeddedString
+	for _, e := range img.Elements() {
+		start := -1
+		for i := 0; i <= len(e.Data); i++ {
+			if i < len(e.Data) && e.Data[i] >= 0x20 && e.Data[i] < 0x7F {
+				if start < 0 {
+					start = i
+				}
+				continue
+			}
+			if start >= 0 && i-start >= 4 {
+				text := string(e.Data[start:i])
+				if v := versionPattern.FindString(text); v != "" {
+					found = append(found, EmbeddedString{Address: e.Address + uint32(start), Text: text, Version: v})
+				}
+			}
+			start = -1
+		}
+	}
+	return found
+}
@@ -0,0 +1,183 @@
+package dfu
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// withSuffix appends a DFU suffix for the AIOC to data
+func withSuffix(data []byte) []byte {
+	s := make([]byte, suffixSize)
+	binary.LittleEndian.PutUint16(s[0:], 0x0100)
+	binary.LittleEndian.PutUint16(s[2:], 0x7388)
+	binary.LittleEndian.PutUint16(s[4:], 0x1209)
+	binary.LittleEndian.PutUint16(s[6:], 0x011A)
+	copy(s[8:], "UFD")
+	s[11] = suffixSize
+	out := append(append([]byte(nil), data...), s...)
+	binary.LittleEndian.PutUint32(out[len(out)-4:], dfuCRC(out[:len(out)-4]))
+	return out
+}
+
+// dfuse builds a DfuSe container with one target of elements, without a
+// suffix
+func dfuse(name string, elements ...Element) []byte {
+	var body []byte
+	for _, e := range elements {
+		h := make([]byte, dfuseElementSize)
+		binary.LittleEndian.PutUint32(h, e.Address)
+		binary.LittleEndian.PutUint32(h[4:], uint32(len(e.Data)))
+		body = append(append(body, h...), e.Data...)
+	}
+	target := make([]byte, dfuseTargetSize)
+	copy(target, "Target")
+	if name != "" {
+		target[7] = 1
+		copy(target[11:], name)
+	}
+	binary.LittleEndian.PutUint32(target[266:], uint32(len(body)))
+	binary.LittleEndian.PutUint32(target[270:], uint32(len(elements)))
+
+	prefix := make([]byte, dfusePrefixSize)
+	copy(prefix, "DfuSe")
+	prefix[5] = 0x01
+	binary.LittleEndian.PutUint32(prefix[6:], uint32(dfusePrefixSize+len(target)+len(body)))
+	prefix[10] = 1
+	return append(append(prefix, target...), body...)
+}
+
+func TestParseBinary(t *testing.T) {
+	data := pattern(1000, 1)
+	for _, tc := range []struct {
+		name   string
+		file   []byte
+		suffix bool
+	}{
+		{"plain", data, false},
+		{"with suffix", withSuffix(data), true},
+	} {
+		img, err := ParseImage(tc.file, STM32F302CB.Base)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if img.Format != FormatBinary || (img.Suffix != nil) != tc.suffix || img.FileSize != len(tc.file) {
+			t.Errorf("%s: format %s, suffix %v, size %d", tc.name, img.Format, img.Suffix, img.FileSize)
+		}
+		elements := img.Elements()
+		if len(elements) != 1 || elements[0].Address != STM32F302CB.Base || !bytes.Equal(elements[0].Data, data) {
+			t.Errorf("%s: suffix not removed from the data", tc.name)
+		}
+	}
+
+	img, err := ParseImage(withSuffix(data), STM32F302CB.Base)
+	if err != nil {
+		t.Fatalf("ParseImage: %v", err)
+	}
+	if s := img.Suffix; s.VendorID != 0x1209 || s.ProductID != 0x7388 || s.BCDDevice != 0x0100 || s.BCDDFU != 0x011A {
+		t.Errorf("suffix decoded as %+v", *s)
+	}
+}
+
+func TestParseSuffixCRC(t *testing.T) {
+	for _, corrupt := range []int{0, 500, 1000 + 3} {
+		file := withSuffix(pattern(1000, 2))
+		file[corrupt] ^= 0x80
+		if _, err := ParseImage(file, STM32F302CB.Base); !errors.Is(err, ErrInvalidImage) {
+			t.Errorf("byte %d corrupted: got %v, want %v", corrupt, err, ErrInvalidImage)
+		}
+	}
+}
+
+func TestParseDfuSe(t *testing.T) {
+	elements := []Element{
+		{Address: 0x08000000, Data: pattern(600, 3)},
+		{Address: 0x08004000, Data: pattern(100, 4)},
+	}
+	img, err := ParseImage(withSuffix(dfuse("ST...", elements...)), 0)
+	if err != nil {
+		t.Fatalf("ParseImage: %v", err)
+	}
+	if img.Format != FormatDfuSe || len(img.Targets) != 1 || img.Targets[0].Name != "ST..." {
+		t.Fatalf("format %s, targets %+v", img.Format, img.Targets)
+	}
+	got := img.Elements()
+	if len(got) != len(elements) {
+		t.Fatalf("%d elements, want %d", len(got), len(elements))
+	}
+	for i := range elements {
+		if got[i].Address != elements[i].Address || !bytes.Equal(got[i].Data, elements[i].Data) {
+			t.Errorf("element %d differs", i)
+		}
+	}
+	if img.Size() != 700 {
+		t.Errorf("size %d, want 700", img.Size())
+	}
+}
+
+func TestParseDfuSeInvalid(t *testing.T) {
+	valid := dfuse("", Element{Address: 0x08000000, Data: pattern(64, 5)})
+	// setSize fixes the prefix size after the body is changed, so only
+	// the intended check fails
+	setSize := func(b []byte) []byte {
+		binary.LittleEndian.PutUint32(b[6:], uint32(len(b)))
+		return b
+	}
+	edit := func(f func(b []byte) []byte) []byte {
+		return f(append([]byte(nil), valid...))
+	}
+	for _, tc := range []struct {
+		name string
+		file []byte
+	}{
+		{"no suffix", valid},
+		{"truncated prefix", withSuffix(valid[:8])},
+		{"truncated target prefix", withSuffix(edit(func(b []byte) []byte { return setSize(b[:dfusePrefixSize+100]) }))},
+		{"truncated element", withSuffix(edit(func(b []byte) []byte { return setSize(b[:len(b)-10]) }))},
+		{"wrong size", withSuffix(edit(func(b []byte) []byte { return b[:len(b)-10] }))},
+		{"wrong version", withSuffix(edit(func(b []byte) []byte { b[5] = 2; return b }))},
+		{"bad target signature", withSuffix(edit(func(b []byte) []byte { b[dfusePrefixSize] = 'X'; return b }))},
+		{"trailing bytes", withSuffix(edit(func(b []byte) []byte { return setSize(append(b, 0, 0)) }))},
+		{"element overruns target", withSuffix(edit(func(b []byte) []byte {
+			binary.LittleEndian.PutUint32(b[dfusePrefixSize+dfuseTargetSize+4:], 65)
+			return b
+		}))},
+	} {
+		if _, err := ParseImage(tc.file, 0); !errors.Is(err, ErrInvalidImage) {
+			t.Errorf("%s: got %v, want %v", tc.name, err, ErrInvalidImage)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	layout := STM32F302CB
+	vectors := func(sp, reset uint32) []byte {
+		b := pattern(256, 6)
+		binary.LittleEndian.PutUint32(b, sp)
+		binary.LittleEndian.PutUint32(b[4:], reset)
+		return b
+	}
+	for _, tc := range []struct {
+		name  string
+		base  uint32
+		data  []byte
+		valid bool
+	}{
+		{"valid", layout.Base, vectors(0x20008000, 0x08000101), true},
+		{"stack outside RAM", layout.Base, vectors(0x10002000, 0x08000101), false},
+		{"ARM reset handler", layout.Base, vectors(0x20008000, 0x08000100), false},
+		{"reset handler outside flash", layout.Base, vectors(0x20008000, 0x00000101), false},
+		{"not at the start of flash", layout.Base + 0x4000, vectors(0x20008000, 0x08000101), false},
+		{"past the end of flash", layout.End() - 128, vectors(0x20008000, 0x08000101), false},
+	} {
+		img, err := ParseImage(tc.data, tc.base)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if err := img.Validate(layout); (err == nil) != tc.valid {
+			t.Errorf("%s: Validate returned %v", tc.name, err)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package dfu
+
+import "fmt"
+
+// USB IDs of the STM32 system bootloader
+const (
+	BootloaderVendorID  = 0x0483
+	BootloaderProductID = 0xDF11
+)
+
+// Interface protocols of the DFU class (0xFE, subclass 0x01)
+const (
+	ProtocolRuntime = 1 // the application, which can detach to DFU mode
+	ProtocolDFU     = 2 // the bootloader
+)
+
+// Layout describes the flash memory of a microcontroller, and its RAM for
+// checking the vector table of an image
+type Layout struct {
+	Name     string
+	Base     uint32
+	Size     uint32
+	PageSize uint32
+	RAMBase  uint32
+	RAMSize  uint32
+}
+
+// STM32F302CB is the microcontroller on the AIOC: 128 KiB of flash in 2 KiB
+// pages and 32 KiB of RAM
+var STM32F302CB = Layout{
+	Name:     "STM32F302CB",
+	Base:     0x08000000,
+	Size:     128 << 10,
+	PageSize: 2 << 10,
+	RAMBase:  0x20000000,
+	RAMSize:  32 << 10,
+}
+
+// End returns the address after the last byte of flash
+func (l Layout) End() uint32 {
+	return l.Base + l.Size
+}
+
+// Check returns an error unless n bytes at addr lie within flash
+func (l Layout) Check(addr uint32, n int) error {
+	if addr < l.Base || uint64(addr)+uint64(n) > uint64(l.End()) {
+		return fmt.Errorf("0x%08x-0x%08x is outside %s flash (0x%08x-0x%08x)",
+			addr, uint64(addr)+uint64(n), l.Name, l.Base, l.End())
+	}
+	return nil
+}
+
+// Pages returns the start addresses of the pages overlapping n bytes at
+// addr
+func (l Layout) Pages(addr uint32, n int) []uint32 {
+	if n <= 0 {
+		return nil
+	}
+	var pages []uint32
+	first := l.Base + (addr-l.Base)/l.PageSize*l.PageSize
+	for p := first; uint64(p) < uint64(addr)+uint64(n); p += l.PageSize {
+		pages = append(pages, p)
+	}
+	return pages
+}
@@ -0,0 +1,226 @@
+package dfu
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// Simulated operation times reported as bwPollTimeout
+const (
+	simEraseTime   = 20 * time.Millisecond
+	simProgramTime = 2 * time.Millisecond
+)
+
+// Simulator is a Transport emulating the STM32 system bootloader on a flash
+// held in memory. It follows the DFU state machine and DfuSe commands
+// closely enough to exercise every step of Client: errors stall the request
+// or put the device in dfuERROR like the real bootloader does.
+type Simulator struct {
+	Layout Layout
+	// Memory is the simulated flash, Layout.Size bytes; erased bytes are 0xFF
+	Memory []byte
+	// Requests counts the requests received, by type
+	Requests map[Request]int
+
+	state        State
+	status       Status
+	poll         time.Duration
+	pointer      uint32
+	pending      []byte
+	block        uint16
+	transferSize int
+	manifested   bool
+}
+
+// NewSimulator returns a simulated bootloader in dfuIDLE with an erased
+// flash of layout
+func NewSimulator(layout Layout) *Simulator {
+	s := &Simulator{
+		Layout:       layout,
+		Memory:       make([]byte, layout.Size),
+		Requests:     make(map[Request]int),
+		state:        StateIdle,
+		pointer:      layout.Base,
+		transferSize: 2048,
+	}
+	for i := range s.Memory {
+		s.Memory[i] = 0xFF
+	}
+	return s
+}
+
+// Manifested reports whether the device has left DFU mode to start the
+// firmware
+func (s *Simulator) Manifested() bool {
+	return s.manifested
+}
+
+// Read returns n bytes of the simulated flash at addr
+func (s *Simulator) Read(addr uint32, n int) []byte {
+	if s.Layout.Check(addr, n) != nil {
+		return nil
+	}
+	off := addr - s.Layout.Base
+	return append([]byte(nil), s.Memory[off:off+uint32(n)]...)
+}
+
+// TransferSize implements Transport
+func (s *Simulator) TransferSize() int {
+	return s.transferSize
+}
+
+// Close implements Transport
+func (s *Simulator) Close() error {
+	return nil
+}
+
+// fail puts the device in dfuERROR with status
+func (s *Simulator) fail(status Status) {
+	s.state = StateError
+	s.status = status
+}
+
+// stall rejects a request that is not valid in the current state
+func (s *Simulator) stall() error {
+	s.fail(StatusErrStalledPkt)
+	return ErrStall
+}
+
+// ControlOut implements Transport
+func (s *Simulator) ControlOut(req Request, value uint16, data []byte) error {
+	if s.manifested {
+		return ErrDisconnected
+	}
+	s.Requests[req]++
+	switch req {
+	case ReqDnload:
+		if s.state != StateIdle && s.state != StateDnloadIdle {
+			return s.stall()
+		}
+		if len(data) > s.transferSize {
+			return s.stall()
+		}
+		if len(data) == 0 {
+			s.state = StateManifestSync
+			return nil
+		}
+		s.pending = append(s.pending[:0], data...)
+		s.block = value
+		s.state = StateDnloadSync
+		return nil
+	case ReqClrStatus:
+		if s.state != StateError {
+			return s.stall()
+		}
+		s.state, s.status = StateIdle, StatusOK
+		return nil
+	case ReqAbort:
+		switch s.state {
+		case StateIdle, StateDnloadSync, StateDnloadIdle, StateManifestSync, StateUploadIdle:
+			s.state = StateIdle
+			return nil
+		}
+		return s.stall()
+	}
+	return s.stall()
+}
+
+// ControlIn implements Transport
+func (s *Simulator) ControlIn(req Request, value uint16, length int) ([]byte, error) {
+	if s.manifested {
+		return nil, ErrDisconnected
+	}
+	s.Requests[req]++
+	switch req {
+	case ReqGetStatus:
+		s.poll = 0
+		switch s.state {
+		case StateDnloadSync:
+			s.execute()
+		case StateDnbusy:
+			s.state = StateDnloadIdle
+		case StateManifestSync:
+			// The bootloader jumps to the firmware and leaves the bus
+			s.state = StateManifest
+			s.manifested = true
+		}
+		b := make([]byte, 6)
+		b[0] = byte(s.status)
+		ms := uint32(s.poll / time.Millisecond)
+		b[1], b[2], b[3] = byte(ms), byte(ms>>8), byte(ms>>16)
+		b[4] = byte(s.state)
+		return b[:min(length, 6)], nil
+	case ReqGetState:
+		return []byte{byte(s.state)}[:min(length, 1)], nil
+	case ReqUpload:
+		if s.state != StateIdle && s.state != StateUploadIdle {
+			return nil, s.stall()
+		}
+		if value < 2 || length > s.transferSize {
+			return nil, s.stall()
+		}
+		addr := uint64(s.pointer) + uint64(value-2)*uint64(s.transferSize)
+		if addr >= uint64(s.Layout.End()) || addr < uint64(s.Layout.Base) {
+			s.fail(StatusErrAddress)
+			return nil, ErrStall
+		}
+		s.state = StateUploadIdle
+		n := min(uint64(length), uint64(s.Layout.End())-addr)
+		off := addr - uint64(s.Layout.Base)
+		return append([]byte(nil), s.Memory[off:off+n]...), nil
+	}
+	return nil, s.stall()
+}
+
+// execute runs the pending DNLOAD block when the host asks for the status
+func (s *Simulator) execute() {
+	s.state = StateDnbusy
+	data := s.pending
+	switch {
+	case s.block == 0:
+		s.command(data)
+	case s.block == 1:
+		s.fail(StatusErrStalledPkt)
+	default:
+		addr := uint64(s.pointer) + uint64(s.block-2)*uint64(s.transferSize)
+		if addr < uint64(s.Layout.Base) || addr+uint64(len(data)) > uint64(s.Layout.End()) {
+			s.fail(StatusErrAddress)
+			return
+		}
+		off := addr - uint64(s.Layout.Base)
+		for i := range data {
+			if s.Memory[off+uint64(i)] != 0xFF {
+				s.fail(StatusErrCheckErased)
+				return
+			}
+		}
+		copy(s.Memory[off:], data)
+		s.poll = simProgramTime
+	}
+}
+
+// command runs a DfuSe command sent to block 0
+func (s *Simulator) command(data []byte) {
+	switch {
+	case len(data) == 5 && data[0] == dfuseSetAddress:
+		s.pointer = binary.LittleEndian.Uint32(data[1:])
+	case len(data) == 5 && data[0] == dfuseErase:
+		addr := binary.LittleEndian.Uint32(data[1:])
+		if s.Layout.Check(addr, 1) != nil {
+			s.fail(StatusErrAddress)
+			return
+		}
+		page := (addr - s.Layout.Base) / s.Layout.PageSize * s.Layout.PageSize
+		for i := page; i < page+s.Layout.PageSize; i++ {
+			s.Memory[i] = 0xFF
+		}
+		s.poll = simEraseTime
+	case len(data) == 1 && data[0] == dfuseErase:
+		for i := range s.Memory {
+			s.Memory[i] = 0xFF
+		}
+		s.poll = simEraseTime
+	default:
+		s.fail(StatusErrStalledPkt)
+	}
+}
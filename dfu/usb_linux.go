@@ -0,0 +1,357 @@
+//go:build linux
+
+package dfu
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// sysfsDevices lists the USB devices known to the kernel
+const sysfsDevices = "/sys/bus/usb/devices"
+
+// usbfs ioctls from linux/usbdevice_fs.h, encoded as _IOC(dir, 'U', nr, size)
+const (
+	iocWrite = 1
+	iocRead  = 2
+)
+
+func usbIoctlNumber(dir, nr, size uintptr) uintptr {
+	return dir<<30 | size<<16 | 'U'<<8 | nr
+}
+
+// ctrlTransfer is struct usbdevfs_ctrltransfer
+type ctrlTransfer struct {
+	RequestType uint8
+	Request     uint8
+	Value       uint16
+	Index       uint16
+	Length      uint16
+	Timeout     uint32 // milliseconds
+	Data        unsafe.Pointer
+}
+
+// setInterface is struct usbdevfs_setinterface
+type setInterface struct {
+	Interface  uint32
+	AltSetting uint32
+}
+
+var (
+	usbdevfsControl          = usbIoctlNumber(iocRead|iocWrite, 0, unsafe.Sizeof(ctrlTransfer{}))
+	usbdevfsSetInterface     = usbIoctlNumber(iocRead, 4, unsafe.Sizeof(setInterface{}))
+	usbdevfsClaimInterface   = usbIoctlNumber(iocRead, 15, unsafe.Sizeof(uint32(0)))
+	usbdevfsReleaseInterface = usbIoctlNumber(iocRead, 16, unsafe.Sizeof(uint32(0)))
+	usbdevfsReset            = usbIoctlNumber(0, 20, 0)
+)
+
+// Class request types addressed to an interface
+const (
+	requestTypeOut = 0x21
+	requestTypeIn  = 0xA1
+)
+
+// controlTimeout bounds each control transfer
+const controlTimeout = 5 * time.Second
+
+// USB descriptor types
+const (
+	descInterface   = 0x04
+	descDFUFunction = 0x21
+)
+
+// bitWillDetach in bmAttributes of the DFU functional descriptor: the
+// device detaches by itself after DETACH instead of waiting for a reset
+const attrWillDetach = 0x08
+
+// USBDevice is a Transport over Linux usbfs to the DFU interface of a USB
+// device
+type USBDevice struct {
+	f             *os.File
+	iface         uint8
+	protocol      uint8
+	attributes    uint8
+	transferSize  int
+	detachTimeout time.Duration
+	serial        string
+}
+
+// usbEntry is a USB device found in sysfs
+type usbEntry struct {
+	dir    string
+	vid    uint16
+	pid    uint16
+	serial string
+	busnum int
+	devnum int
+}
+
+// dfuInterface is the DFU interface found in the descriptors of a device
+type dfuInterface struct {
+	number        uint8
+	protocol      uint8
+	attributes    uint8
+	transferSize  int
+	detachTimeout time.Duration
+}
+
+func readSysfs(dir, name string) string {
+	b, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// findUSB lists the devices matching vid, pid and, if not empty, serial
+func findUSB(vid, pid uint16, serial string) ([]usbEntry, error) {
+	dirs, err := os.ReadDir(sysfsDevices)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list USB devices: %w", err)
+	}
+	var found []usbEntry
+	for _, d := range dirs {
+		// Interfaces are listed as bus-port:config.interface
+		if strings.Contains(d.Name(), ":") {
+			continue
+		}
+		dir := filepath.Join(sysfsDevices, d.Name())
+		v, err1 := strconv.ParseUint(readSysfs(dir, "idVendor"), 16, 16)
+		p, err2 := strconv.ParseUint(readSysfs(dir, "idProduct"), 16, 16)
+		if err1 != nil || err2 != nil || uint16(v) != vid || uint16(p) != pid {
+			continue
+		}
+		e := usbEntry{dir: dir, vid: uint16(v), pid: uint16(p), serial: readSysfs(dir, "serial")}
+		if serial != "" && e.serial != serial {
+			continue
+		}
+		e.busnum, _ = strconv.Atoi(readSysfs(dir, "busnum"))
+		e.devnum, _ = strconv.Atoi(readSysfs(dir, "devnum"))
+		found = append(found, e)
+	}
+	return found, nil
+}
+
+// parseDFUInterface finds the first DFU interface in raw descriptors. The
+// DFU functional descriptor follows the last alternate setting of the
+// interface.
+func parseDFUInterface(desc []byte) (dfuInterface, bool) {
+	var dfu dfuInterface
+	found := false
+	for off := 0; off+2 <= len(desc); {
+		n := int(desc[off])
+		if n < 2 || off+n > len(desc) {
+			break
+		}
+		d := desc[off : off+n]
+		switch d[1] {
+		case descInterface:
+			if !found && n >= 9 && d[5] == 0xFE && d[6] == 0x01 {
+				dfu = dfuInterface{number: d[2], protocol: d[7], transferSize: 1024}
+				found = true
+			}
+		case descDFUFunction:
+			if found && n >= 7 {
+				dfu.attributes = d[2]
+				dfu.detachTimeout = time.Duration(binary.LittleEndian.Uint16(d[3:])) * time.Millisecond
+				dfu.transferSize = int(binary.LittleEndian.Uint16(d[5:]))
+				return dfu, true
+			}
+		}
+		off += n
+	}
+	return dfu, found
+}
+
+// OpenUSB opens the DFU interface of the device with vid and pid and, if
+// not empty, serial. It serves both the run-time interface of an
+// application and the bootloader.
+func OpenUSB(vid, pid uint16, serial string) (*USBDevice, error) {
+	entries, err := findUSB(vid, pid, serial)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no USB device %04x:%04x found", vid, pid)
+	}
+	if len(entries) > 1 {
+		return nil, fmt.Errorf("%d USB devices %04x:%04x found, select one by serial number", len(entries), vid, pid)
+	}
+	e := entries[0]
+
+	desc, err := os.ReadFile(filepath.Join(e.dir, "descriptors"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read USB descriptors: %w", err)
+	}
+	dfu, ok := parseDFUInterface(desc)
+	if !ok {
+		return nil, fmt.Errorf("USB device %04x:%04x has no DFU interface", vid, pid)
+	}
+
+	path := fmt.Sprintf("/dev/bus/usb/%03d/%03d", e.busnum, e.devnum)
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	d := &USBDevice{
+		f:             f,
+		iface:         dfu.number,
+		protocol:      dfu.protocol,
+		attributes:    dfu.attributes,
+		transferSize:  dfu.transferSize,
+		detachTimeout: dfu.detachTimeout,
+		serial:        e.serial,
+	}
+	n := uint32(d.iface)
+	if err := d.ioctl(usbdevfsClaimInterface, unsafe.Pointer(&n)); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to claim DFU interface %d: %w", d.iface, err)
+	}
+	if d.protocol == ProtocolDFU {
+		// Alternate setting 0 of the STM32 bootloader is the internal flash
+		alt := setInterface{Interface: uint32(d.iface)}
+		if err := d.ioctl(usbdevfsSetInterface, unsafe.Pointer(&alt)); err != nil {
+			d.Close()
+			return nil, fmt.Errorf("failed to select the internal flash: %w", err)
+		}
+	}
+	return d, nil
+}
+
+func (d *USBDevice) ioctl(req uintptr, arg unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, d.f.Fd(), req, uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// control runs one control transfer and returns the bytes transferred
+func (d *USBDevice) control(requestType uint8, req Request, value uint16, data []byte) (int, error) {
+	t := ctrlTransfer{
+		RequestType: requestType,
+		Request:     uint8(req),
+		Value:       value,
+		Index:       uint16(d.iface),
+		Length:      uint16(len(data)),
+		Timeout:     uint32(controlTimeout.Milliseconds()),
+	}
+	if len(data) > 0 {
+		t.Data = unsafe.Pointer(&data[0])
+	}
+	n, _, errno := syscall.Syscall(syscall.SYS_IOCTL, d.f.Fd(), usbdevfsControl, uintptr(unsafe.Pointer(&t)))
+	runtime.KeepAlive(data)
+	switch {
+	case errno == syscall.EPIPE:
+		return 0, ErrStall
+	case errno == syscall.ENODEV:
+		return 0, ErrDisconnected
+	case errno != 0:
+		return 0, errno
+	}
+	return int(n), nil
+}
+
+// ControlOut implements Transport
+func (d *USBDevice) ControlOut(req Request, value uint16, data []byte) error {
+	_, err := d.control(requestTypeOut, req, value, data)
+	return err
+}
+
+// ControlIn implements Transport
+func (d *USBDevice) ControlIn(req Request, value uint16, length int) ([]byte, error) {
+	buf := make([]byte, length)
+	n, err := d.control(requestTypeIn, req, value, buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// TransferSize implements Transport
+func (d *USBDevice) TransferSize() int {
+	return d.transferSize
+}
+
+// Protocol returns ProtocolRuntime or ProtocolDFU
+func (d *USBDevice) Protocol() int {
+	return int(d.protocol)
+}
+
+// DetachTimeout returns the longest time the device waits for a reset
+// after DETACH
+func (d *USBDevice) DetachTimeout() time.Duration {
+	return d.detachTimeout
+}
+
+// WillDetach implements Resetter
+func (d *USBDevice) WillDetach() bool {
+	return d.attributes&attrWillDetach != 0
+}
+
+// Reset implements Resetter with a USB port reset
+func (d *USBDevice) Reset() error {
+	if err := d.ioctl(usbdevfsReset, nil); err != nil {
+		if err == syscall.ENODEV {
+			return ErrDisconnected
+		}
+		return err
+	}
+	return nil
+}
+
+// Serial returns the USB serial number of the device
+func (d *USBDevice) Serial() string {
+	return d.serial
+}
+
+// Close releases the interface and closes the device
+func (d *USBDevice) Close() error {
+	n := uint32(d.iface)
+	d.ioctl(usbdevfsReleaseInterface, unsafe.Pointer(&n))
+	return d.f.Close()
+}
+
+// PresentUSB reports whether a device with vid and pid and, if not empty,
+// serial is connected
+func PresentUSB(vid, pid uint16, serial string) bool {
+	return CountUSB(vid, pid, serial) > 0
+}
+
+// CountUSB returns the number of connected devices with vid and pid and,
+// if not empty, serial
+func CountUSB(vid, pid uint16, serial string) int {
+	entries, _ := findUSB(vid, pid, serial)
+	return len(entries)
+}
+
+// WaitUSB waits until a device with vid and pid and, if not empty, serial
+// is present
+func WaitUSB(ctx context.Context, vid, pid uint16, serial string) error {
+	for {
+		entries, err := findUSB(vid, pid, serial)
+		if err != nil {
+			return err
+		}
+		if len(entries) > 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return fmt.Errorf("USB device %04x:%04x did not appear", vid, pid)
+			}
+			return ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,50 @@
+//go:build !linux
+
+package dfu
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"time"
+)
+
+// USBDevice is a Transport to the DFU interface of a USB device. It is only
+// implemented on Linux.
+type USBDevice struct{}
+
+// OpenUSB opens the DFU interface of a USB device
+func OpenUSB(vid, pid uint16, serial string) (*USBDevice, error) {
+	return nil, fmt.Errorf("USB DFU is not supported on %s", runtime.GOOS)
+}
+
+// PresentUSB reports whether a USB device is connected
+func PresentUSB(vid, pid uint16, serial string) bool {
+	return false
+}
+
+// CountUSB returns the number of connected USB devices
+func CountUSB(vid, pid uint16, serial string) int {
+	return 0
+}
+
+// WaitUSB waits until a USB device is present
+func WaitUSB(ctx context.Context, vid, pid uint16, serial string) error {
+	return fmt.Errorf("USB DFU is not supported on %s", runtime.GOOS)
+}
+
+// The Transport methods are never reached since OpenUSB always fails
+
+func (d *USBDevice) ControlOut(req Request, value uint16, data []byte) error { return nil }
+
+func (d *USBDevice) ControlIn(req Request, value uint16, length int) ([]byte, error) {
+	return nil, nil
+}
+
+func (d *USBDevice) TransferSize() int            { return 0 }
+func (d *USBDevice) Protocol() int                { return 0 }
+func (d *USBDevice) DetachTimeout() time.Duration { return 0 }
+func (d *USBDevice) WillDetach() bool             { return true }
+func (d *USBDevice) Reset() error                 { return nil }
+func (d *USBDevice) Serial() string               { return "" }
+func (d *USBDevice) Close() error                 { return nil }
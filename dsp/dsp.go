@@ -0,0 +1,90 @@
+// Package dsp measures audio levels for tuning the AIOC's audio settings.
+package dsp
+
+import (
+	"math"
+	"sort"
+)
+
+// FullScale is the magnitude of a full scale 16-bit sample
+const FullScale = 32768
+
+// clipLevel is the magnitude at which a sample counts as clipped
+const clipLevel = 32700
+
+// Stats are level measurements of a block of audio
+type Stats struct {
+	Samples    int
+	Peak       int     // largest sample magnitude
+	RMS        float64 // RMS level with the DC offset removed
+	DC         float64 // mean sample value
+	Clipped    int     // samples at or near full scale
+	NoiseFloor float64 // RMS of the quietest 10% of 50 ms blocks
+}
+
+// ClipFraction returns the fraction of samples that are clipped
+func (s Stats) ClipFraction() float64 {
+	if s.Samples == 0 {
+		return 0
+	}
+	return float64(s.Clipped) / float64(s.Samples)
+}
+
+// DBFS converts a 16-bit level to dB relative to full scale
+func DBFS(level float64) float64 {
+	if level <= 0 {
+		return math.Inf(-1)
+	}
+	return 20 * math.Log10(level/FullScale)
+}
+
+// Measure computes the level statistics of mono 16-bit audio
+func Measure(samples []int16, sampleRate int) Stats {
+	s := Stats{Samples: len(samples)}
+	if len(samples) == 0 {
+		return s
+	}
+
+	sum := 0.0
+	for _, v := range samples {
+		sum += float64(v)
+		m := int(v)
+		if m < 0 {
+			m = -m
+		}
+		s.Peak = max(s.Peak, m)
+		if m >= clipLevel {
+			s.Clipped++
+		}
+	}
+	s.DC = sum / float64(len(samples))
+	s.RMS = rms(samples, s.DC)
+
+	block := max(sampleRate/20, 1)
+	var blocks []float64
+	for i := 0; i+block <= len(samples); i += block {
+		blocks = append(blocks, rms(samples[i:i+block], s.DC))
+	}
+	if len(blocks) == 0 {
+		s.NoiseFloor = s.RMS
+		return s
+	}
+	sort.Float64s(blocks)
+	quiet := blocks[:max(len(blocks)/10, 1)]
+	sum = 0
+	for _, b := range quiet {
+		sum += b * b
+	}
+	s.NoiseFloor = math.Sqrt(sum / float64(len(quiet)))
+	return s
+}
+
+// rms returns the RMS level of samples around dc
+func rms(samples []int16, dc float64) float64 {
+	sum := 0.0
+	for _, v := range samples {
+		d := float64(v) - dc
+		sum += d * d
+	}
+	return math.Sqrt(sum / float64(len(samples)))
+}
@@ -0,0 +1,89 @@
+package dsp
+
+import (
+	"math"
+	"time"
+)
+
+// Amplitude converts a peak level in dBFS to a 16-bit amplitude
+func Amplitude(dbfs float64) float64 {
+	return math.Min(FullScale*math.Pow(10, dbfs/20), FullScale-1)
+}
+
+// synth renders a sine whose frequency at sample i is freq(i), keeping
+// the phase continuous across frequency changes
+func synth(n int, sampleRate int, amp float64, freq func(i int) float64) []float64 {
+	out := make([]float64, n)
+	phase := 0.0
+	for i := range out {
+		out[i] = amp * math.Sin(phase)
+		phase += 2 * math.Pi * freq(i) / float64(sampleRate)
+		if phase > 2*math.Pi {
+			phase -= 2 * math.Pi
+		}
+	}
+	return out
+}
+
+// samplesFor returns the number of samples in d at sampleRate
+func samplesFor(d time.Duration, sampleRate int) int {
+	return int(d.Seconds() * float64(sampleRate))
+}
+
+// toInt16 rounds and clamps float samples to 16 bits
+func toInt16(in []float64) []int16 {
+	out := make([]int16, len(in))
+	for i, v := range in {
+		out[i] = int16(math.Max(-FullScale, math.Min(FullScale-1, math.Round(v))))
+	}
+	return out
+}
+
+// Tone returns a sine of the given frequency and peak amplitude
+func Tone(sampleRate int, d time.Duration, freq, amp float64) []int16 {
+	return toInt16(synth(samplesFor(d, sampleRate), sampleRate, amp,
+		func(int) float64 { return freq }))
+}
+
+// TwoTone returns the sum of two equal sines whose combined peak is amp
+func TwoTone(sampleRate int, d time.Duration, f1, f2, amp float64) []int16 {
+	n := samplesFor(d, sampleRate)
+	a := synth(n, sampleRate, amp/2, func(int) float64 { return f1 })
+	b := synth(n, sampleRate, amp/2, func(int) float64 { return f2 })
+	for i := range a {
+		a[i] += b[i]
+	}
+	return toInt16(a)
+}
+
+// AFSK returns phase continuous FSK alternating between mark and space
+// every bit at baud, like a Bell 202 modem sending 0x55 bytes
+func AFSK(sampleRate int, d time.Duration, mark, space, baud, amp float64) []int16 {
+	return toInt16(synth(samplesFor(d, sampleRate), sampleRate, amp, func(i int) float64 {
+		if int(float64(i)*baud/float64(sampleRate))%2 == 0 {
+			return mark
+		}
+		return space
+	}))
+}
+
+// Sweep returns a sine sweeping logarithmically from one frequency to
+// another
+func Sweep(sampleRate int, d time.Duration, from, to, amp float64) []int16 {
+	n := samplesFor(d, sampleRate)
+	return toInt16(synth(n, sampleRate, amp, func(i int) float64 {
+		return from * math.Pow(to/from, float64(i)/float64(n))
+	}))
+}
+
+// Fade applies raised cosine ramps of length ramp to both ends of samples
+// to avoid clicks
+func Fade(samples []int16, sampleRate int, ramp time.Duration) {
+	n := min(samplesFor(ramp, sampleRate), len(samples)/2)
+	for i := 0; i < n; i++ {
+		g := 0.5 - 0.5*math.Cos(math.Pi*float64(i)/float64(n))
+		samples[i] = int16(math.Round(float64(samples[i]) * g))
+		j := len(samples) - 1 - i
+		samples[j] = int16(math.Round(float64(samples[j]) * g))
+	}
+}
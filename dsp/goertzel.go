@@ -0,0 +1,90 @@
+package dsp
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"time"
+)
+
+// ErrNoTone is returned when no keyed tone stands out from the noise
+var ErrNoTone = errors.New("no keyed tone found")
+
+// Goertzel returns the amplitude of freq in samples, in 16-bit units
+func Goertzel(samples []int16, sampleRate int, freq float64) float64 {
+	if len(samples) == 0 {
+		return 0
+	}
+	coeff := 2 * math.Cos(2*math.Pi*freq/float64(sampleRate))
+	var s1, s2 float64
+	for _, x := range samples {
+		s := float64(x) + coeff*s1 - s2
+		s2, s1 = s1, s
+	}
+	power := s1*s1 + s2*s2 - coeff*s1*s2
+	return 2 * math.Sqrt(math.Max(power, 0)) / float64(len(samples))
+}
+
+// DominantTone returns the strongest frequency between lo and hi, in 10 Hz
+// steps, measured over the loudest 50 ms blocks (up to 10 s of audio)
+func DominantTone(samples []int16, sampleRate int, lo, hi float64) float64 {
+	n := max(sampleRate/20, 1)
+	type block struct {
+		start  int
+		energy float64
+	}
+	var blocks []block
+	for i := 0; i+n <= len(samples); i += n {
+		blocks = append(blocks, block{i, rms(samples[i:i+n], 0)})
+	}
+	sort.Slice(blocks, func(a, b int) bool { return blocks[a].energy > blocks[b].energy })
+	blocks = blocks[:min(len(blocks), 200)]
+
+	best, bestAmp := lo, -1.0
+	for f := lo; f <= hi; f += 10 {
+		amp := 0.0
+		for _, b := range blocks {
+			amp += Goertzel(samples[b.start:b.start+n], sampleRate, f)
+		}
+		if amp > bestAmp {
+			best, bestAmp = f, amp
+		}
+	}
+	return best
+}
+
+// ToneKeying measures freq in consecutive blocks of length block and
+// reports for each block whether the tone is present. The decision level
+// sits halfway (in dB) between the quiet and loud blocks, with hysteresis.
+// The returned snr is the difference between loud and quiet blocks in dB.
+func ToneKeying(samples []int16, sampleRate int, freq float64, block time.Duration) (keyed []bool, snr float64, err error) {
+	n := max(int(block.Seconds()*float64(sampleRate)), 1)
+	var levels []float64
+	for i := 0; i+n <= len(samples); i += n {
+		levels = append(levels, 20*math.Log10(Goertzel(samples[i:i+n], sampleRate, freq)+1e-3))
+	}
+	if len(levels) == 0 {
+		return nil, 0, ErrNoTone
+	}
+	sorted := append([]float64(nil), levels...)
+	sort.Float64s(sorted)
+	quiet := sorted[len(sorted)*10/100]
+	loud := sorted[min(len(sorted)*90/100, len(sorted)-1)]
+	snr = loud - quiet
+	if snr < 10 {
+		return nil, snr, ErrNoTone
+	}
+
+	mid, hyst := (loud+quiet)/2, snr/8
+	keyed = make([]bool, len(levels))
+	on := false
+	for i, l := range levels {
+		if on && l < mid-hyst {
+			on = false
+		} else if !on && l > mid+hyst {
+			on = true
+		}
+		keyed[i] = on
+	}
+	return keyed, snr, nil
+}
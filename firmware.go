@@ -0,0 +1,439 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rampa069/aioc-util/aioc"
+	"github.com/rampa069/aioc-util/dfu"
+)
+
+// firmwareCommands are the subcommands of "aioc-util firmware"
+var firmwareCommands = map[string]func([]string){
+	"inspect": runFirmwareInspect,
+	"update":  runFirmwareUpdate,
+}
+
+// runFirmware implements "aioc-util firmware"
+func runFirmware(args []string) {
+	if len(args) > 0 {
+		if run, ok := firmwareCommands[args[0]]; ok {
+			run(args[1:])
+			return
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Usage: aioc-util firmware <command> [options]\n\n")
+	fmt.Fprintf(os.Stderr, "Commands:\n")
+	fmt.Fprintf(os.Stderr, "  inspect  Show the contents of a firmware image and check it fits the AIOC\n")
+	fmt.Fprintf(os.Stderr, "  update   Flash a firmware image over USB DFU, keeping the settings\n")
+	os.Exit(1)
+}
+
+// checkImageDevice returns an error if the DFU suffix of img names a USB
+// device other than the AIOC or its bootloader
+func checkImageDevice(img *dfu.Image) error {
+	// 0xFFFF in the suffix means "any device"
+	s := img.Suffix
+	if s == nil || s.VendorID == 0xFFFF {
+		return nil
+	}
+	aiocIDs := s.VendorID == aioc.VendorID && s.ProductID == aioc.ProductID
+	bootIDs := s.VendorID == dfu.BootloaderVendorID && s.ProductID == dfu.BootloaderProductID
+	if !aiocIDs && !bootIDs {
+		return fmt.Errorf("%w: image is for USB device %04x:%04x", dfu.ErrInvalidImage, s.VendorID, s.ProductID)
+	}
+	return nil
+}
+
+// loadFirmwareImage reads and validates a .bin or .dfu image for the AIOC
+func loadFirmwareImage(path string) (*dfu.Image, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	img, err := dfu.ParseImage(data, dfu.STM32F302CB.Base)
+	if err != nil {
+		return nil, err
+	}
+	if err := img.Validate(dfu.STM32F302CB); err != nil {
+		return nil, err
+	}
+	if err := checkImageDevice(img); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// imageVersion returns the firmware version of img and where it was found:
+// the bcdDevice of its USB device descriptor, which is what the running
+// firmware reports, or else the first version string in it
+func imageVersion(img *dfu.Image) (aioc.FirmwareVersion, string) {
+	if addr, bcd, ok := img.FindDeviceDescriptor(aioc.VendorID, aioc.ProductID); ok {
+		if v := aioc.DecodeBCDDevice(bcd); v.AtLeast(aioc.FirmwareVersion{Major: 1, Minor: 1}) {
+			return v, fmt.Sprintf("USB device descriptor at 0x%08x", addr)
+		}
+	}
+	for _, s := range img.FindVersionStrings() {
+		if v, err := aioc.ParseFirmwareVersion(strings.SplitN(s.Version, "-", 2)[0]); err == nil {
+			return v, fmt.Sprintf("string %q at 0x%08x", s.Text, s.Address)
+		}
+	}
+	return aioc.FirmwareVersion{}, ""
+}
+
+// runFirmwareInspect implements "aioc-util firmware inspect"
+func runFirmwareInspect(args []string) {
+	fs := flag.NewFlagSet("firmware inspect", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: aioc-util firmware inspect IMAGE.bin|IMAGE.dfu\n")
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	path := fs.Arg(0)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read image: %v\n", err)
+		os.Exit(1)
+	}
+	layout := dfu.STM32F302CB
+	img, err := dfu.ParseImage(data, layout.Base)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("File: %s (%d bytes)\n", path, img.FileSize)
+	fmt.Printf("Format: %s\n", img.Format)
+	if s := img.Suffix; s != nil {
+		fmt.Printf("DFU suffix: device %04x:%04x, bcdDevice 0x%04x, DFU 0x%04x, CRC 0x%08x (valid)\n",
+			s.VendorID, s.ProductID, s.BCDDevice, s.BCDDFU, s.CRC)
+	} else {
+		fmt.Println("DFU suffix: none")
+	}
+	for i, t := range img.Targets {
+		name := t.Name
+		if name == "" {
+			name = "unnamed"
+		}
+		fmt.Printf("Target %d: alternate setting %d (%s), %d element(s)\n", i, t.AltSetting, name, len(t.Elements))
+		if t.AltSetting != 0 {
+			fmt.Println("  not the internal flash, ignored when flashing")
+		}
+		for _, e := range t.Elements {
+			fmt.Printf("  0x%08x-0x%08x  %6d bytes  CRC32 0x%08x\n",
+				e.Address, e.Address+uint32(len(e.Data)), len(e.Data), crc32.ChecksumIEEE(e.Data))
+		}
+	}
+
+	size := img.Size()
+	fmt.Printf("Flash: %d of %d bytes used (%.0f%%) on %s\n",
+		size, layout.Size, float64(size)*100/float64(layout.Size), layout.Name)
+	if sp, reset, ok := img.VectorTable(layout.Base); ok {
+		fmt.Printf("Vector table: stack pointer 0x%08x, reset handler 0x%08x\n", sp, reset)
+	}
+	if v, where := imageVersion(img); v.Known() {
+		fmt.Printf("Version: %s (from %s)\n", v, where)
+	} else {
+		fmt.Println("Version: unknown")
+	}
+	for _, s := range img.FindVersionStrings() {
+		fmt.Printf("  0x%08x  %q\n", s.Address, s.Text)
+	}
+
+	err = img.Validate(layout)
+	if err == nil {
+		err = checkImageDevice(img)
+	}
+	if err != nil {
+		fmt.Printf("Result: FAIL\n%v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println("Result: OK, the image fits the AIOC")
+}
+
+// flashProgress prints the progress of each flash stage on one line
+func flashProgress() func(dfu.Stage, int, int) {
+	var last dfu.Stage
+	return func(stage dfu.Stage, done, total int) {
+		if stage != last {
+			if last != "" {
+				fmt.Println()
+			}
+			last = stage
+		}
+		fmt.Printf("\r  %-7s %3d%%", stage, done*100/total)
+	}
+}
+
+// waitForAIOC opens the AIOC with serial under any of ids once it has
+// enumerated and its device nodes are accessible
+func waitForAIOC(ids [][2]uint16, serial string, timeout time.Duration) (*aioc.Device, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		var err error
+		for _, id := range ids {
+			var dev *aioc.Device
+			dev, err = aioc.OpenSerial(id[0], id[1], serial)
+			if err == nil {
+				return dev, nil
+			}
+			// Permissions follow shortly after enumeration, through udev
+			if !errors.Is(err, aioc.ErrNotFound) && !errors.Is(err, aioc.ErrPermission) {
+				return nil, err
+			}
+		}
+		if time.Now().After(deadline) {
+			return nil, err
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+}
+
+// openBootloader waits for the STM32 bootloader and opens its DFU interface
+func openBootloader(timeout time.Duration) (*dfu.USBDevice, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := dfu.WaitUSB(ctx, dfu.BootloaderVendorID, dfu.BootloaderProductID, ""); err != nil {
+		return nil, err
+	}
+	for {
+		boot, err := dfu.OpenUSB(dfu.BootloaderVendorID, dfu.BootloaderProductID, "")
+		if err == nil {
+			return boot, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(250 * time.Millisecond):
+		}
+	}
+}
+
+// runFirmwareUpdate implements "aioc-util firmware update"
+func runFirmwareUpdate(args []string) {
+	fs := flag.NewFlagSet("firmware update", flag.ExitOnError)
+	backup := fs.String("backup", "", "File to save the settings to (default: aioc-settings-SERIAL.json)")
+	serialFlag := fs.String("serial", "", "USB serial number of the AIOC to update (default: first found; required to resume an update while other AIOCs are connected)")
+	noVerify := fs.Bool("no-verify", false, "Do not read the flash back after writing")
+	noRestore := fs.Bool("no-restore", false, "Keep the settings of the new firmware instead of restoring the old ones")
+	force := fs.Bool("force", false, "Restore the settings even if the AIOC does not report the image's firmware version")
+	simulate := fs.Bool("simulate", false, "Flash a simulated bootloader instead of the device")
+	timeout := fs.Duration("timeout", 15*time.Second, "How long to wait for the device to re-enumerate")
+	openUSB := fs.String("open-usb", "", "USB VID and PID to use when opening (format: VID,PID)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: aioc-util firmware update [options] IMAGE.bin|IMAGE.dfu\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	path := fs.Arg(0)
+	img, err := loadFirmwareImage(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot use %s: %v\n", path, err)
+		os.Exit(1)
+	}
+	imgVersion, _ := imageVersion(img)
+	fmt.Printf("Image: %s (%s, %d bytes, firmware %s)\n", path, img.Format, img.Size(), imgVersion)
+
+	opts := dfu.FlashOptions{Verify: !*noVerify, Leave: true, Progress: flashProgress()}
+
+	if *simulate {
+		sim := dfu.NewSimulator(dfu.STM32F302CB)
+		client := dfu.NewClient(sim)
+		client.Sleep = func(time.Duration) {}
+		fmt.Println("Flashing simulated bootloader...")
+		err := client.Flash(dfu.STM32F302CB, img.Elements(), opts)
+		fmt.Println()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Simulated update failed: %v\n", err)
+			os.Exit(1)
+		}
+		for _, e := range img.Elements() {
+			if string(sim.Read(e.Address, len(e.Data))) != string(e.Data) {
+				fmt.Fprintf(os.Stderr, "Simulated flash does not match the image at 0x%08x\n", e.Address)
+				os.Exit(1)
+			}
+		}
+		if !sim.Manifested() {
+			fmt.Fprintf(os.Stderr, "Simulated bootloader did not start the firmware\n")
+			os.Exit(1)
+		}
+		fmt.Printf("Simulated update successful (%d DNLOAD, %d UPLOAD, %d GETSTATUS requests)\n",
+			sim.Requests[dfu.ReqDnload], sim.Requests[dfu.ReqUpload], sim.Requests[dfu.ReqGetStatus])
+		return
+	}
+
+	vid, pid := uint16(aioc.VendorID), uint16(aioc.ProductID)
+	if *openUSB != "" {
+		v, p, err := parseUSBPair(*openUSB)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid --open-usb value: %v\n", err)
+			os.Exit(1)
+		}
+		vid, pid = uint16(v), uint16(p)
+	}
+
+	var (
+		state      *aioc.DeviceState // settings to restore, nil if none
+		serial     = *serialFlag
+		oldVersion aioc.FirmwareVersion
+		bootWait   = *timeout
+	)
+	if dfu.PresentUSB(dfu.BootloaderVendorID, dfu.BootloaderProductID, "") {
+		// Resuming an interrupted update: only the backup file is left
+		fmt.Println("An AIOC is already in DFU mode, skipping the settings backup")
+		// The bootloader does not report the AIOC serial number, so without
+		// one the restarted AIOC cannot be told from the others
+		if serial == "" && (dfu.PresentUSB(vid, pid, "") || dfu.PresentUSB(aioc.VendorID, aioc.ProductID, "")) {
+			fmt.Fprintf(os.Stderr, "Another AIOC is connected; give the serial number of the one in DFU mode with --serial\n")
+			os.Exit(1)
+		}
+		if *backup != "" && !*noRestore {
+			data, err := os.ReadFile(*backup)
+			if err == nil {
+				state = new(aioc.DeviceState)
+				err = json.Unmarshal(data, state)
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to load settings from %s: %v\n", *backup, err)
+				os.Exit(1)
+			}
+		}
+	} else {
+		var dev *aioc.Device
+		if serial == "" {
+			dev = openDevice(*openUSB)
+			// Without the serial number the AIOC cannot be told from the
+			// others when it is detached and when it comes back
+			if serial, err = dev.GetSerialNumber(); err != nil {
+				count := dfu.CountUSB(vid, pid, "")
+				if vid != aioc.VendorID || pid != aioc.ProductID {
+					count += dfu.CountUSB(aioc.VendorID, aioc.ProductID, "")
+				}
+				if count > 1 {
+					fmt.Fprintf(os.Stderr, "Failed to read the serial number: %v\n", err)
+					fmt.Fprintf(os.Stderr, "%d AIOCs are connected; give the serial number of the one to update with --serial\n", count)
+					os.Exit(1)
+				}
+				serial = ""
+			}
+		} else if dev, err = aioc.OpenSerial(vid, pid, serial); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not open AIOC %s: %v\n", serial, err)
+			printOpenHint(err)
+			os.Exit(1)
+		}
+		oldVersion = dev.FirmwareVersion()
+		current, err := dev.ReadState()
+		dev.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read settings: %v\n", err)
+			os.Exit(1)
+		}
+		state = &current
+
+		if *backup == "" {
+			name := serial
+			if name == "" {
+				name = "backup"
+			}
+			*backup = fmt.Sprintf("aioc-settings-%s.json", name)
+		}
+		data, _ := json.MarshalIndent(state, "", "  ")
+		if err := os.WriteFile(*backup, append(data, '\n'), 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to save settings: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Settings saved to %s\n", *backup)
+
+		rt, err := dfu.OpenUSB(vid, pid, serial)
+		if err == nil && rt.Protocol() == dfu.ProtocolRuntime {
+			fmt.Println("Switching to DFU bootloader...")
+			err = dfu.NewClient(rt).Detach(time.Second)
+			// The device may drop off the bus before acknowledging
+			if errors.Is(err, dfu.ErrDisconnected) {
+				err = nil
+			}
+			rt.Close()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to switch to the bootloader: %v\n", err)
+				os.Exit(1)
+			}
+		} else {
+			if rt != nil {
+				rt.Close()
+			}
+			fmt.Println("The firmware cannot switch to the bootloader by itself.")
+			fmt.Println("Put the AIOC into DFU mode (bridge the BOOT pads and replug it) to continue.")
+			bootWait = max(bootWait, 2*time.Minute)
+		}
+	}
+
+	boot, err := openBootloader(bootWait)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "DFU bootloader not found: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println("Flashing...")
+	err = dfu.NewClient(boot).Flash(dfu.STM32F302CB, img.Elements(), opts)
+	fmt.Println()
+	boot.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Update failed: %v\n", err)
+		fmt.Fprintf(os.Stderr, "The AIOC stays in DFU mode, run the update again")
+		if state != nil {
+			fmt.Fprintf(os.Stderr, " with --backup %s", *backup)
+		}
+		fmt.Fprintf(os.Stderr, ".\n")
+		os.Exit(1)
+	}
+
+	// The new firmware may have reset the USB ID to the default
+	fmt.Println("Waiting for the AIOC to restart...")
+	dev, err := waitForAIOC([][2]uint16{{vid, pid}, {aioc.VendorID, aioc.ProductID}}, serial, *timeout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "AIOC did not come back: %v\n", err)
+		printOpenHint(err)
+		os.Exit(1)
+	}
+	defer dev.Close()
+	fmt.Printf("Firmware: %s -> %s\n", oldVersion, dev.FirmwareVersion())
+	if imgVersion.Known() && dev.FirmwareVersion().Known() && imgVersion != dev.FirmwareVersion() {
+		if !*force {
+			fmt.Fprintf(os.Stderr, "The AIOC reports %s, the image is %s; the update did not take effect\n", dev.FirmwareVersion(), imgVersion)
+			if state != nil && !*noRestore {
+				fmt.Fprintf(os.Stderr, "Settings were not restored, they are saved in %s (use --force to restore them anyway).\n", *backup)
+			}
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "Warning: the AIOC reports %s, the image is %s\n", dev.FirmwareVersion(), imgVersion)
+	}
+
+	if state == nil || *noRestore {
+		return
+	}
+	if err := dev.WriteState(*state); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to restore settings: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Settings are saved in %s.\n", *backup)
+		os.Exit(1)
+	}
+	fmt.Println("Storing settings...")
+	if err := dev.SendCommand(aioc.CmdSTORE); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to store settings: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println("Settings restored")
+}
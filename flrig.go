@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// flrigVersion is reported by main.get_version. Clients such as hamlib
+// compare it against the flrig releases they know, so it mimics one.
+const flrigVersion = "1.4.7"
+
+// flrigModes is the mode list offered to clients. The AIOC has no notion
+// of mode; the value is only remembered so clients see their own setting.
+var flrigModes = []string{"LSB", "USB", "CW", "FM", "AM", "PKT-U", "PKT-L"}
+
+// flrigServer implements the subset of flrig's XML-RPC API needed by
+// logging and digital mode programs to key an AIOC
+type flrigServer struct {
+	ptt *pttKeyer
+
+	mu   sync.Mutex
+	freq float64
+	mode string
+}
+
+// xmlrpcCall is an incoming XML-RPC methodCall
+type xmlrpcCall struct {
+	Method string        `xml:"methodName"`
+	Params []xmlrpcValue `xml:"params>param>value"`
+}
+
+// xmlrpcValue is an XML-RPC value of any scalar type. Untyped values are
+// strings per the spec and end up in Text.
+type xmlrpcValue struct {
+	Int     *string `xml:"int"`
+	I4      *string `xml:"i4"`
+	Double  *string `xml:"double"`
+	Boolean *string `xml:"boolean"`
+	String  *string `xml:"string"`
+	Text    string  `xml:",chardata"`
+}
+
+func (v xmlrpcValue) str() string {
+	for _, s := range []*string{v.Int, v.I4, v.Double, v.Boolean, v.String} {
+		if s != nil {
+			return strings.TrimSpace(*s)
+		}
+	}
+	return strings.TrimSpace(v.Text)
+}
+
+func (c *xmlrpcCall) param(i int) (string, error) {
+	if i >= len(c.Params) {
+		return "", fmt.Errorf("%s: missing parameter %d", c.Method, i+1)
+	}
+	return c.Params[i].str(), nil
+}
+
+func (c *xmlrpcCall) intParam(i int) (int, error) {
+	s, err := c.param(i)
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s: invalid integer %q", c.Method, s)
+	}
+	return int(v), nil
+}
+
+func (c *xmlrpcCall) floatParam(i int) (float64, error) {
+	s, err := c.param(i)
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s: invalid number %q", c.Method, s)
+	}
+	return v, nil
+}
+
+// xmlrpcEncode renders a Go value as an XML-RPC <value>
+func xmlrpcEncode(v interface{}) string {
+	var b strings.Builder
+	b.WriteString("<value>")
+	switch val := v.(type) {
+	case nil:
+	case int:
+		fmt.Fprintf(&b, "<i4>%d</i4>", val)
+	case float64:
+		fmt.Fprintf(&b, "<double>%s</double>", strconv.FormatFloat(val, 'f', -1, 64))
+	case string:
+		b.WriteString("<string>")
+		xml.EscapeText(&b, []byte(val))
+		b.WriteString("</string>")
+	case []string:
+		b.WriteString("<array><data>")
+		for _, s := range val {
+			b.WriteString(xmlrpcEncode(s))
+		}
+		b.WriteString("</data></array>")
+	}
+	b.WriteString("</value>")
+	return b.String()
+}
+
+func xmlrpcResponse(v interface{}) string {
+	return `<?xml version="1.0"?>` + "\n" +
+		"<methodResponse><params><param>" + xmlrpcEncode(v) + "</param></params></methodResponse>\n"
+}
+
+func xmlrpcFault(code int, msg string) string {
+	return `<?xml version="1.0"?>` + "\n" +
+		"<methodResponse><fault><value><struct>" +
+		"<member><name>faultCode</name>" + xmlrpcEncode(code) + "</member>" +
+		"<member><name>faultString</name>" + xmlrpcEncode(msg) + "</member>" +
+		"</struct></value></fault></methodResponse>\n"
+}
+
+var flrigMethods = []string{
+	"main.get_version",
+	"rig.get_AB",
+	"rig.get_info",
+	"rig.get_mode",
+	"rig.get_modes",
+	"rig.get_ptt",
+	"rig.get_vfo",
+	"rig.get_xcvr",
+	"rig.set_frequency",
+	"rig.set_mode",
+	"rig.set_ptt",
+	"rig.set_vfo",
+	"system.listMethods",
+}
+
+// call executes a single method and returns its result
+func (s *flrigServer) call(c *xmlrpcCall) (interface{}, error) {
+	switch c.Method {
+	case "system.listMethods":
+		return flrigMethods, nil
+	case "main.get_version":
+		return flrigVersion, nil
+	case "rig.get_xcvr":
+		return "AIOC", nil
+	case "rig.get_info":
+		tx := "R"
+		if s.ptt.Keyed() {
+			tx = "X"
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return fmt.Sprintf("R:AIOC\nT:%s\nFA:%.0f\nM:%s", tx, s.freq, s.mode), nil
+	case "rig.get_AB":
+		return "A", nil
+	case "rig.get_ptt":
+		if s.ptt.Keyed() {
+			return 1, nil
+		}
+		return 0, nil
+	case "rig.set_ptt":
+		state, err := c.intParam(0)
+		if err != nil {
+			return nil, err
+		}
+		on := state != 0
+		if err := s.ptt.Set(on); err != nil {
+			return nil, err
+		}
+		log.Printf("PTT %s", onOff(on))
+		return nil, nil
+	case "rig.get_vfo":
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return strconv.FormatFloat(s.freq, 'f', 0, 64), nil
+	case "rig.set_vfo", "rig.set_frequency":
+		freq, err := c.floatParam(0)
+		if err != nil {
+			return nil, err
+		}
+		s.mu.Lock()
+		s.freq = freq
+		s.mu.Unlock()
+		return nil, nil
+	case "rig.get_modes":
+		return flrigModes, nil
+	case "rig.get_mode":
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.mode, nil
+	case "rig.set_mode":
+		mode, err := c.param(0)
+		if err != nil {
+			return nil, err
+		}
+		s.mu.Lock()
+		s.mode = mode
+		s.mu.Unlock()
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unknown method: %s", c.Method)
+}
+
+func (s *flrigServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "XML-RPC requires POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 64*1024))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/xml")
+
+	var c xmlrpcCall
+	if err := xml.NewDecoder(bytes.NewReader(body)).Decode(&c); err != nil {
+		io.WriteString(w, xmlrpcFault(1, fmt.Sprintf("malformed request: %v", err)))
+		return
+	}
+
+	result, err := s.call(&c)
+	if err != nil {
+		log.Printf("%s: %v", c.Method, err)
+		io.WriteString(w, xmlrpcFault(1, err.Error()))
+		return
+	}
+	io.WriteString(w, xmlrpcResponse(result))
+}
+
+// runFlrig implements "aioc-util flrig"
+func runFlrig(args []string) {
+	fs := flag.NewFlagSet("flrig", flag.ExitOnError)
+	listen := fs.String("listen", "127.0.0.1:12345", "Address to serve the flrig XML-RPC API on")
+	channel := fs.Int("channel", 1, "PTT channel to key: 1 or 2")
+	txTimeout := fs.Duration("tx-timeout", 3*time.Minute, "Release PTT after it has been keyed this long (0 disables)")
+	openUSB := fs.String("open-usb", "", "USB VID and PID to use when opening (format: VID,PID)")
+	fs.Parse(args)
+
+	ch, err := pttChannelFromNumber(*channel)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid --channel value: %v\n", err)
+		os.Exit(1)
+	}
+
+	dev := openDevice(*openUSB)
+	defer dev.Close()
+
+	server := &flrigServer{
+		ptt:  newPTTKeyer(dev, ch, *txTimeout),
+		freq: 14070000,
+		mode: "USB",
+	}
+	releaseOnSignal(server.ptt)
+
+	log.Printf("flrig XML-RPC server listening on %s (PTT%d, tx timeout %s)", *listen, *channel, *txTimeout)
+	if err := http.ListenAndServe(*listen, server); err != nil {
+		server.ptt.Close()
+		fmt.Fprintf(os.Stderr, "flrig server failed: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,635 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"os/signal"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/rampa069/aioc-util/aioc"
+	"github.com/rampa069/aioc-util/dsp"
+	"github.com/rampa069/aioc-util/morse"
+	"github.com/rampa069/aioc-util/wav"
+)
+
+// callsignPattern matches a token that looks like an amateur callsign: a
+// prefix, a digit and a suffix ending in a letter
+var callsignPattern = regexp.MustCompile(`^[A-Z0-9]{1,3}[0-9][A-Z0-9]{0,3}[A-Z]$`)
+
+// foxhuntCharset is what the Morse table of the AIOC firmware can send:
+// letters, digits, space and the ITU punctuation. '!', '$', '@' and '_' are
+// in morse.Code but not in the firmware table.
+const foxhuntCharset = "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789 .,?'/()&:;=+-\""
+
+// validateFoxhuntMessage checks a foxhunt message against what the
+// firmware can send. The message is upper-cased and prosigns such as <AR>
+// are mapped to their single character equivalents; characters outside
+// foxhuntCharset and messages longer than the registers are rejected.
+// Problems that do not prevent sending are returned as warnings.
+func validateFoxhuntMessage(msg string) (string, []string, error) {
+	normalized, err := morse.Normalize(msg)
+	if err != nil {
+		return "", nil, err
+	}
+	if strings.TrimSpace(normalized) == "" {
+		return "", nil, fmt.Errorf("message contains nothing to send")
+	}
+	for i, r := range normalized {
+		if !strings.ContainsRune(foxhuntCharset, r) {
+			return "", nil, fmt.Errorf("character %q at position %d cannot be sent by the AIOC firmware", r, i+1)
+		}
+	}
+	if len(normalized) > aioc.FoxhuntMessageSize {
+		return "", nil, fmt.Errorf("message is %d characters, the foxhunt registers hold %d", len(normalized), aioc.FoxhuntMessageSize)
+	}
+
+	var warnings []string
+	if normalized != msg {
+		warnings = append(warnings, fmt.Sprintf("message normalized to '%s'", normalized))
+	}
+	hasCallsign := false
+	for _, token := range strings.FieldsFunc(normalized, func(r rune) bool { return r == ' ' || r == '/' }) {
+		if callsignPattern.MatchString(token) {
+			hasCallsign = true
+			break
+		}
+	}
+	if !hasCallsign {
+		warnings = append(warnings, "message contains no callsign for station identification")
+	}
+	return normalized, warnings, nil
+}
+
+// foxhuntDutyWarning is the duty cycle above which a handheld running as a
+// fox is likely to overheat
+const foxhuntDutyWarning = 0.5
+
+// foxhuntPlan describes the on-air schedule of a beacon. The interval is
+// the time from the start of one transmission to the start of the next.
+type foxhuntPlan struct {
+	Units     int // message length in dits
+	TxTime    time.Duration
+	IdleTime  time.Duration
+	DutyCycle float64
+	PerHour   float64
+	Disabled  bool
+	Warnings  []string
+}
+
+// planFoxhunt computes the transmit schedule for message at wpm repeated
+// every interval seconds. Combinations that cannot work as a beacon, such
+// as an interval not longer than the message, are returned as an error.
+func planFoxhunt(message string, wpm, interval int) (*foxhuntPlan, error) {
+	elems, err := morse.Encode(message)
+	if err != nil {
+		return nil, err
+	}
+	plan := &foxhuntPlan{Units: morse.Units(elems)}
+
+	if interval == 0 {
+		plan.Disabled = true
+		return plan, nil
+	}
+	if wpm == 0 {
+		return nil, fmt.Errorf("foxhunt is enabled (interval %d s) but WPM is 0", interval)
+	}
+	if plan.Units == 0 {
+		return nil, fmt.Errorf("foxhunt is enabled (interval %d s) but the message is empty", interval)
+	}
+
+	period := time.Duration(interval) * time.Second
+	plan.TxTime = time.Duration(plan.Units) * morse.DitDuration(wpm)
+	if plan.TxTime >= period {
+		return nil, fmt.Errorf("message takes %.2f s at %d WPM, not shorter than the %d s interval (100%% duty cycle)",
+			plan.TxTime.Seconds(), wpm, interval)
+	}
+	plan.IdleTime = period - plan.TxTime
+	plan.DutyCycle = plan.TxTime.Seconds() / period.Seconds()
+	plan.PerHour = time.Hour.Seconds() / period.Seconds()
+
+	if plan.DutyCycle > foxhuntDutyWarning {
+		plan.Warnings = append(plan.Warnings, fmt.Sprintf("duty cycle is %.0f%%, a handheld may overheat", plan.DutyCycle*100))
+	}
+	return plan, nil
+}
+
+// foxhuntSettings are the beacon parameters used by the foxhunt
+// subcommands. A value of -1 (or an empty message) means "read it from the
+// device".
+type foxhuntSettings struct {
+	message  string
+	volume   int
+	wpm      int
+	interval int
+	openUSB  string
+}
+
+func (s *foxhuntSettings) addFlags(fs *flag.FlagSet) {
+	fs.StringVar(&s.message, "message", "", "Foxhunt message (default: read from device)")
+	fs.IntVar(&s.volume, "volume", -1, "Foxhunt volume 0-65535 (default: read from device)")
+	fs.IntVar(&s.wpm, "wpm", -1, "Morse speed in words per minute (default: read from device)")
+	fs.IntVar(&s.interval, "interval", -1, "Seconds between transmissions (default: read from device)")
+	fs.StringVar(&s.openUSB, "open-usb", "", "USB VID and PID to use when opening (format: VID,PID)")
+}
+
+// resolve fills in every unset value from the device. The device is only
+// opened if something is missing.
+func (s *foxhuntSettings) resolve() error {
+	if s.message != "" && s.volume != -1 && s.wpm != -1 && s.interval != -1 {
+		return nil
+	}
+
+	dev := openDevice(s.openUSB)
+	defer dev.Close()
+	requireCapability(dev, aioc.CapFoxhunt)
+
+	ctrl, err := dev.Read(aioc.RegFOXHUNTCTRL)
+	if err != nil {
+		return fmt.Errorf("failed to read FOXHUNT_CTRL: %w", err)
+	}
+	cur := aioc.DecodeFoxhuntCtrl(ctrl)
+	if s.volume == -1 {
+		s.volume = cur.Volume
+	}
+	if s.wpm == -1 {
+		s.wpm = cur.WPM
+	}
+	if s.interval == -1 {
+		s.interval = cur.Interval
+	}
+	if s.message == "" {
+		msg, err := dev.ReadFoxhuntMessage()
+		if err != nil {
+			return fmt.Errorf("failed to read foxhunt message: %w", err)
+		}
+		s.message = msg
+	}
+	return nil
+}
+
+// foxhuntCommands are the "aioc-util foxhunt" subcommands
+var foxhuntCommands = map[string]func(args []string){
+	"plan":     runFoxhuntPlan,
+	"render":   runFoxhuntRender,
+	"schedule": runFoxhuntSchedule,
+	"verify":   runFoxhuntVerify,
+}
+
+// runFoxhunt implements "aioc-util foxhunt"
+func runFoxhunt(args []string) {
+	if len(args) > 0 {
+		if run, ok := foxhuntCommands[args[0]]; ok {
+			run(args[1:])
+			return
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Usage: aioc-util foxhunt <command> [options]\n\n")
+	fmt.Fprintf(os.Stderr, "Commands:\n")
+	fmt.Fprintf(os.Stderr, "  plan      Check the transmit schedule and duty cycle of the beacon\n")
+	fmt.Fprintf(os.Stderr, "  render    Render the beacon to a WAV file and print its on-air duration\n")
+	fmt.Fprintf(os.Stderr, "  schedule  Enable the beacon only within a time window\n")
+	fmt.Fprintf(os.Stderr, "  verify    Decode a recording of the beacon and compare it with the device\n")
+	os.Exit(1)
+}
+
+// runFoxhuntRender implements "aioc-util foxhunt render"
+func runFoxhuntRender(args []string) {
+	fs := flag.NewFlagSet("foxhunt render", flag.ExitOnError)
+	var settings foxhuntSettings
+	settings.addFlags(fs)
+	out := fs.String("out", "", "WAV file to write (omit to only print the duration)")
+	tone := fs.Float64("tone", 800, "Tone frequency in Hz")
+	rate := fs.Int("rate", 48000, "Sample rate in Hz")
+	fs.Parse(args)
+
+	if *rate <= 0 {
+		fmt.Fprintf(os.Stderr, "Invalid --rate value: %d\n", *rate)
+		os.Exit(1)
+	}
+	if nyquist := float64(*rate) / 2; *tone <= 0 || *tone >= nyquist {
+		fmt.Fprintf(os.Stderr, "Invalid --tone value: %.0f Hz (must be between 0 and %.0f Hz)\n", *tone, nyquist)
+		os.Exit(1)
+	}
+	if err := settings.resolve(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	if settings.wpm <= 0 {
+		fmt.Fprintf(os.Stderr, "Cannot render at %d WPM\n", settings.wpm)
+		os.Exit(1)
+	}
+	if settings.volume < 0 || settings.volume > 0xFFFF {
+		fmt.Fprintf(os.Stderr, "Invalid --volume value: %d (use 0-65535)\n", settings.volume)
+		os.Exit(1)
+	}
+
+	message, warnings, err := validateFoxhuntMessage(settings.message)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid foxhunt message '%s': %v\n", settings.message, err)
+		os.Exit(1)
+	}
+	for _, w := range warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", w)
+	}
+	settings.message = message
+
+	elems, err := morse.Encode(settings.message)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot encode message '%s': %v\n", settings.message, err)
+		os.Exit(1)
+	}
+	units := morse.Units(elems)
+	dit := morse.DitDuration(settings.wpm)
+	duration := time.Duration(units) * dit
+
+	fmt.Printf("Message: '%s'\n", settings.message)
+	fmt.Printf("Speed: %d WPM (dit %s)\n", settings.wpm, dit)
+	fmt.Printf("Length: %d dits\n", units)
+	fmt.Printf("On-air duration: %.2f s\n", duration.Seconds())
+
+	if *out == "" {
+		return
+	}
+
+	samples := morse.Render(elems, settings.wpm, morse.Tone{
+		SampleRate: *rate,
+		Frequency:  *tone,
+		Amplitude:  float64(settings.volume) / 65535,
+		Ramp:       5 * time.Millisecond,
+	})
+	if err := wav.WriteFile(*out, *rate, samples); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write %s: %v\n", *out, err)
+		os.Exit(1)
+	}
+	fmt.Printf("Wrote %s (%d Hz, volume %d)\n", *out, *rate, settings.volume)
+}
+
+// runFoxhuntPlan implements "aioc-util foxhunt plan"
+func runFoxhuntPlan(args []string) {
+	fs := flag.NewFlagSet("foxhunt plan", flag.ExitOnError)
+	var settings foxhuntSettings
+	settings.addFlags(fs)
+	apply := fs.Bool("apply", false, "Write the planned settings to the device")
+	store := fs.Bool("store", false, "Store the settings into flash after --apply")
+	force := fs.Bool("force", false, "Apply even if the plan is rejected")
+	fs.Parse(args)
+
+	if err := settings.resolve(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	ctrl, err := aioc.FoxhuntCtrl{Volume: settings.volume, WPM: settings.wpm, Interval: settings.interval}.Encode()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid foxhunt settings: %v\n", err)
+		os.Exit(1)
+	}
+	message, warnings, err := validateFoxhuntMessage(settings.message)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid foxhunt message '%s': %v\n", settings.message, err)
+		os.Exit(1)
+	}
+	settings.message = message
+
+	fmt.Printf("Message: '%s'\n", settings.message)
+	fmt.Printf("Speed: %d WPM\n", settings.wpm)
+	fmt.Printf("Interval: %d s\n", settings.interval)
+	fmt.Printf("Volume: %d\n", settings.volume)
+
+	rejected := false
+	plan, err := planFoxhunt(settings.message, settings.wpm, settings.interval)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Plan rejected: %v\n", err)
+		rejected = true
+	} else if plan.Disabled {
+		fmt.Println("Foxhunt mode is disabled (interval 0)")
+	} else {
+		warnings = append(warnings, plan.Warnings...)
+		fmt.Printf("Length: %d dits\n", plan.Units)
+		fmt.Printf("Transmit time: %.2f s\n", plan.TxTime.Seconds())
+		fmt.Printf("Idle time: %.2f s\n", plan.IdleTime.Seconds())
+		fmt.Printf("Duty cycle: %.1f%%\n", plan.DutyCycle*100)
+		fmt.Printf("Transmissions per hour: %.1f\n", plan.PerHour)
+	}
+	for _, w := range warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", w)
+	}
+
+	if !*apply {
+		if rejected {
+			os.Exit(1)
+		}
+		return
+	}
+	if rejected && !*force {
+		fmt.Fprintf(os.Stderr, "Not applying a rejected plan (use --force to override)\n")
+		os.Exit(1)
+	}
+
+	dev := openDevice(settings.openUSB)
+	defer dev.Close()
+	requireCapability(dev, aioc.CapFoxhunt)
+
+	fmt.Printf("Setting FOXHUNT_CTRL: volume=%d, wpm=%d, interval=%d\n", settings.volume, settings.wpm, settings.interval)
+	if err := dev.Write(aioc.RegFOXHUNTCTRL, ctrl); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write FOXHUNT_CTRL: %v\n", err)
+		os.Exit(1)
+	}
+	if err := dev.WriteFoxhuntMessage(settings.message); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write foxhunt message: %v\n", err)
+		os.Exit(1)
+	}
+	if *store {
+		fmt.Println("Storing...")
+		if err := dev.SendCommand(aioc.CmdSTORE); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to store settings: %v\n", err)
+			os.Exit(1)
+		}
+	}
+}
+
+// stringList is a flag.Value collecting repeated string flags
+type stringList []string
+
+func (l *stringList) String() string     { return strings.Join(*l, ", ") }
+func (l *stringList) Set(s string) error { *l = append(*l, s); return nil }
+
+// runFoxhuntSchedule implements "aioc-util foxhunt schedule". It keeps the
+// beacon off outside a time window and optionally rotates messages while
+// it is on. All writes go to RAM only, so the flash is not worn and a power
+// cycle restores the stored settings.
+func runFoxhuntSchedule(args []string) {
+	fs := flag.NewFlagSet("foxhunt schedule", flag.ExitOnError)
+	var messages stringList
+	fs.Var(&messages, "message", "Message to send, repeat to rotate between several (default: message on the device)")
+	start := fs.String("start", "", "Time to enable the beacon, HH:MM[:SS] or RFC 3339 (default: now)")
+	end := fs.String("end", "", "Time to disable the beacon, HH:MM[:SS] or RFC 3339")
+	rotate := fs.Duration("rotate", 10*time.Minute, "Time between message changes when several messages are given")
+	interval := fs.Int("interval", -1, "Seconds between transmissions while enabled (default: interval on the device)")
+	wpm := fs.Int("wpm", -1, "Morse speed in words per minute (default: read from device)")
+	volume := fs.Int("volume", -1, "Foxhunt volume 0-65535 (default: read from device)")
+	force := fs.Bool("force", false, "Run even if a message does not fit the interval")
+	openUSB := fs.String("open-usb", "", "USB VID and PID to use when opening (format: VID,PID)")
+	fs.Parse(args)
+
+	now := time.Now()
+	if *end == "" {
+		fmt.Fprintf(os.Stderr, "--end is required\n")
+		os.Exit(1)
+	}
+	endTime, err := parseClockTime(*end, now)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid --end value: %v\n", err)
+		os.Exit(1)
+	}
+	startTime := now
+	if *start != "" {
+		startTime, err = parseClockTime(*start, now)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid --start value: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if !endTime.After(startTime) {
+		// A time of day window that is already open, e.g. 18:00-22:00 at 19:00
+		if startTime.AddDate(0, 0, -1).Before(now) && endTime.After(now) {
+			startTime = now
+		} else {
+			fmt.Fprintf(os.Stderr, "End time %s is not after start time %s\n",
+				endTime.Format(time.RFC3339), startTime.Format(time.RFC3339))
+			os.Exit(1)
+		}
+	}
+	if *rotate <= 0 {
+		fmt.Fprintf(os.Stderr, "Invalid --rotate value: %s\n", *rotate)
+		os.Exit(1)
+	}
+
+	dev := openDevice(*openUSB)
+	defer dev.Close()
+	requireCapability(dev, aioc.CapFoxhunt)
+
+	current, err := dev.Read(aioc.RegFOXHUNTCTRL)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read FOXHUNT_CTRL: %v\n", err)
+		os.Exit(1)
+	}
+	cur := aioc.DecodeFoxhuntCtrl(current)
+	if *volume == -1 {
+		*volume = cur.Volume
+	}
+	if *wpm == -1 {
+		*wpm = cur.WPM
+	}
+	if *interval == -1 {
+		*interval = cur.Interval
+	}
+	if *interval == 0 {
+		fmt.Fprintf(os.Stderr, "The interval is 0, set --interval to enable the beacon\n")
+		os.Exit(1)
+	}
+	onCtrl, err := aioc.FoxhuntCtrl{Volume: *volume, WPM: *wpm, Interval: *interval}.Encode()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid foxhunt settings: %v\n", err)
+		os.Exit(1)
+	}
+	offCtrl, _ := aioc.FoxhuntCtrl{Volume: *volume, WPM: *wpm}.Encode()
+
+	if len(messages) == 0 {
+		msg, err := dev.ReadFoxhuntMessage()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read foxhunt message: %v\n", err)
+			os.Exit(1)
+		}
+		messages = stringList{msg}
+	}
+	for i, msg := range messages {
+		normalized, warnings, err := validateFoxhuntMessage(msg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid foxhunt message '%s': %v\n", msg, err)
+			os.Exit(1)
+		}
+		for _, w := range warnings {
+			fmt.Fprintf(os.Stderr, "Warning: '%s': %s\n", msg, w)
+		}
+		if _, err := planFoxhunt(normalized, *wpm, *interval); err != nil && !*force {
+			fmt.Fprintf(os.Stderr, "Plan rejected for '%s': %v (use --force to override)\n", normalized, err)
+			os.Exit(1)
+		}
+		messages[i] = normalized
+	}
+
+	setCtrl := func(ctrl uint32, what string) {
+		if err := dev.Write(aioc.RegFOXHUNTCTRL, ctrl); err != nil {
+			log.Printf("Failed to %s beacon: %v", what, err)
+			return
+		}
+		c := aioc.DecodeFoxhuntCtrl(ctrl)
+		log.Printf("Beacon %sd: FOXHUNT_CTRL=%08x (volume=%d, wpm=%d, interval=%d)", what, ctrl, c.Volume, c.WPM, c.Interval)
+	}
+	setMessage := func(msg string) {
+		if err := dev.WriteFoxhuntMessage(msg); err != nil {
+			log.Printf("Failed to write message '%s': %v", msg, err)
+			return
+		}
+		log.Printf("Message set to '%s'", msg)
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	wait := func(until time.Time) bool {
+		select {
+		case <-time.After(time.Until(until)):
+			return true
+		case sig := <-sigs:
+			log.Printf("Received %s", sig)
+			return false
+		}
+	}
+
+	log.Printf("Foxhunt window %s - %s, %d message(s)",
+		startTime.Format("2006-01-02 15:04:05"), endTime.Format("2006-01-02 15:04:05"), len(messages))
+	setCtrl(offCtrl, "disable")
+
+	if wait(startTime) {
+		setMessage(messages[0])
+		setCtrl(onCtrl, "enable")
+
+		next := startTime.Add(*rotate)
+		for i := 1; ; i++ {
+			if len(messages) == 1 || !next.Before(endTime) {
+				wait(endTime)
+				break
+			}
+			if !wait(next) {
+				break
+			}
+			setMessage(messages[i%len(messages)])
+			next = next.Add(*rotate)
+		}
+	}
+
+	setCtrl(offCtrl, "disable")
+}
+
+// firstDifference returns the 1-based position of the first character at
+// which a and b differ, or 0 if they are equal
+func firstDifference(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	for i := 0; i < len(ra) || i < len(rb); i++ {
+		if i >= len(ra) || i >= len(rb) || ra[i] != rb[i] {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// runFoxhuntVerify implements "aioc-util foxhunt verify". It decodes the
+// beacon from a receive recording and compares it with the device.
+func runFoxhuntVerify(args []string) {
+	fs := flag.NewFlagSet("foxhunt verify", flag.ExitOnError)
+	wavFile := fs.String("wav", "", "WAV recording of the received beacon")
+	message := fs.String("message", "", "Expected message (default: read from device)")
+	wpm := fs.Int("wpm", -1, "Expected speed in WPM (default: read from device)")
+	tone := fs.Float64("tone", 0, "Tone frequency in Hz (default: detect)")
+	tolerance := fs.Float64("tolerance", 10, "Allowed speed deviation in percent")
+	openUSB := fs.String("open-usb", "", "USB VID and PID to use when opening (format: VID,PID)")
+	fs.Parse(args)
+
+	if *wavFile == "" {
+		fmt.Fprintf(os.Stderr, "--wav is required\n")
+		os.Exit(1)
+	}
+	rate, samples, err := wav.ReadFile(*wavFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read %s: %v\n", *wavFile, err)
+		os.Exit(1)
+	}
+
+	if *message == "" || *wpm == -1 {
+		dev := openDevice(*openUSB)
+		requireCapability(dev, aioc.CapFoxhunt)
+		if *message == "" {
+			*message, err = dev.ReadFoxhuntMessage()
+			if err != nil {
+				dev.Close()
+				fmt.Fprintf(os.Stderr, "Failed to read foxhunt message: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		if *wpm == -1 {
+			val, err := dev.Read(aioc.RegFOXHUNTCTRL)
+			if err != nil {
+				dev.Close()
+				fmt.Fprintf(os.Stderr, "Failed to read FOXHUNT_CTRL: %v\n", err)
+				os.Exit(1)
+			}
+			*wpm = aioc.DecodeFoxhuntCtrl(val).WPM
+		}
+		dev.Close()
+	}
+	expected, err := morse.Normalize(*message)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid foxhunt message '%s': %v\n", *message, err)
+		os.Exit(1)
+	}
+	expected = strings.Join(strings.Fields(expected), " ")
+
+	if *tone == 0 {
+		*tone = dsp.DominantTone(samples, rate, 300, 2000)
+	}
+	keyed, snr, err := dsp.ToneKeying(samples, rate, *tone, 2*time.Millisecond)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot decode %s at %.0f Hz: %v\n", *wavFile, *tone, err)
+		os.Exit(1)
+	}
+	fmt.Printf("Tone: %.0f Hz, %.1f dB above the noise\n", *tone, snr)
+	fmt.Printf("Expected: '%s' at %d WPM\n", expected, *wpm)
+
+	transmissions := morse.Decode(morse.Marks(keyed, 2*time.Millisecond), *wpm)
+	if len(transmissions) == 0 {
+		fmt.Fprintf(os.Stderr, "No transmissions decoded\n")
+		os.Exit(1)
+	}
+
+	failed := false
+	var wpmSum float64
+	complete := 0
+	for i, tx := range transmissions {
+		status := "OK"
+		switch {
+		case tx.Text == expected:
+			wpmSum += tx.WPM
+			complete++
+		case i == 0 && strings.HasSuffix(expected, tx.Text),
+			i == len(transmissions)-1 && strings.HasPrefix(expected, tx.Text):
+			status = "partial, cut off by the recording"
+		default:
+			status = fmt.Sprintf("MISMATCH at position %d", firstDifference(expected, tx.Text))
+			failed = true
+		}
+		fmt.Printf("Transmission %d at %.2f s: '%s' at %.1f WPM: %s\n",
+			i+1, tx.Start.Seconds(), tx.Text, tx.WPM, status)
+	}
+
+	if complete > 0 {
+		measured := wpmSum / float64(complete)
+		fmt.Printf("Speed: measured %.1f WPM, device %d WPM\n", measured, *wpm)
+		if *wpm > 0 && math.Abs(measured-float64(*wpm))/float64(*wpm)*100 > *tolerance {
+			fmt.Fprintf(os.Stderr, "Speed differs from the device setting by more than %.0f%%\n", *tolerance)
+			failed = true
+		}
+	} else {
+		fmt.Fprintf(os.Stderr, "No complete transmission matched the expected message\n")
+		failed = true
+	}
+	if failed {
+		os.Exit(1)
+	}
+	fmt.Println("Beacon verified")
+}
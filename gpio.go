@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/rampa069/aioc-util/aioc"
+)
+
+// gpioNames maps lower-case names to CM108 GPIO bits
+type gpioNames map[string]aioc.CM108GPIO
+
+// defaultGPIONames returns the built-in GPIO names. PTT1/PTT2 refer to the
+// GPIOs keyed by SetPTTState.
+func defaultGPIONames() gpioNames {
+	return gpioNames{
+		"gpio1": aioc.CM108GPIO1,
+		"gpio2": aioc.CM108GPIO2,
+		"gpio3": aioc.CM108GPIO3,
+		"gpio4": aioc.CM108GPIO4,
+		"ptt1":  aioc.CM108GPIO(1 << (aioc.PTTChannel1 - 1)),
+		"ptt2":  aioc.CM108GPIO(1 << (aioc.PTTChannel2 - 1)),
+	}
+}
+
+// loadProfile adds the names from a profile file. Each line has the
+// form "name = GPIOn"; blank lines and lines starting with # are ignored.
+func (n gpioNames) loadProfile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		parts := strings.SplitN(text, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("%s:%d: expected name = GPIOn", path, line)
+		}
+		name := strings.ToLower(strings.TrimSpace(parts[0]))
+		target := strings.ToLower(strings.TrimSpace(parts[1]))
+		gpio, ok := defaultGPIONames()[target]
+		if !ok || !strings.HasPrefix(target, "gpio") {
+			return fmt.Errorf("%s:%d: unknown GPIO %q", path, line, parts[1])
+		}
+		n[name] = gpio
+	}
+	return scanner.Err()
+}
+
+// label returns "GPIOn" plus any profile names for a single GPIO bit
+func (n gpioNames) label(gpio aioc.CM108GPIO) string {
+	var base string
+	var aliases []string
+	for name, g := range n {
+		if g != gpio {
+			continue
+		}
+		if strings.HasPrefix(name, "gpio") {
+			base = strings.ToUpper(name)
+		} else {
+			aliases = append(aliases, name)
+		}
+	}
+	sort.Strings(aliases)
+	if len(aliases) == 0 {
+		return base
+	}
+	return fmt.Sprintf("%s (%s)", base, strings.Join(aliases, ", "))
+}
+
+// parseAssignments parses "name=on|off" arguments into a mask and state
+// suitable for SetCM108GPIO
+func (n gpioNames) parseAssignments(args []string) (mask, state aioc.CM108GPIO, err error) {
+	for _, arg := range args {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) != 2 {
+			return 0, 0, fmt.Errorf("invalid assignment %q, use NAME=on|off", arg)
+		}
+		gpio, ok := n[strings.ToLower(strings.TrimSpace(parts[0]))]
+		if !ok {
+			return 0, 0, fmt.Errorf("unknown GPIO name: %s", parts[0])
+		}
+		var on bool
+		switch strings.ToLower(strings.TrimSpace(parts[1])) {
+		case "on", "1", "high":
+			on = true
+		case "off", "0", "low":
+			on = false
+		default:
+			return 0, 0, fmt.Errorf("invalid GPIO level %q, use on or off", parts[1])
+		}
+		if mask&gpio != 0 && (state&gpio != 0) != on {
+			return 0, 0, fmt.Errorf("conflicting levels for %s", strings.ToUpper(parts[0]))
+		}
+		mask |= gpio
+		if on {
+			state |= gpio
+		}
+	}
+	return mask, state, nil
+}
+
+// runGPIO implements "aioc-util gpio"
+func runGPIO(args []string) {
+	fs := flag.NewFlagSet("gpio", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: aioc-util gpio [options] NAME=on|off ...\n\n")
+		fmt.Fprintf(fs.Output(), "Sets CM108 GPIOs in a single HID report. NAME is GPIO1-GPIO4, PTT1, PTT2\n")
+		fmt.Fprintf(fs.Output(), "or a name from --profile.\n\n")
+		fmt.Fprintf(fs.Output(), "The AIOC cannot report its GPIO outputs back, so the levels printed are\n")
+		fmt.Fprintf(fs.Output(), "the ones commanded, not read from the device.\n\n")
+		fs.PrintDefaults()
+	}
+	profile := fs.String("profile", "", "File with \"name = GPIOn\" lines naming the GPIOs")
+	list := fs.Bool("list", false, "List the known GPIO names and exit")
+	rawMask := fs.String("mask", "", "Raw GPIO mask to update (hex or decimal, bit 0 = GPIO1)")
+	rawState := fs.String("state", "", "Raw GPIO levels for --mask (hex or decimal)")
+	openUSB := fs.String("open-usb", "", "USB VID and PID to use when opening (format: VID,PID)")
+	fs.Parse(args)
+
+	names := defaultGPIONames()
+	if *profile != "" {
+		if err := names.loadProfile(*profile); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load GPIO profile: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if *list {
+		for _, gpio := range []aioc.CM108GPIO{aioc.CM108GPIO1, aioc.CM108GPIO2, aioc.CM108GPIO3, aioc.CM108GPIO4} {
+			fmt.Println(names.label(gpio))
+		}
+		return
+	}
+
+	mask, state, err := names.parseAssignments(fs.Args())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	if *rawMask != "" {
+		m, err := parseHexOrDec(*rawMask)
+		if err != nil || m < 0 || m > int(aioc.CM108GPIOAll) {
+			fmt.Fprintf(os.Stderr, "Invalid --mask value: %s\n", *rawMask)
+			os.Exit(1)
+		}
+		st, err := parseHexOrDec(*rawState)
+		if err != nil || st < 0 || st > int(aioc.CM108GPIOAll) {
+			fmt.Fprintf(os.Stderr, "Invalid --state value: %s\n", *rawState)
+			os.Exit(1)
+		}
+		if mask&aioc.CM108GPIO(m) != 0 {
+			fmt.Fprintf(os.Stderr, "--mask overlaps the named GPIO assignments\n")
+			os.Exit(1)
+		}
+		mask |= aioc.CM108GPIO(m)
+		state |= aioc.CM108GPIO(st) & aioc.CM108GPIO(m)
+	}
+	if mask == 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	dev := openDevice(*openUSB)
+	defer dev.Close()
+
+	if err := dev.SetCM108GPIO(mask, state); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to set GPIOs: %v\n", err)
+		os.Exit(1)
+	}
+
+	commanded := dev.CM108GPIOState()
+	for _, gpio := range []aioc.CM108GPIO{aioc.CM108GPIO1, aioc.CM108GPIO2, aioc.CM108GPIO3, aioc.CM108GPIO4} {
+		if mask&gpio != 0 {
+			fmt.Printf("Commanded %s: %s\n", names.label(gpio), onOff(commanded&gpio != 0))
+		}
+	}
+}
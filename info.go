@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/rampa069/aioc-util/aioc"
+)
+
+// requireCapability exits if the firmware of dev lacks capability, and
+// warns if that cannot be told
+func requireCapability(dev *aioc.Device, capability aioc.Capability) {
+	caps, err := dev.Capabilities()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to detect firmware capabilities: %v\n", err)
+		os.Exit(1)
+	}
+	err = caps.Check(capability)
+	switch {
+	case err == nil:
+	case errors.Is(err, aioc.ErrUnsupportedFirmware):
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		fmt.Fprintf(os.Stderr, "Update the AIOC firmware to use this feature.\n")
+		os.Exit(1)
+	default:
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+}
+
+// runInfo implements "aioc-util info"
+func runInfo(args []string) {
+	fs := flag.NewFlagSet("info", flag.ExitOnError)
+	openUSB := fs.String("open-usb", "", "USB VID and PID to use when opening (format: VID,PID)")
+	fs.Parse(args)
+
+	dev := openDevice(*openUSB)
+	defer dev.Close()
+
+	mfr, _ := dev.GetManufacturer()
+	prod, _ := dev.GetProduct()
+	serial, _ := dev.GetSerialNumber()
+	fmt.Printf("Manufacturer: %s\n", mfr)
+	fmt.Printf("Product: %s\n", prod)
+	fmt.Printf("Serial: %s\n", serial)
+
+	usbid, err := dev.Read(aioc.RegUSBID)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read USBID: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("USB ID: %s\n", aioc.RegUSBID.Describe(usbid))
+	fmt.Printf("bcdDevice: 0x%04x\n", dev.BCDDevice())
+
+	caps, err := dev.Capabilities()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to detect firmware capabilities: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Firmware: %s\n", caps.Version)
+	if caps.Probed {
+		fmt.Println("Capabilities (probed from registers, the firmware does not report its version):")
+	} else {
+		fmt.Println("Capabilities:")
+	}
+	for _, c := range aioc.AllCapabilities {
+		fmt.Printf("  %-10s %-8s (firmware %s+)\n", c, caps.Support(c), c.Since())
+	}
+}
@@ -1,12 +1,16 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/rampa069/aioc-util/aioc"
+	"github.com/rampa069/aioc-util/morse"
 	"github.com/sstallion/go-hid"
 )
 
@@ -32,6 +36,8 @@ type Config struct {
 	Store                bool
 	SetPTT1State         string
 	SetPTT2State         string
+	PTTVia               string
+	Force                bool
 	EnableHWCOS          bool
 	EnableVCOS           bool
 	FoxhuntVolume        int
@@ -45,131 +51,96 @@ type Config struct {
 	AudioGetSettings     bool
 }
 
-func parsePTTSource(val string) (PTTSource, error) {
-	if val == "" {
-		return 0, nil
-	}
-	parts := strings.Split(val, "|")
-	var result PTTSource
-	for _, p := range parts {
-		p = strings.TrimSpace(p)
-		switch p {
-		case "NONE":
-			result |= PTTSourceNONE
-		case "CM108GPIO1":
-			result |= PTTSourceCM108GPIO1
-		case "CM108GPIO2":
-			result |= PTTSourceCM108GPIO2
-		case "CM108GPIO3":
-			result |= PTTSourceCM108GPIO3
-		case "CM108GPIO4":
-			result |= PTTSourceCM108GPIO4
-		case "SERIALDTR":
-			result |= PTTSourceSERIALDTR
-		case "SERIALRTS":
-			result |= PTTSourceSERIALRTS
-		case "SERIALDTRNRTS":
-			result |= PTTSourceSERIALDTRNRTS
-		case "SERIALNDTRRTS":
-			result |= PTTSourceSERIALNDTRRTS
-		case "VPTT":
-			result |= PTTSourceVPTT
-		default:
-			return 0, fmt.Errorf("unknown PTT source: %s", p)
-		}
-	}
-	return result, nil
-}
-
-func parseCM108ButtonSource(val string) (CM108ButtonSource, error) {
-	if val == "" {
-		return 0, nil
-	}
-	parts := strings.Split(val, "|")
-	var result CM108ButtonSource
-	for _, p := range parts {
-		p = strings.TrimSpace(p)
-		switch p {
-		case "NONE":
-			result |= CM108ButtonSourceNONE
-		case "IN1":
-			result |= CM108ButtonSourceIN1
-		case "IN2":
-			result |= CM108ButtonSourceIN2
-		case "VCOS":
-			result |= CM108ButtonSourceVCOS
-		default:
-			return 0, fmt.Errorf("unknown button source: %s", p)
-		}
-	}
-	return result, nil
+func parseHexOrDec(s string) (int, error) {
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+		val, err := strconv.ParseInt(s[2:], 16, 64)
+		return int(val), err
+	}
+	val, err := strconv.ParseInt(s, 0, 64)
+	return int(val), err
 }
 
-func pttSourceString(src PTTSource) string {
-	if src == PTTSourceNONE {
-		return "NONE"
-	}
-	var parts []string
-	if src&PTTSourceCM108GPIO1 != 0 {
-		parts = append(parts, "CM108GPIO1")
-	}
-	if src&PTTSourceCM108GPIO2 != 0 {
-		parts = append(parts, "CM108GPIO2")
-	}
-	if src&PTTSourceCM108GPIO3 != 0 {
-		parts = append(parts, "CM108GPIO3")
-	}
-	if src&PTTSourceCM108GPIO4 != 0 {
-		parts = append(parts, "CM108GPIO4")
-	}
-	if src&PTTSourceSERIALDTR != 0 {
-		parts = append(parts, "SERIALDTR")
-	}
-	if src&PTTSourceSERIALRTS != 0 {
-		parts = append(parts, "SERIALRTS")
-	}
-	if src&PTTSourceSERIALDTRNRTS != 0 {
-		parts = append(parts, "SERIALDTRNRTS")
+// parseUSBPair parses a "VID,PID" pair in hex or decimal
+func parseUSBPair(s string) (int, int, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid format %q, use: VID,PID", s)
 	}
-	if src&PTTSourceSERIALNDTRRTS != 0 {
-		parts = append(parts, "SERIALNDTRRTS")
-	}
-	if src&PTTSourceVPTT != 0 {
-		parts = append(parts, "VPTT")
+	vid, err := parseHexOrDec(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid VID: %w", err)
 	}
-	if len(parts) == 0 {
-		return fmt.Sprintf("0x%08x", src)
+	pid, err := parseHexOrDec(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid PID: %w", err)
 	}
-	return strings.Join(parts, "|")
+	return vid, pid, nil
 }
 
-func cm108ButtonSourceString(src CM108ButtonSource) string {
-	if src == CM108ButtonSourceNONE {
-		return "NONE"
-	}
-	var parts []string
-	if src&CM108ButtonSourceIN1 != 0 {
-		parts = append(parts, "IN1")
-	}
-	if src&CM108ButtonSourceIN2 != 0 {
-		parts = append(parts, "IN2")
-	}
-	if src&CM108ButtonSourceVCOS != 0 {
-		parts = append(parts, "VCOS")
+// openDevice opens the AIOC selected by an --open-usb style "VID,PID" value
+// (empty for the default IDs) and exits on failure. It is shared by the
+// subcommands.
+func openDevice(openUSB string) *aioc.Device {
+	vid := uint16(aioc.VendorID)
+	pid := uint16(aioc.ProductID)
+	if openUSB != "" {
+		v, p, err := parseUSBPair(openUSB)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid --open-usb value: %v\n", err)
+			os.Exit(1)
+		}
+		vid, pid = uint16(v), uint16(p)
 	}
-	if len(parts) == 0 {
-		return fmt.Sprintf("0x%08x", src)
+
+	dev, err := aioc.Open(vid, pid)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not open AIOC device (VID: 0x%04x, PID: 0x%04x): %v\n", vid, pid, err)
+		printOpenHint(err)
+		os.Exit(1)
 	}
-	return strings.Join(parts, "|")
+	return dev
 }
 
-func parseHexOrDec(s string) (int, error) {
-	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
-		val, err := strconv.ParseInt(s[2:], 16, 64)
-		return int(val), err
-	}
-	val, err := strconv.ParseInt(s, 0, 64)
-	return int(val), err
+// printOpenHint tells the user how to fix a failed open
+func printOpenHint(err error) {
+	var hint string
+	switch {
+	case errors.Is(err, aioc.ErrNotFound):
+		hint = "Check that the AIOC is plugged in. If its USB IDs were changed with --set-usb, pass them with --open-usb."
+	case errors.Is(err, aioc.ErrPermission):
+		hint = "Install the udev rule for non-root access and replug the AIOC:\n" +
+			"  sudo cp udev/rules.d/91-aioc.rules /etc/udev/rules.d/\n" +
+			"  sudo udevadm control --reload && sudo udevadm trigger"
+	case errors.Is(err, aioc.ErrNotAIOC):
+		hint = "The device with these USB IDs is not an AIOC (e.g. a CM108 sound card). Check --open-usb."
+	case errors.Is(err, aioc.ErrUnsupportedFirmware):
+		hint = "The firmware does not support configuration, update the AIOC to firmware v1.3 or later."
+	case errors.Is(err, aioc.ErrBusy):
+		hint = "Another program is using the AIOC, try again when it is done."
+	case errors.Is(err, aioc.ErrTimeout):
+		hint = "The AIOC stopped responding, replug it."
+	default:
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%s\n", hint)
+}
+
+// commands maps subcommand names to their entry points. Anything else is
+// handled by the flag based interface in main.
+var commands = map[string]func(args []string){
+	"ardf":          runARDF,
+	"audio":         runAudio,
+	"cat":           runCAT,
+	"firmware":      runFirmware,
+	"flrig":         runFlrig,
+	"foxhunt":       runFoxhunt,
+	"gpio":          runGPIO,
+	"info":          runInfo,
+	"scan":          runScan,
+	"selftest":      runSelftest,
+	"serial-bridge": runSerialBridge,
+	"vcos":          runVCOS,
+	"vptt":          runVPTT,
 }
 
 func main() {
@@ -179,6 +150,13 @@ func main() {
 	}
 	defer hid.Exit()
 
+	if len(os.Args) > 1 {
+		if run, ok := commands[os.Args[1]]; ok {
+			run(os.Args[2:])
+			return
+		}
+	}
+
 	config := Config{
 		VPTTLvlCtrl:   -1,
 		VPTTTimCtrl:   -1,
@@ -226,6 +204,8 @@ func main() {
 	flag.BoolVar(&config.Store, "store", false, "Store settings into flash")
 	flag.StringVar(&config.SetPTT1State, "set-ptt1-state", "", "Set PTT1 state via raw HID write: 'on' or 'off'")
 	flag.StringVar(&config.SetPTT2State, "set-ptt2-state", "", "Set PTT2 state via raw HID write: 'on' or 'off'")
+	flag.StringVar(&config.PTTVia, "via", "hid", "Path used by --set-ptt1-state/--set-ptt2-state: 'hid' (CM108 GPIO) or 'serial' (CDC DTR/RTS)")
+	flag.BoolVar(&config.Force, "force", false, "Apply settings that are otherwise refused: serial PTT without a serial source, a foxhunt message that does not fit the interval")
 	flag.BoolVar(&config.EnableHWCOS, "enable-hwcos", false, "Enable hardware COS (needs an AIOC that supports it)")
 	flag.BoolVar(&config.EnableVCOS, "enable-vcos", false, "Enable virtual COS (default behavior)")
 
@@ -239,7 +219,7 @@ func main() {
 	flag.StringVar(&foxhuntInterval, "foxhunt-interval", "", "Set foxhunt interval in seconds (0-255, 0 disables foxhunt mode)")
 
 	flag.BoolVar(&config.FoxhuntGetSettings, "foxhunt-get-settings", false, "Read and display current foxhunt control settings")
-	flag.StringVar(&config.FoxhuntMessage, "foxhunt-message", "", "Set foxhunt message (up to 16 characters)")
+	flag.StringVar(&config.FoxhuntMessage, "foxhunt-message", "", "Set foxhunt message (up to 16 characters, prosigns as <AR>)")
 	flag.BoolVar(&config.FoxhuntGetMessage, "foxhunt-get-message", false, "Read and display current foxhunt message")
 	flag.StringVar(&config.AudioRXGain, "audio-rx-gain", "", "Set audio RX gain: 1x, 2x, 4x, 8x, or 16x")
 	flag.StringVar(&config.AudioTXBoost, "audio-tx-boost", "", "Set audio TX boost: off or on")
@@ -249,19 +229,9 @@ func main() {
 
 	// Parse hex/decimal values
 	if setUSB != "" {
-		parts := strings.Split(setUSB, ",")
-		if len(parts) != 2 {
-			fmt.Fprintf(os.Stderr, "Invalid --set-usb format. Use: VID,PID\n")
-			os.Exit(1)
-		}
-		vid, err := parseHexOrDec(parts[0])
+		vid, pid, err := parseUSBPair(setUSB)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Invalid VID: %v\n", err)
-			os.Exit(1)
-		}
-		pid, err := parseHexOrDec(parts[1])
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Invalid PID: %v\n", err)
+			fmt.Fprintf(os.Stderr, "Invalid --set-usb value: %v\n", err)
 			os.Exit(1)
 		}
 		config.SetUSBVID = vid
@@ -269,19 +239,9 @@ func main() {
 	}
 
 	if openUSB != "" {
-		parts := strings.Split(openUSB, ",")
-		if len(parts) != 2 {
-			fmt.Fprintf(os.Stderr, "Invalid --open-usb format. Use: VID,PID\n")
-			os.Exit(1)
-		}
-		vid, err := parseHexOrDec(parts[0])
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Invalid VID: %v\n", err)
-			os.Exit(1)
-		}
-		pid, err := parseHexOrDec(parts[1])
+		vid, pid, err := parseUSBPair(openUSB)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Invalid PID: %v\n", err)
+			fmt.Fprintf(os.Stderr, "Invalid --open-usb value: %v\n", err)
 			os.Exit(1)
 		}
 		config.OpenUSBVID = vid
@@ -330,6 +290,10 @@ func main() {
 			fmt.Fprintf(os.Stderr, "Invalid --foxhunt-volume value: %v\n", err)
 			os.Exit(1)
 		}
+		if val < 0 || val > 65535 {
+			fmt.Fprintf(os.Stderr, "Invalid --foxhunt-volume value: %d (must be 0-65535)\n", val)
+			os.Exit(1)
+		}
 		config.FoxhuntVolume = val
 	}
 
@@ -339,6 +303,10 @@ func main() {
 			fmt.Fprintf(os.Stderr, "Invalid --foxhunt-wpm value: %v\n", err)
 			os.Exit(1)
 		}
+		if val < 0 || val > 255 {
+			fmt.Fprintf(os.Stderr, "Invalid --foxhunt-wpm value: %d (must be 0-255)\n", val)
+			os.Exit(1)
+		}
 		config.FoxhuntWPM = val
 	}
 
@@ -348,9 +316,30 @@ func main() {
 			fmt.Fprintf(os.Stderr, "Invalid --foxhunt-interval value: %v\n", err)
 			os.Exit(1)
 		}
+		if val < 0 || val > 255 {
+			fmt.Fprintf(os.Stderr, "Invalid --foxhunt-interval value: %d (must be 0-255)\n", val)
+			os.Exit(1)
+		}
 		config.FoxhuntInterval = val
 	}
 
+	if config.PTTVia != "hid" && config.PTTVia != "serial" {
+		fmt.Fprintf(os.Stderr, "Invalid --via value: %s (use 'hid' or 'serial')\n", config.PTTVia)
+		os.Exit(1)
+	}
+
+	if config.FoxhuntMessage != "" {
+		message, warnings, err := validateFoxhuntMessage(config.FoxhuntMessage)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid --foxhunt-message value: %v\n", err)
+			os.Exit(1)
+		}
+		for _, w := range warnings {
+			fmt.Fprintf(os.Stderr, "Warning: %s\n", w)
+		}
+		config.FoxhuntMessage = message
+	}
+
 	// Show help if no args
 	if len(os.Args) == 1 {
 		flag.Usage()
@@ -372,8 +361,8 @@ func main() {
 	}
 
 	// Open device
-	vid := uint16(AIOCVendorID)
-	pid := uint16(AIOCProductID)
+	vid := uint16(aioc.VendorID)
+	pid := uint16(aioc.ProductID)
 	if config.OpenUSBVID != -1 {
 		vid = uint16(config.OpenUSBVID)
 	}
@@ -381,32 +370,48 @@ func main() {
 		pid = uint16(config.OpenUSBPID)
 	}
 
-	aioc, err := Open(vid, pid)
+	dev, err := aioc.Open(vid, pid)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Could not open AIOC device (VID: 0x%04x, PID: 0x%04x): %v\n", vid, pid, err)
+		printOpenHint(err)
 		os.Exit(1)
 	}
-	defer aioc.Close()
+	defer dev.Close()
+
+	// Refuse settings the firmware does not have before changing anything
+	if config.VPTTLvlCtrl != -1 || config.VPTTTimCtrl != -1 {
+		requireCapability(dev, aioc.CapVPTT)
+	}
+	if config.VCOSLvlCtrl != -1 || config.VCOSTimCtrl != -1 || config.EnableVCOS {
+		requireCapability(dev, aioc.CapVCOS)
+	}
+	if config.FoxhuntVolume != -1 || config.FoxhuntWPM != -1 || config.FoxhuntInterval != -1 ||
+		config.FoxhuntMessage != "" || config.FoxhuntGetSettings || config.FoxhuntGetMessage {
+		requireCapability(dev, aioc.CapFoxhunt)
+	}
+	if config.AudioRXGain != "" || config.AudioTXBoost != "" || config.AudioGetSettings {
+		requireCapability(dev, aioc.CapAudio)
+	}
 
 	// Execute commands
 	if config.Defaults {
 		fmt.Println("Loading Defaults...")
-		if err := aioc.SendCommand(CmdDEFAULTS); err != nil {
+		if err := dev.SendCommand(aioc.CmdDEFAULTS); err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to load defaults: %v\n", err)
 			os.Exit(1)
 		}
 	}
 
 	if config.Dump {
-		mfr, _ := aioc.GetManufacturer()
-		prod, _ := aioc.GetProduct()
-		serial, _ := aioc.GetSerialNumber()
+		mfr, _ := dev.GetManufacturer()
+		prod, _ := dev.GetProduct()
+		serial, _ := dev.GetSerialNumber()
 
 		fmt.Printf("Manufacturer: %s\n", mfr)
 		fmt.Printf("Product: %s\n", prod)
 		fmt.Printf("Serial No: %s\n", serial)
 
-		magic, _ := aioc.Read(RegMAGIC)
+		magic, _ := dev.Read(aioc.RegMAGIC)
 		magicBytes := []byte{
 			byte(magic),
 			byte(magic >> 8),
@@ -415,340 +420,320 @@ func main() {
 		}
 		fmt.Printf("Magic: %s\n", magicBytes)
 
-		ptt1Source, _ := aioc.Read(RegAIOCIOMUX0)
-		ptt2Source, _ := aioc.Read(RegAIOCIOMUX1)
-		fmt.Printf("Current PTT1 Source: %s\n", pttSourceString(PTTSource(ptt1Source)))
-		fmt.Printf("Current PTT2 Source: %s\n", pttSourceString(PTTSource(ptt2Source)))
-
-		btn1Source, _ := aioc.Read(RegCM108IOMUX0)
-		btn2Source, _ := aioc.Read(RegCM108IOMUX1)
-		btn3Source, _ := aioc.Read(RegCM108IOMUX2)
-		btn4Source, _ := aioc.Read(RegCM108IOMUX3)
-		fmt.Printf("Current CM108 Button 1 (VolUP) Source: %s\n", cm108ButtonSourceString(CM108ButtonSource(btn1Source)))
-		fmt.Printf("Current CM108 Button 2 (VolDN) Source: %s\n", cm108ButtonSourceString(CM108ButtonSource(btn2Source)))
-		fmt.Printf("Current CM108 Button 3 (PlbMute) Source: %s\n", cm108ButtonSourceString(CM108ButtonSource(btn3Source)))
-		fmt.Printf("Current CM108 Button 4 (RecMute) Source: %s\n", cm108ButtonSourceString(CM108ButtonSource(btn4Source)))
-
-		if err := aioc.DumpRegisters(); err != nil {
+		ptt1Source, _ := dev.Read(aioc.RegAIOCIOMUX0)
+		ptt2Source, _ := dev.Read(aioc.RegAIOCIOMUX1)
+		fmt.Printf("Current PTT1 Source: %s\n", aioc.PTTSource(ptt1Source))
+		fmt.Printf("Current PTT2 Source: %s\n", aioc.PTTSource(ptt2Source))
+
+		btn1Source, _ := dev.Read(aioc.RegCM108IOMUX0)
+		btn2Source, _ := dev.Read(aioc.RegCM108IOMUX1)
+		btn3Source, _ := dev.Read(aioc.RegCM108IOMUX2)
+		btn4Source, _ := dev.Read(aioc.RegCM108IOMUX3)
+		fmt.Printf("Current CM108 Button 1 (VolUP) Source: %s\n", aioc.CM108ButtonSource(btn1Source))
+		fmt.Printf("Current CM108 Button 2 (VolDN) Source: %s\n", aioc.CM108ButtonSource(btn2Source))
+		fmt.Printf("Current CM108 Button 3 (PlbMute) Source: %s\n", aioc.CM108ButtonSource(btn3Source))
+		fmt.Printf("Current CM108 Button 4 (RecMute) Source: %s\n", aioc.CM108ButtonSource(btn4Source))
+
+		vals, err := dev.ReadRegisters()
+		if err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to dump registers: %v\n", err)
 			os.Exit(1)
 		}
+		for _, reg := range aioc.Registers {
+			fmt.Printf("Reg. %s: %08x\n", reg, vals[reg])
+		}
 	}
 
 	if config.SwapPTT {
-		ptt1Source, _ := aioc.Read(RegAIOCIOMUX0)
-		ptt2Source, _ := aioc.Read(RegAIOCIOMUX1)
+		ptt1Source, _ := dev.Read(aioc.RegAIOCIOMUX0)
+		ptt2Source, _ := dev.Read(aioc.RegAIOCIOMUX1)
 
-		fmt.Printf("Setting PTT1 Source to %s\n", pttSourceString(PTTSource(ptt2Source)))
-		aioc.Write(RegAIOCIOMUX0, ptt2Source)
-		fmt.Printf("Setting PTT2 Source to %s\n", pttSourceString(PTTSource(ptt1Source)))
-		aioc.Write(RegAIOCIOMUX1, ptt1Source)
+		fmt.Printf("Setting PTT1 Source to %s\n", aioc.PTTSource(ptt2Source))
+		dev.Write(aioc.RegAIOCIOMUX0, ptt2Source)
+		fmt.Printf("Setting PTT2 Source to %s\n", aioc.PTTSource(ptt1Source))
+		dev.Write(aioc.RegAIOCIOMUX1, ptt1Source)
 
-		newPTT1, _ := aioc.Read(RegAIOCIOMUX0)
-		newPTT2, _ := aioc.Read(RegAIOCIOMUX1)
-		fmt.Printf("Now PTT1 Source: %s\n", pttSourceString(PTTSource(newPTT1)))
-		fmt.Printf("Now PTT2 Source: %s\n", pttSourceString(PTTSource(newPTT2)))
+		newPTT1, _ := dev.Read(aioc.RegAIOCIOMUX0)
+		newPTT2, _ := dev.Read(aioc.RegAIOCIOMUX1)
+		fmt.Printf("Now PTT1 Source: %s\n", aioc.PTTSource(newPTT1))
+		fmt.Printf("Now PTT2 Source: %s\n", aioc.PTTSource(newPTT2))
 	}
 
 	if config.AutoPTT1 {
-		fmt.Printf("Setting PTT1 Source to %s\n", pttSourceString(PTTSourceVPTT))
-		aioc.Write(RegAIOCIOMUX0, uint32(PTTSourceVPTT))
+		fmt.Printf("Setting PTT1 Source to %s\n", aioc.PTTSourceVPTT)
+		dev.Write(aioc.RegAIOCIOMUX0, uint32(aioc.PTTSourceVPTT))
 
-		newPTT1, _ := aioc.Read(RegAIOCIOMUX0)
-		newPTT2, _ := aioc.Read(RegAIOCIOMUX1)
-		fmt.Printf("Now PTT1 Source: %s\n", pttSourceString(PTTSource(newPTT1)))
-		fmt.Printf("Now PTT2 Source: %s\n", pttSourceString(PTTSource(newPTT2)))
+		newPTT1, _ := dev.Read(aioc.RegAIOCIOMUX0)
+		newPTT2, _ := dev.Read(aioc.RegAIOCIOMUX1)
+		fmt.Printf("Now PTT1 Source: %s\n", aioc.PTTSource(newPTT1))
+		fmt.Printf("Now PTT2 Source: %s\n", aioc.PTTSource(newPTT2))
 	}
 
 	if config.PTT1 != "" || config.PTT2 != "" {
 		if config.PTT1 != "" {
-			val, err := parsePTTSource(config.PTT1)
+			val, err := aioc.ParsePTTSource(config.PTT1)
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "Failed to parse PTT1 source: %v\n", err)
 				os.Exit(1)
 			}
-			fmt.Printf("Setting PTT1 Source to %s\n", pttSourceString(val))
-			aioc.Write(RegAIOCIOMUX0, uint32(val))
+			fmt.Printf("Setting PTT1 Source to %s\n", val)
+			dev.Write(aioc.RegAIOCIOMUX0, uint32(val))
 		}
 		if config.PTT2 != "" {
-			val, err := parsePTTSource(config.PTT2)
+			val, err := aioc.ParsePTTSource(config.PTT2)
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "Failed to parse PTT2 source: %v\n", err)
 				os.Exit(1)
 			}
-			fmt.Printf("Setting PTT2 Source to %s\n", pttSourceString(val))
-			aioc.Write(RegAIOCIOMUX1, uint32(val))
+			fmt.Printf("Setting PTT2 Source to %s\n", val)
+			dev.Write(aioc.RegAIOCIOMUX1, uint32(val))
 		}
 
-		newPTT1, _ := aioc.Read(RegAIOCIOMUX0)
-		newPTT2, _ := aioc.Read(RegAIOCIOMUX1)
-		fmt.Printf("Now PTT1 Source: %s\n", pttSourceString(PTTSource(newPTT1)))
-		fmt.Printf("Now PTT2 Source: %s\n", pttSourceString(PTTSource(newPTT2)))
+		newPTT1, _ := dev.Read(aioc.RegAIOCIOMUX0)
+		newPTT2, _ := dev.Read(aioc.RegAIOCIOMUX1)
+		fmt.Printf("Now PTT1 Source: %s\n", aioc.PTTSource(newPTT1))
+		fmt.Printf("Now PTT2 Source: %s\n", aioc.PTTSource(newPTT2))
 	}
 
 	if config.SetUSBVID != -1 && config.SetUSBPID != -1 {
 		value := uint32((config.SetUSBPID << 16) | config.SetUSBVID)
-		aioc.Write(RegUSBID, value)
-		newVal, _ := aioc.Read(RegUSBID)
+		dev.Write(aioc.RegUSBID, value)
+		newVal, _ := dev.Read(aioc.RegUSBID)
 		fmt.Printf("Now USBID: %08x\n", newVal)
 	}
 
 	if config.VolUp != "" || config.VolDn != "" {
 		if config.VolUp != "" {
-			su, err := parseCM108ButtonSource(config.VolUp)
+			su, err := aioc.ParseCM108ButtonSource(config.VolUp)
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "Failed to parse VolUp source: %v\n", err)
 				os.Exit(1)
 			}
-			fmt.Printf("Setting VolUP button source to %s\n", cm108ButtonSourceString(su))
-			aioc.Write(RegCM108IOMUX0, uint32(su))
+			fmt.Printf("Setting VolUP button source to %s\n", su)
+			dev.Write(aioc.RegCM108IOMUX0, uint32(su))
 		}
 		if config.VolDn != "" {
-			sd, err := parseCM108ButtonSource(config.VolDn)
+			sd, err := aioc.ParseCM108ButtonSource(config.VolDn)
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "Failed to parse VolDn source: %v\n", err)
 				os.Exit(1)
 			}
-			fmt.Printf("Setting VolDN button source to %s\n", cm108ButtonSourceString(sd))
-			aioc.Write(RegCM108IOMUX1, uint32(sd))
+			fmt.Printf("Setting VolDN button source to %s\n", sd)
+			dev.Write(aioc.RegCM108IOMUX1, uint32(sd))
 		}
 
-		newVolUp, _ := aioc.Read(RegCM108IOMUX0)
-		newVolDn, _ := aioc.Read(RegCM108IOMUX1)
-		fmt.Printf("Now VolUP button source: %s\n", cm108ButtonSourceString(CM108ButtonSource(newVolUp)))
-		fmt.Printf("Now VolDN button source: %s\n", cm108ButtonSourceString(CM108ButtonSource(newVolDn)))
+		newVolUp, _ := dev.Read(aioc.RegCM108IOMUX0)
+		newVolDn, _ := dev.Read(aioc.RegCM108IOMUX1)
+		fmt.Printf("Now VolUP button source: %s\n", aioc.CM108ButtonSource(newVolUp))
+		fmt.Printf("Now VolDN button source: %s\n", aioc.CM108ButtonSource(newVolDn))
 	}
 
 	if config.VPTTLvlCtrl != -1 {
 		fmt.Printf("Setting VPTT_LVLCTRL to 0x%x\n", config.VPTTLvlCtrl)
-		aioc.Write(RegVPTTLVLCTRL, uint32(config.VPTTLvlCtrl))
-		newVal, _ := aioc.Read(RegVPTTLVLCTRL)
+		dev.Write(aioc.RegVPTTLVLCTRL, uint32(config.VPTTLvlCtrl))
+		newVal, _ := dev.Read(aioc.RegVPTTLVLCTRL)
 		fmt.Printf("Now VPTT_LVLCTRL: %08x\n", newVal)
 	}
 
 	if config.VPTTTimCtrl != -1 {
 		fmt.Printf("Setting VPTT_TIMCTRL to 0x%x\n", config.VPTTTimCtrl)
-		aioc.Write(RegVPTTTIMCTRL, uint32(config.VPTTTimCtrl))
-		newVal, _ := aioc.Read(RegVPTTTIMCTRL)
+		dev.Write(aioc.RegVPTTTIMCTRL, uint32(config.VPTTTimCtrl))
+		newVal, _ := dev.Read(aioc.RegVPTTTIMCTRL)
 		fmt.Printf("Now VPTT_TIMCTRL: %08x\n", newVal)
 	}
 
 	if config.VCOSLvlCtrl != -1 {
 		fmt.Printf("Setting VCOS_LVLCTRL to 0x%x\n", config.VCOSLvlCtrl)
-		aioc.Write(RegVCOSLVLCTRL, uint32(config.VCOSLvlCtrl))
-		newVal, _ := aioc.Read(RegVCOSLVLCTRL)
+		dev.Write(aioc.RegVCOSLVLCTRL, uint32(config.VCOSLvlCtrl))
+		newVal, _ := dev.Read(aioc.RegVCOSLVLCTRL)
 		fmt.Printf("Now VCOS_LVLCTRL: %08x\n", newVal)
 	}
 
 	if config.VCOSTimCtrl != -1 {
 		fmt.Printf("Setting VCOS_TIMCTRL to 0x%x\n", config.VCOSTimCtrl)
-		aioc.Write(RegVCOSTIMCTRL, uint32(config.VCOSTimCtrl))
-		newVal, _ := aioc.Read(RegVCOSTIMCTRL)
+		dev.Write(aioc.RegVCOSTIMCTRL, uint32(config.VCOSTimCtrl))
+		newVal, _ := dev.Read(aioc.RegVCOSTIMCTRL)
 		fmt.Printf("Now VCOS_TIMCTRL: %08x\n", newVal)
 	}
 
 	if config.EnableHWCOS {
 		fmt.Println("Enabling hardware COS (if your aioc supports it)...")
-		aioc.Write(RegCM108IOMUX0, uint32(CM108ButtonSourceNONE))
-		aioc.Write(RegCM108IOMUX1, uint32(CM108ButtonSourceIN2))
+		dev.Write(aioc.RegCM108IOMUX0, uint32(aioc.CM108ButtonSourceNONE))
+		dev.Write(aioc.RegCM108IOMUX1, uint32(aioc.CM108ButtonSourceIN2))
 
-		newVal0, _ := aioc.Read(RegCM108IOMUX0)
-		newVal1, _ := aioc.Read(RegCM108IOMUX1)
-		fmt.Printf("Now CM108_IOMUX0: %s\n", cm108ButtonSourceString(CM108ButtonSource(newVal0)))
-		fmt.Printf("Now CM108_IOMUX1: %s\n", cm108ButtonSourceString(CM108ButtonSource(newVal1)))
+		newVal0, _ := dev.Read(aioc.RegCM108IOMUX0)
+		newVal1, _ := dev.Read(aioc.RegCM108IOMUX1)
+		fmt.Printf("Now CM108_IOMUX0: %s\n", aioc.CM108ButtonSource(newVal0))
+		fmt.Printf("Now CM108_IOMUX1: %s\n", aioc.CM108ButtonSource(newVal1))
 	}
 
 	if config.EnableVCOS {
 		fmt.Println("Enabling virtual COS...")
-		aioc.Write(RegCM108IOMUX0, uint32(CM108ButtonSourceIN2))
-		aioc.Write(RegCM108IOMUX1, uint32(CM108ButtonSourceVCOS))
+		dev.Write(aioc.RegCM108IOMUX0, uint32(aioc.CM108ButtonSourceIN2))
+		dev.Write(aioc.RegCM108IOMUX1, uint32(aioc.CM108ButtonSourceVCOS))
 
-		newVal0, _ := aioc.Read(RegCM108IOMUX0)
-		newVal1, _ := aioc.Read(RegCM108IOMUX1)
-		fmt.Printf("Now CM108_IOMUX0: %s\n", cm108ButtonSourceString(CM108ButtonSource(newVal0)))
-		fmt.Printf("Now CM108_IOMUX1: %s\n", cm108ButtonSourceString(CM108ButtonSource(newVal1)))
+		newVal0, _ := dev.Read(aioc.RegCM108IOMUX0)
+		newVal1, _ := dev.Read(aioc.RegCM108IOMUX1)
+		fmt.Printf("Now CM108_IOMUX0: %s\n", aioc.CM108ButtonSource(newVal0))
+		fmt.Printf("Now CM108_IOMUX1: %s\n", aioc.CM108ButtonSource(newVal1))
 	}
 
 	if config.FoxhuntGetSettings {
-		currentFoxhunt, _ := aioc.Read(RegFOXHUNTCTRL)
-		currentVolume := (currentFoxhunt >> 16) & 0xFFFF
-		currentWPM := (currentFoxhunt >> 8) & 0xFF
-		currentInterval := currentFoxhunt & 0xFF
+		currentFoxhunt, _ := dev.Read(aioc.RegFOXHUNTCTRL)
+		current := aioc.DecodeFoxhuntCtrl(currentFoxhunt)
 
 		fmt.Println("Current foxhunt settings:")
-		fmt.Printf("  Volume: %d\n", currentVolume)
-		fmt.Printf("  WPM: %d\n", currentWPM)
-		fmt.Printf("  Interval: %d seconds\n", currentInterval)
+		fmt.Printf("  Volume: %d\n", current.Volume)
+		fmt.Printf("  WPM: %d\n", current.WPM)
+		fmt.Printf("  Interval: %d seconds\n", current.Interval)
 		fmt.Printf("  Raw register: %08x\n", currentFoxhunt)
 	}
 
 	if config.FoxhuntGetMessage {
-		msgRegisters := []Register{RegFOXHUNTMSG0, RegFOXHUNTMSG1, RegFOXHUNTMSG2, RegFOXHUNTMSG3}
-		messageBytes := make([]byte, 0, 16)
-
+		var vals [4]uint32
 		fmt.Println("Current foxhunt message registers:")
-		for i, reg := range msgRegisters {
-			val, _ := aioc.Read(reg)
-			regBytes := []byte{
-				byte(val),
-				byte(val >> 8),
-				byte(val >> 16),
-				byte(val >> 24),
-			}
-			messageBytes = append(messageBytes, regBytes...)
-			fmt.Printf("  MSG%d: %08x ('%s')\n", i, val, string(regBytes))
-		}
-
-		// Find null terminator
-		nullIdx := -1
-		for i, b := range messageBytes {
-			if b == 0 {
-				nullIdx = i
-				break
-			}
+		for i, reg := range aioc.FoxhuntMessageRegisters {
+			vals[i], _ = dev.Read(reg)
+			fmt.Printf("  MSG%d: %08x ('%s')\n", i, vals[i], aioc.DecodeFoxhuntMessage([4]uint32{vals[i]}))
 		}
-
-		messageStr := ""
-		if nullIdx != -1 {
-			messageStr = string(messageBytes[:nullIdx])
-		} else {
-			messageStr = string(messageBytes)
-		}
-
-		fmt.Printf("Current foxhunt message: '%s'\n", messageStr)
+		fmt.Printf("Current foxhunt message: '%s'\n", aioc.DecodeFoxhuntMessage(vals))
 	}
 
-	if config.FoxhuntVolume != -1 || config.FoxhuntWPM != -1 || config.FoxhuntInterval != -1 {
-		currentFoxhunt, _ := aioc.Read(RegFOXHUNTCTRL)
-		currentVolume := int((currentFoxhunt >> 16) & 0xFFFF)
-		currentWPM := int((currentFoxhunt >> 8) & 0xFF)
-		currentInterval := int(currentFoxhunt & 0xFF)
-
-		newVolume := currentVolume
+	// A new message is checked against the stored settings too, since it
+	// may no longer fit the interval
+	setFoxhuntCtrl := config.FoxhuntVolume != -1 || config.FoxhuntWPM != -1 || config.FoxhuntInterval != -1
+	if setFoxhuntCtrl || config.FoxhuntMessage != "" {
+		currentFoxhunt, err := dev.Read(aioc.RegFOXHUNTCTRL)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read FOXHUNT_CTRL: %v\n", err)
+			os.Exit(1)
+		}
+		ctrl := aioc.DecodeFoxhuntCtrl(currentFoxhunt)
 		if config.FoxhuntVolume != -1 {
-			newVolume = config.FoxhuntVolume
+			ctrl.Volume = config.FoxhuntVolume
 		}
-		newWPM := currentWPM
 		if config.FoxhuntWPM != -1 {
-			newWPM = config.FoxhuntWPM
+			ctrl.WPM = config.FoxhuntWPM
 		}
-		newInterval := currentInterval
 		if config.FoxhuntInterval != -1 {
-			newInterval = config.FoxhuntInterval
+			ctrl.Interval = config.FoxhuntInterval
 		}
 
-		newFoxhunt := uint32((newVolume << 16) | (newWPM << 8) | newInterval)
-		fmt.Printf("Setting FOXHUNT_CTRL: volume=%d, wpm=%d, interval=%d\n", newVolume, newWPM, newInterval)
-		aioc.Write(RegFOXHUNTCTRL, newFoxhunt)
+		newFoxhunt, err := ctrl.Encode()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid foxhunt settings: %v\n", err)
+			os.Exit(1)
+		}
 
-		updatedVal, _ := aioc.Read(RegFOXHUNTCTRL)
-		fmt.Printf("Now FOXHUNT_CTRL: %08x\n", updatedVal)
+		message := config.FoxhuntMessage
+		if message == "" {
+			message, err = dev.ReadFoxhuntMessage()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to read foxhunt message: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		if plan, err := planFoxhunt(message, ctrl.WPM, ctrl.Interval); err != nil {
+			if !config.Force {
+				fmt.Fprintf(os.Stderr, "Not applying foxhunt settings: %v (use --force to override)\n", err)
+				os.Exit(1)
+			}
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		} else {
+			for _, w := range plan.Warnings {
+				fmt.Fprintf(os.Stderr, "Warning: %s\n", w)
+			}
+		}
+
+		if setFoxhuntCtrl {
+			fmt.Printf("Setting FOXHUNT_CTRL: volume=%d, wpm=%d, interval=%d\n", ctrl.Volume, ctrl.WPM, ctrl.Interval)
+			if err := dev.Write(aioc.RegFOXHUNTCTRL, newFoxhunt); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to write FOXHUNT_CTRL: %v\n", err)
+				os.Exit(1)
+			}
+
+			updatedVal, _ := dev.Read(aioc.RegFOXHUNTCTRL)
+			fmt.Printf("Now FOXHUNT_CTRL: %08x\n", updatedVal)
+		}
 	}
 
 	if config.FoxhuntMessage != "" {
-		messageBytes := []byte(config.FoxhuntMessage)
-		if len(messageBytes) > 16 {
-			messageBytes = messageBytes[:16]
-		}
-		// Pad with nulls
-		for len(messageBytes) < 16 {
-			messageBytes = append(messageBytes, 0)
+		vals, err := aioc.EncodeFoxhuntMessage(config.FoxhuntMessage)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid foxhunt message: %v\n", err)
+			os.Exit(1)
 		}
 
-		msgRegisters := []Register{RegFOXHUNTMSG0, RegFOXHUNTMSG1, RegFOXHUNTMSG2, RegFOXHUNTMSG3}
-
 		fmt.Printf("Setting foxhunt message: '%s'\n", config.FoxhuntMessage)
-		for i := 0; i < 4; i++ {
-			byteOffset := i * 4
-			val := uint32(messageBytes[byteOffset]) |
-				(uint32(messageBytes[byteOffset+1]) << 8) |
-				(uint32(messageBytes[byteOffset+2]) << 16) |
-				(uint32(messageBytes[byteOffset+3]) << 24)
-			aioc.Write(msgRegisters[i], val)
-			fmt.Printf("  MSG%d: %08x ('%s')\n", i, val, string(messageBytes[byteOffset:byteOffset+4]))
+		for i, reg := range aioc.FoxhuntMessageRegisters {
+			if err := dev.Write(reg, vals[i]); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to write %s: %v\n", reg, err)
+				os.Exit(1)
+			}
+			fmt.Printf("  MSG%d: %08x ('%s')\n", i, vals[i], aioc.DecodeFoxhuntMessage([4]uint32{vals[i]}))
+		}
+
+		elems, _ := morse.Encode(config.FoxhuntMessage)
+		units := morse.Units(elems)
+		currentFoxhunt, _ := dev.Read(aioc.RegFOXHUNTCTRL)
+		wpm := aioc.DecodeFoxhuntCtrl(currentFoxhunt).WPM
+		if wpm > 0 {
+			fmt.Printf("Message length: %d dits (%.2f s at %d WPM)\n", units, (time.Duration(units) * morse.DitDuration(wpm)).Seconds(), wpm)
+		} else {
+			fmt.Printf("Message length: %d dits\n", units)
 		}
 	}
 
 	if config.AudioGetSettings {
-		currentRX, _ := aioc.Read(RegAUDIORX)
-		currentTX, _ := aioc.Read(RegAUDIOTX)
-
-		rxGainName := "unknown"
-		switch RXGain(currentRX) {
-		case RXGain1X:
-			rxGainName = "1x"
-		case RXGain2X:
-			rxGainName = "2x"
-		case RXGain4X:
-			rxGainName = "4x"
-		case RXGain8X:
-			rxGainName = "8x"
-		case RXGain16X:
-			rxGainName = "16x"
-		}
-
-		txBoostName := "unknown"
-		switch TXBoost(currentTX) {
-		case TXBoostOFF:
-			txBoostName = "off"
-		case TXBoostON:
-			txBoostName = "on"
-		}
+		currentRX, _ := dev.Read(aioc.RegAUDIORX)
+		currentTX, _ := dev.Read(aioc.RegAUDIOTX)
 
 		fmt.Println("Current audio settings:")
-		fmt.Printf("  RX Gain: %s\n", rxGainName)
-		fmt.Printf("  TX Boost: %s\n", txBoostName)
+		fmt.Printf("  RX Gain: %s\n", aioc.RXGain(currentRX))
+		fmt.Printf("  TX Boost: %s\n", aioc.TXBoost(currentTX))
 		fmt.Printf("  Raw AUDIO_RX: %08x\n", currentRX)
 		fmt.Printf("  Raw AUDIO_TX: %08x\n", currentTX)
 	}
 
 	if config.AudioRXGain != "" {
-		gainMap := map[string]RXGain{
-			"1x":  RXGain1X,
-			"2x":  RXGain2X,
-			"4x":  RXGain4X,
-			"8x":  RXGain8X,
-			"16x": RXGain16X,
-		}
-
-		gain, ok := gainMap[config.AudioRXGain]
-		if !ok {
+		gain, err := aioc.ParseRXGain(config.AudioRXGain)
+		if err != nil {
 			fmt.Fprintf(os.Stderr, "Invalid audio RX gain: %s\n", config.AudioRXGain)
 			os.Exit(1)
 		}
 
 		fmt.Printf("Setting Audio RX gain to %s\n", config.AudioRXGain)
-		aioc.Write(RegAUDIORX, uint32(gain))
-		newVal, _ := aioc.Read(RegAUDIORX)
+		dev.Write(aioc.RegAUDIORX, uint32(gain))
+		newVal, _ := dev.Read(aioc.RegAUDIORX)
 		fmt.Printf("Now AUDIO_RX: %08x\n", newVal)
 	}
 
 	if config.AudioTXBoost != "" {
-		boostMap := map[string]TXBoost{
-			"off": TXBoostOFF,
-			"on":  TXBoostON,
-		}
-
-		boost, ok := boostMap[config.AudioTXBoost]
-		if !ok {
+		boost, err := aioc.ParseTXBoost(config.AudioTXBoost)
+		if err != nil {
 			fmt.Fprintf(os.Stderr, "Invalid audio TX boost: %s\n", config.AudioTXBoost)
 			os.Exit(1)
 		}
 
 		fmt.Printf("Setting Audio TX boost to %s\n", config.AudioTXBoost)
-		aioc.Write(RegAUDIOTX, uint32(boost))
-		newVal, _ := aioc.Read(RegAUDIOTX)
+		dev.Write(aioc.RegAUDIOTX, uint32(boost))
+		newVal, _ := dev.Read(aioc.RegAUDIOTX)
 		fmt.Printf("Now AUDIO_TX: %08x\n", newVal)
 	}
 
 	if config.Store {
 		fmt.Println("Storing...")
-		aioc.SendCommand(CmdSTORE)
+		dev.SendCommand(aioc.CmdSTORE)
 	}
 
 	if config.SetPTT1State != "" {
 		on := config.SetPTT1State == "on"
-		if err := aioc.SetPTTState(PTTChannel1, on); err != nil {
+		if config.PTTVia == "serial" {
+			err = setPTTStateViaSerial(dev, vid, pid, 1, on, config.Force)
+		} else {
+			err = dev.SetPTTState(aioc.PTTChannel1, on)
+		}
+		if err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to set PTT1 state: %v\n", err)
 			os.Exit(1)
 		}
@@ -756,7 +741,12 @@ func main() {
 
 	if config.SetPTT2State != "" {
 		on := config.SetPTT2State == "on"
-		if err := aioc.SetPTTState(PTTChannel2, on); err != nil {
+		if config.PTTVia == "serial" {
+			err = setPTTStateViaSerial(dev, vid, pid, 2, on, config.Force)
+		} else {
+			err = dev.SetPTTState(aioc.PTTChannel2, on)
+		}
+		if err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to set PTT2 state: %v\n", err)
 			os.Exit(1)
 		}
@@ -764,6 +754,6 @@ func main() {
 
 	if config.Reboot {
 		fmt.Println("Rebooting device...")
-		aioc.SendCommand(CmdREBOOT)
+		dev.SendCommand(aioc.CmdREBOOT)
 	}
 }
@@ -0,0 +1,187 @@
+package morse
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// Mark is a measured keyed (tone) or unkeyed period of a received signal
+type Mark struct {
+	On       bool
+	Duration time.Duration
+}
+
+// Transmission is one decoded transmission, delimited by long silences
+type Transmission struct {
+	Start time.Duration // offset of the first mark in the recording
+	Text  string        // decoded text, '*' for unknown codes
+	WPM   float64       // measured speed
+}
+
+// decodeTable maps Morse representations back to characters
+var decodeTable = func() map[string]rune {
+	t := make(map[string]rune, len(Code))
+	for r, code := range Code {
+		t[code] = r
+	}
+	return t
+}()
+
+// Marks converts per-step keying decisions into marks, merging glitches
+// of a single step into the surrounding period
+func Marks(keyed []bool, step time.Duration) []Mark {
+	var marks []Mark
+	for i := 0; i < len(keyed); {
+		j := i
+		for j < len(keyed) && keyed[j] == keyed[i] {
+			j++
+		}
+		d := time.Duration(j-i) * step
+		switch {
+		case len(marks) > 0 && marks[len(marks)-1].On == keyed[i]:
+			marks[len(marks)-1].Duration += d
+		case j-i == 1 && len(marks) > 0 && j < len(keyed):
+			marks[len(marks)-1].Duration += d
+		default:
+			marks = append(marks, Mark{On: keyed[i], Duration: d})
+		}
+		i = j
+	}
+	return marks
+}
+
+// initialDit estimates the dit length by splitting the keyed durations
+// into two clusters (dits and dahs). When all elements have the same
+// length they are compared with the expected dit at wpm or, if wpm is 0,
+// with the shortest gap, which is the one-dit gap inside a character.
+func initialDit(marks []Mark, wpm int) time.Duration {
+	var on []time.Duration
+	for _, m := range marks {
+		if m.On {
+			on = append(on, m.Duration)
+		}
+	}
+	if len(on) == 0 {
+		return 0
+	}
+	sort.Slice(on, func(a, b int) bool { return on[a] < on[b] })
+	short, long := on[0], on[len(on)-1]
+	if long < 2*short {
+		// Only one kind of element, e.g. "MO" or "EE"
+		ref := DitDuration(wpm)
+		if ref == 0 {
+			ref = shortestGap(marks)
+		}
+		if ref > 0 && short > 2*ref {
+			return short / DahUnits
+		}
+		return short
+	}
+	for iter := 0; iter < 10; iter++ {
+		split := (short + long) / 2
+		var s, l, ns, nl time.Duration
+		for _, d := range on {
+			if d < split {
+				s, ns = s+d, ns+1
+			} else {
+				l, nl = l+d, nl+1
+			}
+		}
+		if ns == 0 || nl == 0 {
+			break
+		}
+		short, long = s/ns, l/nl
+	}
+	// A dah is three dits, use both clusters
+	return (short + long/3) / 2
+}
+
+// shortestGap returns the shortest unkeyed period between two keyed ones,
+// or 0 if there is none
+func shortestGap(marks []Mark) time.Duration {
+	var gap time.Duration
+	for i := 1; i+1 < len(marks); i++ {
+		if !marks[i].On && (gap == 0 || marks[i].Duration < gap) {
+			gap = marks[i].Duration
+		}
+	}
+	return gap
+}
+
+// Decode decodes marks into transmissions. The dit length is estimated
+// from the whole recording and then tracked element by element, so slow
+// speed drift is followed. wpm is the expected speed, only used to tell
+// dits from dahs when the recording holds one kind; 0 if unknown.
+// Silences longer than 14 dits separate transmissions.
+func Decode(marks []Mark, wpm int) []Transmission {
+	dit := initialDit(marks, wpm)
+	if dit == 0 {
+		return nil
+	}
+
+	var out []Transmission
+	var text strings.Builder
+	var code strings.Builder
+	var cur *Transmission
+	var ditSum time.Duration
+	var ditCount int
+	offset := time.Duration(0)
+
+	flushChar := func() {
+		if code.Len() == 0 {
+			return
+		}
+		if r, ok := decodeTable[code.String()]; ok {
+			text.WriteRune(r)
+		} else {
+			text.WriteRune('*')
+		}
+		code.Reset()
+	}
+	flushTransmission := func() {
+		flushChar()
+		if cur != nil {
+			cur.Text = strings.TrimSpace(text.String())
+			if ditCount > 0 {
+				cur.WPM = float64(ditAtOneWPM) / float64(ditSum/time.Duration(ditCount))
+			}
+			out = append(out, *cur)
+			cur = nil
+		}
+		text.Reset()
+		ditSum, ditCount = 0, 0
+	}
+
+	for _, m := range marks {
+		if m.On {
+			if cur == nil {
+				cur = &Transmission{Start: offset}
+			}
+			// Classify and adapt the dit estimate
+			if m.Duration < 2*dit {
+				code.WriteByte('.')
+				dit = (4*dit + m.Duration) / 5
+				ditSum += m.Duration
+			} else {
+				code.WriteByte('-')
+				dit = (4*dit + m.Duration/3) / 5
+				ditSum += m.Duration / 3
+			}
+			ditCount++
+		} else if cur != nil {
+			switch {
+			case m.Duration > 14*dit:
+				flushTransmission()
+			case m.Duration > 5*dit:
+				flushChar()
+				text.WriteByte(' ')
+			case m.Duration > 2*dit:
+				flushChar()
+			}
+		}
+		offset += m.Duration
+	}
+	flushTransmission()
+	return out
+}
@@ -0,0 +1,83 @@
+package morse
+
+import (
+	"testing"
+	"time"
+)
+
+// marks returns the marks of text sent at wpm, repeated with a pause
+// between transmissions
+func marks(t *testing.T, text string, wpm, repeat int) []Mark {
+	elems, err := Encode(text)
+	if err != nil {
+		t.Fatalf("Encode(%q): %v", text, err)
+	}
+	dit := DitDuration(wpm)
+	var out []Mark
+	for i := 0; i < repeat; i++ {
+		if i > 0 {
+			out = append(out, Mark{On: false, Duration: 30 * dit})
+		}
+		for _, e := range elems {
+			out = append(out, Mark{On: e.On, Duration: time.Duration(e.Units) * dit})
+		}
+	}
+	return out
+}
+
+func TestDecode(t *testing.T) {
+	for _, tc := range []struct {
+		text string
+		wpm  int
+	}{
+		{"CQ TEST DE N0CALL", 20},
+		{"VVV", 12},
+		// Only dahs or only dits: the speed tells them apart
+		{"MO", 15},
+		{"TOM", 15},
+		{"OO", 25},
+		{"T", 10},
+		{"EE", 15},
+		{"HI", 8},
+	} {
+		got := Decode(marks(t, tc.text, tc.wpm, 2), tc.wpm)
+		if len(got) != 2 {
+			t.Errorf("%q: %d transmissions, want 2", tc.text, len(got))
+			continue
+		}
+		for _, tx := range got {
+			if tx.Text != tc.text {
+				t.Errorf("%q at %d WPM decoded as %q", tc.text, tc.wpm, tx.Text)
+			}
+			if tx.WPM < float64(tc.wpm)-0.5 || tx.WPM > float64(tc.wpm)+0.5 {
+				t.Errorf("%q: measured %.1f WPM, want %d", tc.text, tx.WPM, tc.wpm)
+			}
+		}
+	}
+}
+
+func TestDecodeUnknownSpeed(t *testing.T) {
+	// The gaps inside a character are one dit long
+	for _, text := range []string{"MO", "TOM", "EE", "CQ"} {
+		got := Decode(marks(t, text, 18, 1), 0)
+		if len(got) != 1 || got[0].Text != text {
+			t.Errorf("%q decoded as %+v", text, got)
+		}
+	}
+}
+
+func TestMarks(t *testing.T) {
+	step := 2 * time.Millisecond
+	keyed := []bool{true, true, true, false, true, true, false, false, false, true}
+	got := Marks(keyed, step)
+	// The single unkeyed step is a glitch merged into the keyed period
+	want := []Mark{{true, 12 * time.Millisecond}, {false, 6 * time.Millisecond}, {true, 2 * time.Millisecond}}
+	if len(got) != len(want) {
+		t.Fatalf("Marks = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("mark %d = %v, want %v", i, got[i], want[i])
+		}
+	}
+}
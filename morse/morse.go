@@ -0,0 +1,190 @@
+// Package morse encodes text as International Morse code and renders it
+// as audio, using PARIS timing like the AIOC foxhunt beacon.
+package morse
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// Code maps characters to their International Morse representation
+var Code = map[rune]string{
+	'A': ".-", 'B': "-...", 'C': "-.-.", 'D': "-..", 'E': ".", 'F': "..-.",
+	'G': "--.", 'H': "....", 'I': "..", 'J': ".---", 'K': "-.-", 'L': ".-..",
+	'M': "--", 'N': "-.", 'O': "---", 'P': ".--.", 'Q': "--.-", 'R': ".-.",
+	'S': "...", 'T': "-", 'U': "..-", 'V': "...-", 'W': ".--", 'X': "-..-",
+	'Y': "-.--", 'Z': "--..",
+
+	'0': "-----", '1': ".----", '2': "..---", '3': "...--", '4': "....-",
+	'5': ".....", '6': "-....", '7': "--...", '8': "---..", '9': "----.",
+
+	'.': ".-.-.-", ',': "--..--", '?': "..--..", '\'': ".----.", '!': "-.-.--",
+	'/': "-..-.", '(': "-.--.", ')': "-.--.-", '&': ".-...", ':': "---...",
+	';': "-.-.-.", '=': "-...-", '+': ".-.-.", '-': "-....-", '_': "..--.-",
+	'"': ".-..-.", '$': "...-..-", '@': ".--.-.",
+}
+
+// Prosigns maps prosigns to the character that is sent with the same code.
+// Prosigns without such a character (e.g. SK) cannot be expressed in plain
+// text and are not listed.
+var Prosigns = map[string]rune{
+	"AR": '+',
+	"AS": '&',
+	"BT": '=',
+	"KN": '(',
+}
+
+// Element and gap lengths in dit units
+const (
+	DitUnits     = 1
+	DahUnits     = 3
+	ElementGap   = 1
+	CharacterGap = 3
+	WordGap      = 7
+)
+
+// ditAtOneWPM is the dit length at 1 WPM: the word "PARIS " is 50 units
+const ditAtOneWPM = time.Minute / 50
+
+// Element is a keyed (tone) or unkeyed (silence) period
+type Element struct {
+	On    bool
+	Units int
+}
+
+// Encode converts text into keyed and unkeyed elements. Runs of spaces
+// become a single word gap; leading and trailing spaces are ignored.
+// Characters without a Morse representation are an error.
+func Encode(text string) ([]Element, error) {
+	var elems []Element
+	words := strings.Fields(text)
+	for w, word := range words {
+		if w > 0 {
+			elems = append(elems, Element{On: false, Units: WordGap})
+		}
+		for c, r := range []rune(word) {
+			code, ok := Code[r]
+			if !ok {
+				return nil, fmt.Errorf("no Morse code for %q", r)
+			}
+			if c > 0 {
+				elems = append(elems, Element{On: false, Units: CharacterGap})
+			}
+			for i, sym := range code {
+				if i > 0 {
+					elems = append(elems, Element{On: false, Units: ElementGap})
+				}
+				units := DitUnits
+				if sym == '-' {
+					units = DahUnits
+				}
+				elems = append(elems, Element{On: true, Units: units})
+			}
+		}
+	}
+	return elems, nil
+}
+
+// Normalize upper-cases text and replaces prosigns written as <AR> with the
+// character that has the same code. It fails on the first character or
+// prosign that cannot be sent.
+func Normalize(text string) (string, error) {
+	var b strings.Builder
+	runes := []rune(strings.ToUpper(text))
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		if r == '<' {
+			end := i + 1
+			for end < len(runes) && runes[end] != '>' {
+				end++
+			}
+			if end == len(runes) {
+				return "", fmt.Errorf("unterminated prosign at position %d", i+1)
+			}
+			name := string(runes[i+1 : end])
+			c, ok := Prosigns[name]
+			if !ok {
+				return "", fmt.Errorf("prosign <%s> cannot be sent as a single character", name)
+			}
+			b.WriteRune(c)
+			i = end
+			continue
+		}
+		if r != ' ' {
+			if _, ok := Code[r]; !ok {
+				return "", fmt.Errorf("character %q at position %d has no Morse code", r, i+1)
+			}
+		}
+		b.WriteRune(r)
+	}
+	return b.String(), nil
+}
+
+// Units returns the total length of elems in dit units
+func Units(elems []Element) int {
+	total := 0
+	for _, e := range elems {
+		total += e.Units
+	}
+	return total
+}
+
+// DitDuration returns the length of one dit at wpm words per minute, using
+// the 50 unit word "PARIS "
+func DitDuration(wpm int) time.Duration {
+	if wpm <= 0 {
+		return 0
+	}
+	return ditAtOneWPM / time.Duration(wpm)
+}
+
+// Duration returns the on-air time of text sent at wpm
+func Duration(text string, wpm int) (time.Duration, error) {
+	elems, err := Encode(text)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(Units(elems)) * DitDuration(wpm), nil
+}
+
+// Tone describes the audio used by Render
+type Tone struct {
+	SampleRate int     // samples per second
+	Frequency  float64 // tone frequency in Hz
+	Amplitude  float64 // peak amplitude, 0.0 to 1.0 of full scale
+	Ramp       time.Duration
+}
+
+// Render synthesises elems sent at wpm as 16-bit mono PCM. Keyed elements
+// get raised cosine ramps of t.Ramp to avoid key clicks.
+func Render(elems []Element, wpm int, t Tone) []int16 {
+	ditSamples := int(DitDuration(wpm).Seconds() * float64(t.SampleRate))
+	rampSamples := int(t.Ramp.Seconds() * float64(t.SampleRate))
+	if rampSamples*2 > ditSamples {
+		rampSamples = ditSamples / 2
+	}
+
+	samples := make([]int16, 0, Units(elems)*ditSamples)
+	phase := 0.0
+	step := 2 * math.Pi * t.Frequency / float64(t.SampleRate)
+	for _, e := range elems {
+		n := e.Units * ditSamples
+		for i := 0; i < n; i++ {
+			if !e.On {
+				samples = append(samples, 0)
+				continue
+			}
+			env := 1.0
+			if i < rampSamples {
+				env = 0.5 - 0.5*math.Cos(math.Pi*float64(i)/float64(rampSamples))
+			} else if i >= n-rampSamples {
+				env = 0.5 - 0.5*math.Cos(math.Pi*float64(n-i)/float64(rampSamples))
+			}
+			samples = append(samples, int16(t.Amplitude*env*math.Sin(phase)*math.MaxInt16))
+			phase += step
+		}
+	}
+	return samples
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rampa069/aioc-util/aioc"
+)
+
+// pttChannelFromNumber maps a user facing PTT number (1 or 2) to the
+// channel value expected by SetPTTState
+func pttChannelFromNumber(n int) (int, error) {
+	switch n {
+	case 1:
+		return aioc.PTTChannel1, nil
+	case 2:
+		return aioc.PTTChannel2, nil
+	}
+	return 0, fmt.Errorf("invalid PTT channel %d, use 1 or 2", n)
+}
+
+func onOff(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
+
+// pttKeyer keys one PTT channel on behalf of a long running server. If the
+// channel stays keyed for longer than timeout it is released again, so a
+// crashed client cannot leave the transmitter on. A zero timeout disables
+// the guard.
+type pttKeyer struct {
+	mu      sync.Mutex
+	dev     *aioc.Device
+	channel int
+	timeout time.Duration
+	keyed   bool
+	timer   *time.Timer
+	gen     uint64 // bumped on every Set so stale timers can be ignored
+}
+
+func newPTTKeyer(dev *aioc.Device, channel int, timeout time.Duration) *pttKeyer {
+	return &pttKeyer{dev: dev, channel: channel, timeout: timeout}
+}
+
+// Set keys or unkeys the channel
+func (k *pttKeyer) Set(on bool) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.gen++
+	if k.timer != nil {
+		k.timer.Stop()
+		k.timer = nil
+	}
+	if err := k.dev.SetPTTState(k.channel, on); err != nil {
+		k.exitIfBroken(err)
+		return err
+	}
+	k.keyed = on
+	if on && k.timeout > 0 {
+		gen := k.gen
+		k.timer = time.AfterFunc(k.timeout, func() { k.expire(gen) })
+	}
+	return nil
+}
+
+// Keyed reports whether the channel is currently keyed
+func (k *pttKeyer) Keyed() bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.keyed
+}
+
+// Close releases the channel if it is still keyed
+func (k *pttKeyer) Close() error {
+	if !k.Keyed() {
+		return nil
+	}
+	return k.Set(false)
+}
+
+func (k *pttKeyer) expire(gen uint64) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	// A Set that raced with the timer has already taken over
+	if gen != k.gen || !k.keyed {
+		return
+	}
+	k.timer = nil
+	log.Printf("Transmit timeout (%s) reached, releasing PTT", k.timeout)
+	if err := k.dev.SetPTTState(k.channel, false); err != nil {
+		log.Printf("Failed to release PTT: %v", err)
+		k.exitIfBroken(err)
+		return
+	}
+	k.keyed = false
+}
+
+// exitIfBroken exits when the device stopped responding, since the PTT
+// state is unknown and the handle cannot be used again. A service manager
+// restarting the server reopens the device.
+func (k *pttKeyer) exitIfBroken(err error) {
+	if k.dev.Broken() != nil {
+		log.Fatalf("Device stopped responding, exiting: %v", err)
+	}
+}
+
+// releaseOnSignal unkeys k, runs cleanup and exits when the process is
+// interrupted, so stopping a server never leaves the transmitter keyed
+func releaseOnSignal(k *pttKeyer, cleanup ...func()) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		log.Printf("Received %s, shutting down", sig)
+		if err := k.Close(); err != nil {
+			log.Printf("Failed to release PTT: %v", err)
+		}
+		for _, fn := range cleanup {
+			fn()
+		}
+		os.Exit(0)
+	}()
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/rampa069/aioc-util/aioc"
+)
+
+// serialPTTLines returns the DTR/RTS levels that key a PTT output whose
+// IOMUX source is src. ok is false if src contains no serial source.
+func serialPTTLines(src aioc.PTTSource) (dtr, rts, ok bool) {
+	switch {
+	case src&aioc.PTTSourceSERIALDTR != 0:
+		return true, false, true
+	case src&aioc.PTTSourceSERIALRTS != 0:
+		return false, true, true
+	case src&aioc.PTTSourceSERIALDTRNRTS != 0:
+		return true, false, true
+	case src&aioc.PTTSourceSERIALNDTRRTS != 0:
+		return false, true, true
+	}
+	return false, false, false
+}
+
+// serialPTTActive reports whether the given DTR/RTS levels key a PTT output
+// whose IOMUX source is src
+func serialPTTActive(src aioc.PTTSource, dtr, rts bool) bool {
+	return (src&aioc.PTTSourceSERIALDTR != 0 && dtr) ||
+		(src&aioc.PTTSourceSERIALRTS != 0 && rts) ||
+		(src&aioc.PTTSourceSERIALDTRNRTS != 0 && dtr && !rts) ||
+		(src&aioc.PTTSourceSERIALNDTRRTS != 0 && !dtr && rts)
+}
+
+// setPTTStateViaSerial keys or unkeys PTT1 or PTT2 (ptt is 1 or 2) through
+// the DTR/RTS lines of the AIOC's CDC serial port instead of the CM108 HID
+// path. The IOMUX registers are checked first: keying is refused if the
+// lines do not reach the requested PTT output, unless force is set, in
+// which case DTR is asserted anyway.
+//
+// The lines are left in place when the tty is closed, so the PTT state
+// persists after aioc-util exits just like with the HID path. Opening the
+// tty raises DTR and RTS for a few milliseconds before they are released,
+// so any PTT output with a serial source, including the one being turned
+// off and the other channel, sees that pulse.
+func setPTTStateViaSerial(dev *aioc.Device, vid, pid uint16, ptt int, on, force bool) error {
+	regs := []aioc.Register{aioc.RegAIOCIOMUX0, aioc.RegAIOCIOMUX1}
+	if ptt != 1 && ptt != 2 {
+		return fmt.Errorf("invalid PTT channel %d", ptt)
+	}
+	val, err := dev.Read(regs[ptt-1])
+	if err != nil {
+		return fmt.Errorf("failed to read PTT%d source: %w", ptt, err)
+	}
+	src := aioc.PTTSource(val)
+	otherVal, err := dev.Read(regs[2-ptt])
+	if err != nil {
+		return fmt.Errorf("failed to read PTT%d source: %w", 3-ptt, err)
+	}
+	other := aioc.PTTSource(otherVal)
+
+	dtr, rts := false, false
+	if on {
+		var ok bool
+		dtr, rts, ok = serialPTTLines(src)
+		if !ok {
+			if !force {
+				return fmt.Errorf("PTT%d source is %s, serial lines do not key it (use --force to assert DTR anyway)", ptt, src)
+			}
+			fmt.Fprintf(os.Stderr, "Warning: PTT%d source is %s, serial lines do not key it (asserting DTR anyway)\n",
+				ptt, src)
+			dtr = true
+		}
+		if serialPTTActive(other, dtr, rts) {
+			fmt.Fprintf(os.Stderr, "Warning: PTT%d source %s is keyed by the same lines\n",
+				3-ptt, other)
+		}
+	}
+
+	serial, err := dev.GetSerialNumber()
+	if err != nil {
+		return fmt.Errorf("failed to read serial number: %w", err)
+	}
+	path, err := findSerialPort(vid, pid, serial)
+	if err != nil {
+		return err
+	}
+
+	port, err := openSerialPort(path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer port.Close()
+
+	if err := port.SetModemLines(dtr, rts); err != nil {
+		return fmt.Errorf("failed to set DTR/RTS on %s: %w", path, err)
+	}
+	// Keep the lines after close while keyed, drop them on close otherwise
+	if err := port.SetHangupOnClose(!on); err != nil {
+		return fmt.Errorf("failed to configure %s: %w", path, err)
+	}
+
+	fmt.Printf("PTT%d %s via %s (DTR=%s, RTS=%s)\n", ptt, onOff(on), path, onOff(dtr), onOff(rts))
+	return nil
+}
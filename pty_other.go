@@ -0,0 +1,13 @@
+//go:build !linux || !(386 || amd64 || arm || arm64 || loong64 || riscv64 || s390x)
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+func openPTY() (master, slave *os.File, err error) {
+	return nil, nil, fmt.Errorf("pseudo-terminals are not supported on %s/%s", runtime.GOOS, runtime.GOARCH)
+}
@@ -0,0 +1,522 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/rampa069/aioc-util/aioc"
+)
+
+// Telnet protocol bytes
+const (
+	telnetSE   = 240
+	telnetSB   = 250
+	telnetWILL = 251
+	telnetWONT = 252
+	telnetDO   = 253
+	telnetDONT = 254
+	telnetIAC  = 255
+)
+
+// Telnet options
+const (
+	telnetOptBinary  = 0
+	telnetOptSGA     = 3
+	telnetOptComPort = 44
+)
+
+// RFC 2217 COM-PORT-OPTION commands (client to server). Server replies use
+// the same value plus rfc2217ServerOffset.
+const (
+	rfc2217Signature          = 0
+	rfc2217SetBaudRate        = 1
+	rfc2217SetDataSize        = 2
+	rfc2217SetParity          = 3
+	rfc2217SetStopSize        = 4
+	rfc2217SetControl         = 5
+	rfc2217FlowControlSuspend = 8
+	rfc2217FlowControlResume  = 9
+	rfc2217SetLineStateMask   = 10
+	rfc2217SetModemStateMask  = 11
+	rfc2217PurgeData          = 12
+
+	rfc2217ServerOffset = 100
+)
+
+// SET-CONTROL values
+const (
+	rfc2217ControlFlowRequest = 0
+	rfc2217ControlFlowNone    = 1
+	rfc2217ControlDTRRequest  = 7
+	rfc2217ControlDTROn       = 8
+	rfc2217ControlDTROff      = 9
+	rfc2217ControlRTSRequest  = 10
+	rfc2217ControlRTSOn       = 11
+	rfc2217ControlRTSOff      = 12
+)
+
+// rfc2217Session bridges one telnet client to a serial port
+type rfc2217Session struct {
+	conn net.Conn
+	port comPort
+
+	wmu sync.Mutex // serialises writes to conn
+
+	// Current port settings, reported back on queries
+	baud     uint32
+	dataSize uint8
+	parity   uint8
+	stopSize uint8
+	dtr      bool
+	rts      bool
+
+	// Negotiated telnet options
+	will map[byte]bool
+	do   map[byte]bool
+}
+
+func newRFC2217Session(conn net.Conn, port comPort) *rfc2217Session {
+	return &rfc2217Session{
+		conn:     conn,
+		port:     port,
+		baud:     9600,
+		dataSize: 8,
+		parity:   1,
+		stopSize: 1,
+		will:     make(map[byte]bool),
+		do:       make(map[byte]bool),
+	}
+}
+
+func (s *rfc2217Session) send(b []byte) error {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	_, err := s.conn.Write(b)
+	return err
+}
+
+// sendData forwards serial data to the client, escaping IAC
+func (s *rfc2217Session) sendData(data []byte) error {
+	escaped := make([]byte, 0, len(data))
+	for _, b := range data {
+		escaped = append(escaped, b)
+		if b == telnetIAC {
+			escaped = append(escaped, telnetIAC)
+		}
+	}
+	return s.send(escaped)
+}
+
+// sendComPort sends a COM-PORT-OPTION reply, escaping IAC in the payload
+func (s *rfc2217Session) sendComPort(cmd byte, payload ...byte) error {
+	msg := []byte{telnetIAC, telnetSB, telnetOptComPort, cmd + rfc2217ServerOffset}
+	for _, b := range payload {
+		msg = append(msg, b)
+		if b == telnetIAC {
+			msg = append(msg, telnetIAC)
+		}
+	}
+	msg = append(msg, telnetIAC, telnetSE)
+	return s.send(msg)
+}
+
+// negotiate answers a WILL/WONT/DO/DONT request. Replies are only sent when
+// the option state changes, which prevents negotiation loops.
+func (s *rfc2217Session) negotiate(verb, opt byte) error {
+	supported := opt == telnetOptBinary || opt == telnetOptSGA || opt == telnetOptComPort
+
+	switch verb {
+	case telnetWILL:
+		if !supported {
+			return s.send([]byte{telnetIAC, telnetDONT, opt})
+		}
+		if !s.do[opt] {
+			s.do[opt] = true
+			return s.send([]byte{telnetIAC, telnetDO, opt})
+		}
+	case telnetWONT:
+		if s.do[opt] {
+			s.do[opt] = false
+			return s.send([]byte{telnetIAC, telnetDONT, opt})
+		}
+	case telnetDO:
+		if !supported {
+			return s.send([]byte{telnetIAC, telnetWONT, opt})
+		}
+		if !s.will[opt] {
+			s.will[opt] = true
+			return s.send([]byte{telnetIAC, telnetWILL, opt})
+		}
+	case telnetDONT:
+		if s.will[opt] {
+			s.will[opt] = false
+			return s.send([]byte{telnetIAC, telnetWONT, opt})
+		}
+	}
+	return nil
+}
+
+// subnegotiation handles an IAC SB ... IAC SE block (without the framing)
+func (s *rfc2217Session) subnegotiation(sb []byte) error {
+	if len(sb) < 2 || sb[0] != telnetOptComPort {
+		return nil
+	}
+	cmd, data := sb[1], sb[2:]
+
+	switch cmd {
+	case rfc2217Signature:
+		return s.sendComPort(cmd, []byte("aioc-util")...)
+
+	case rfc2217SetBaudRate:
+		if len(data) < 4 {
+			return nil
+		}
+		if baud := binary.BigEndian.Uint32(data); baud != 0 {
+			if err := s.port.SetBaudRate(int(baud)); err != nil {
+				log.Printf("Failed to set baud rate: %v", err)
+			} else {
+				s.baud = baud
+			}
+		}
+		reply := make([]byte, 4)
+		binary.BigEndian.PutUint32(reply, s.baud)
+		return s.sendComPort(cmd, reply...)
+
+	case rfc2217SetDataSize:
+		if len(data) < 1 {
+			return nil
+		}
+		if data[0] != 0 {
+			if err := s.port.SetDataBits(int(data[0])); err != nil {
+				log.Printf("Failed to set data size: %v", err)
+			} else {
+				s.dataSize = data[0]
+			}
+		}
+		return s.sendComPort(cmd, s.dataSize)
+
+	case rfc2217SetParity:
+		if len(data) < 1 {
+			return nil
+		}
+		if data[0] != 0 {
+			var err error
+			switch data[0] {
+			case 1:
+				err = s.port.SetParity(parityNone)
+			case 2:
+				err = s.port.SetParity(parityOdd)
+			case 3:
+				err = s.port.SetParity(parityEven)
+			default:
+				err = fmt.Errorf("mark/space parity is not supported")
+			}
+			if err != nil {
+				log.Printf("Failed to set parity: %v", err)
+			} else {
+				s.parity = data[0]
+			}
+		}
+		return s.sendComPort(cmd, s.parity)
+
+	case rfc2217SetStopSize:
+		if len(data) < 1 {
+			return nil
+		}
+		if data[0] == 1 || data[0] == 2 {
+			if err := s.port.SetStopBits(int(data[0])); err != nil {
+				log.Printf("Failed to set stop size: %v", err)
+			} else {
+				s.stopSize = data[0]
+			}
+		}
+		return s.sendComPort(cmd, s.stopSize)
+
+	case rfc2217SetControl:
+		if len(data) < 1 {
+			return nil
+		}
+		return s.setControl(data[0])
+
+	case rfc2217SetLineStateMask, rfc2217SetModemStateMask:
+		// No line or modem state notifications are generated, just
+		// acknowledge the mask
+		if len(data) < 1 {
+			return nil
+		}
+		return s.sendComPort(cmd, data[0])
+
+	case rfc2217FlowControlSuspend, rfc2217FlowControlResume:
+		return s.sendComPort(cmd)
+
+	case rfc2217PurgeData:
+		if len(data) < 1 {
+			return nil
+		}
+		if err := s.port.Purge(data[0]&1 != 0, data[0]&2 != 0); err != nil {
+			log.Printf("Failed to purge: %v", err)
+		}
+		return s.sendComPort(cmd, data[0])
+	}
+	return nil
+}
+
+func (s *rfc2217Session) setControl(val byte) error {
+	switch val {
+	case rfc2217ControlFlowRequest, rfc2217ControlFlowNone:
+		return s.sendComPort(rfc2217SetControl, rfc2217ControlFlowNone)
+	case rfc2217ControlDTROn, rfc2217ControlDTROff:
+		on := val == rfc2217ControlDTROn
+		if err := s.port.SetDTR(on); err != nil {
+			log.Printf("Failed to set DTR: %v", err)
+		} else {
+			s.dtr = on
+			log.Printf("DTR %s", onOff(on))
+		}
+		fallthrough
+	case rfc2217ControlDTRRequest:
+		if s.dtr {
+			return s.sendComPort(rfc2217SetControl, rfc2217ControlDTROn)
+		}
+		return s.sendComPort(rfc2217SetControl, rfc2217ControlDTROff)
+	case rfc2217ControlRTSOn, rfc2217ControlRTSOff:
+		on := val == rfc2217ControlRTSOn
+		if err := s.port.SetRTS(on); err != nil {
+			log.Printf("Failed to set RTS: %v", err)
+		} else {
+			s.rts = on
+			log.Printf("RTS %s", onOff(on))
+		}
+		fallthrough
+	case rfc2217ControlRTSRequest:
+		if s.rts {
+			return s.sendComPort(rfc2217SetControl, rfc2217ControlRTSOn)
+		}
+		return s.sendComPort(rfc2217SetControl, rfc2217ControlRTSOff)
+	}
+	// Break and other flow control modes are not supported; echo the
+	// request back as RFC 2217 allows
+	return s.sendComPort(rfc2217SetControl, val)
+}
+
+// Serve runs the session until either side closes
+func (s *rfc2217Session) Serve() error {
+	// The port keeps whatever the previous user set; apply the settings
+	// reported to the client until it changes them
+	if err := s.port.SetBaudRate(int(s.baud)); err != nil {
+		return fmt.Errorf("failed to set baud rate: %w", err)
+	}
+	if err := s.port.SetDataBits(int(s.dataSize)); err != nil {
+		return fmt.Errorf("failed to set data size: %w", err)
+	}
+	if err := s.port.SetParity(parityNone); err != nil {
+		return fmt.Errorf("failed to set parity: %w", err)
+	}
+	if err := s.port.SetStopBits(int(s.stopSize)); err != nil {
+		return fmt.Errorf("failed to set stop size: %w", err)
+	}
+	// The tty keeps HUPCL across opens and "--via serial on" clears it;
+	// set it so the lines drop even if the release on exit is skipped
+	if err := s.port.SetHangupOnClose(true); err != nil {
+		return fmt.Errorf("failed to set hangup on close: %w", err)
+	}
+	err := s.send([]byte{
+		telnetIAC, telnetWILL, telnetOptBinary,
+		telnetIAC, telnetDO, telnetOptBinary,
+		telnetIAC, telnetWILL, telnetOptSGA,
+		telnetIAC, telnetDO, telnetOptComPort,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send telnet options: %w", err)
+	}
+	s.will[telnetOptBinary] = true
+	s.will[telnetOptSGA] = true
+	s.do[telnetOptBinary] = true
+	s.do[telnetOptComPort] = true
+
+	// Serial to network
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, err := s.port.Read(buf)
+			if n > 0 {
+				if s.sendData(buf[:n]) != nil {
+					break
+				}
+			}
+			if err != nil {
+				break
+			}
+		}
+		s.conn.Close()
+	}()
+
+	// Network to serial, with the telnet state machine inline
+	r := bufio.NewReader(s.conn)
+	var data []byte
+	flush := func() error {
+		if len(data) == 0 {
+			return nil
+		}
+		_, err := s.port.Write(data)
+		data = data[:0]
+		return err
+	}
+
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			flush()
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if b != telnetIAC {
+			data = append(data, b)
+			if r.Buffered() == 0 {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		cmd, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		switch cmd {
+		case telnetIAC:
+			data = append(data, telnetIAC)
+			continue
+		case telnetWILL, telnetWONT, telnetDO, telnetDONT:
+			opt, err := r.ReadByte()
+			if err != nil {
+				return err
+			}
+			if err := s.negotiate(cmd, opt); err != nil {
+				return err
+			}
+		case telnetSB:
+			sb, err := readSubnegotiation(r)
+			if err != nil {
+				return err
+			}
+			if err := flush(); err != nil {
+				return err
+			}
+			if err := s.subnegotiation(sb); err != nil {
+				return err
+			}
+		}
+		// Other telnet commands (NOP, AYT, ...) are ignored
+	}
+}
+
+// readSubnegotiation reads up to and including IAC SE and returns the
+// unescaped contents
+func readSubnegotiation(r *bufio.Reader) ([]byte, error) {
+	var sb []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if b == telnetIAC {
+			if b, err = r.ReadByte(); err != nil {
+				return nil, err
+			}
+			if b == telnetSE {
+				return sb, nil
+			}
+		}
+		sb = append(sb, b)
+		if len(sb) > 64 {
+			return nil, fmt.Errorf("subnegotiation too long")
+		}
+	}
+}
+
+// runSerialBridge implements "aioc-util serial-bridge"
+func runSerialBridge(args []string) {
+	fs := flag.NewFlagSet("serial-bridge", flag.ExitOnError)
+	listen := fs.String("listen", ":2217", "Address to accept RFC 2217 clients on")
+	serial := fs.String("serial", "", "USB serial number of the AIOC to use (default: first found)")
+	portPath := fs.String("port", "", "Serial port to bridge instead of looking up the AIOC's tty")
+	openUSB := fs.String("open-usb", "", "USB VID and PID of the AIOC (format: VID,PID)")
+	fs.Parse(args)
+
+	path := *portPath
+	if path == "" {
+		vid, pid := aioc.VendorID, aioc.ProductID
+		if *openUSB != "" {
+			var err error
+			vid, pid, err = parseUSBPair(*openUSB)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Invalid --open-usb value: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		var err error
+		path, err = findSerialPort(uint16(vid), uint16(pid), *serial)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not locate AIOC serial port: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	ln, err := net.Listen("tcp", *listen)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to listen on %s: %v\n", *listen, err)
+		os.Exit(1)
+	}
+	log.Printf("RFC 2217 bridge for %s listening on %s", path, ln.Addr())
+
+	// Only one client may own the port at a time
+	busy := make(chan struct{}, 1)
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			log.Printf("Accept failed: %v", err)
+			continue
+		}
+
+		select {
+		case busy <- struct{}{}:
+		default:
+			log.Printf("Rejecting %s: port is in use", conn.RemoteAddr())
+			io.WriteString(conn, "aioc-util: serial port is in use by another client\r\n")
+			conn.Close()
+			continue
+		}
+
+		go func(conn net.Conn) {
+			defer func() { <-busy }()
+			defer conn.Close()
+
+			log.Printf("Client %s connected", conn.RemoteAddr())
+			port, err := openSerialPort(path)
+			if err != nil {
+				log.Printf("Failed to open %s: %v", path, err)
+				return
+			}
+			// A lost client must not leave the radio keyed: release
+			// DTR/RTS, and HUPCL (set by Serve) drops them on close too
+			defer port.Close()
+			defer port.SetModemLines(false, false)
+
+			if err := newRFC2217Session(conn, port).Serve(); err != nil {
+				log.Printf("Client %s: %v", conn.RemoteAddr(), err)
+			}
+			log.Printf("Client %s disconnected", conn.RemoteAddr())
+		}(conn)
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rampa069/aioc-util/aioc"
+)
+
+// scanRegister is what a scan found at one register address
+type scanRegister struct {
+	Address uint8    `json:"address"`
+	Name    string   `json:"name,omitempty"` // empty for undocumented registers
+	Values  []uint32 `json:"values,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// scanResult is a scan of the whole register space, as saved with --save
+type scanResult struct {
+	Time      time.Time      `json:"time"`
+	Serial    string         `json:"serial"`
+	Firmware  string         `json:"firmware"`
+	Passes    int            `json:"passes"`
+	Registers []scanRegister `json:"registers"`
+}
+
+// knownRegister reports whether r is one of the documented registers
+func knownRegister(r aioc.Register) bool {
+	for _, k := range aioc.Registers {
+		if k == r {
+			return true
+		}
+	}
+	return false
+}
+
+// nonZero reports whether any read of the register returned a non-zero value
+func (r scanRegister) nonZero() bool {
+	for _, v := range r.Values {
+		if v != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// changing reports whether the register read differently between passes
+func (r scanRegister) changing() bool {
+	for _, v := range r.Values {
+		if v != r.Values[0] {
+			return true
+		}
+	}
+	return false
+}
+
+// value returns the values read, one for a stable register
+func (r scanRegister) value() string {
+	if r.Error != "" {
+		return "error: " + r.Error
+	}
+	if len(r.Values) == 0 {
+		return "-"
+	}
+	if !r.changing() {
+		return fmt.Sprintf("0x%08x", r.Values[0])
+	}
+	vals := make([]string, len(r.Values))
+	for i, v := range r.Values {
+		vals[i] = fmt.Sprintf("0x%08x", v)
+	}
+	return strings.Join(vals, " ")
+}
+
+// flags returns the report markers of the register
+func (r scanRegister) flags() string {
+	var f []string
+	if r.nonZero() {
+		f = append(f, "non-zero")
+	}
+	if r.changing() {
+		f = append(f, "changing")
+	}
+	if r.Name == "" && (r.nonZero() || r.Error != "") {
+		f = append(f, "UNDOCUMENTED")
+	}
+	return strings.Join(f, ", ")
+}
+
+// scanDevice reads every register address passes times, interval apart
+func scanDevice(dev *aioc.Device, passes int, interval time.Duration) (*scanResult, error) {
+	res := &scanResult{Time: time.Now(), Passes: passes, Registers: make([]scanRegister, 256)}
+	res.Serial, _ = dev.GetSerialNumber()
+	res.Firmware = dev.FirmwareVersion().String()
+	for addr := range res.Registers {
+		r := aioc.Register(addr)
+		res.Registers[addr].Address = uint8(addr)
+		if knownRegister(r) {
+			res.Registers[addr].Name = r.String()
+		}
+	}
+
+	for pass := 0; pass < passes; pass++ {
+		if pass > 0 {
+			time.Sleep(interval)
+		}
+		for addr := range res.Registers {
+			reg := &res.Registers[addr]
+			if reg.Error != "" {
+				continue
+			}
+			val, err := dev.Read(aioc.Register(addr))
+			if errors.Is(err, aioc.ErrTimeout) || errors.Is(err, aioc.ErrBroken) {
+				return nil, fmt.Errorf("register 0x%02x: %w", addr, err)
+			}
+			if err != nil {
+				reg.Error, reg.Values = err.Error(), nil
+				continue
+			}
+			reg.Values = append(reg.Values, val)
+		}
+		fmt.Fprintf(os.Stderr, "Pass %d/%d done\n", pass+1, passes)
+	}
+	return res, nil
+}
+
+// loadScan reads a scan saved with --save
+func loadScan(path string) (*scanResult, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var res scanResult
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if len(res.Registers) != 256 {
+		return nil, fmt.Errorf("%s holds %d registers, expected 256", path, len(res.Registers))
+	}
+	return &res, nil
+}
+
+// printScan prints the registers that responded with data, or all with all
+func printScan(res *scanResult, all bool) {
+	fmt.Printf("Scan of %s (firmware %s), %d passes, %s\n",
+		res.Serial, res.Firmware, res.Passes, res.Time.Format("2006-01-02 15:04:05"))
+	fmt.Printf("%-5s %-16s %-11s %s\n", "ADDR", "NAME", "VALUE", "FLAGS")
+	var nonZero, changing, undocumented int
+	for _, r := range res.Registers {
+		if r.nonZero() {
+			nonZero++
+		}
+		if r.changing() {
+			changing++
+		}
+		if r.Name == "" && (r.nonZero() || r.Error != "") {
+			undocumented++
+		}
+		if !all && !r.nonZero() && r.Error == "" {
+			continue
+		}
+		name := r.Name
+		if name == "" {
+			name = "?"
+		}
+		fmt.Printf("0x%02x  %-16s %-11s %s\n", r.Address, name, r.value(), r.flags())
+	}
+	fmt.Printf("\n%d registers non-zero, %d changing, %d undocumented with data\n", nonZero, changing, undocumented)
+}
+
+// printScanDiff prints the registers that differ between two scans
+func printScanDiff(from, to *scanResult) {
+	fmt.Printf("Comparing %s (%s) with %s (%s)\n",
+		from.Time.Format("2006-01-02 15:04:05"), from.Firmware, to.Time.Format("2006-01-02 15:04:05"), to.Firmware)
+	if from.Serial != to.Serial {
+		fmt.Printf("Warning: the scans are of different devices (%s, %s)\n", from.Serial, to.Serial)
+	}
+	changed := 0
+	for addr := range to.Registers {
+		a, b := from.Registers[addr], to.Registers[addr]
+		if a.value() == b.value() {
+			continue
+		}
+		changed++
+		name := b.Name
+		if name == "" {
+			name = "?"
+		}
+		note := ""
+		if a.changing() || b.changing() {
+			note = "  (changing register)"
+		}
+		fmt.Printf("0x%02x  %-16s %s -> %s%s\n", addr, name, a.value(), b.value(), note)
+		if b.Name != "" && len(a.Values) > 0 && len(b.Values) > 0 && !a.changing() && !b.changing() {
+			r := aioc.Register(addr)
+			fmt.Printf("      %-16s %s -> %s\n", "", r.Describe(a.Values[0]), r.Describe(b.Values[0]))
+		}
+	}
+	if changed == 0 {
+		fmt.Println("No differences")
+	} else {
+		fmt.Printf("\n%d registers differ\n", changed)
+	}
+}
+
+// runScan implements "aioc-util scan"
+func runScan(args []string) {
+	if len(args) > 0 && args[0] == "diff" {
+		runScanDiff(args[1:])
+		return
+	}
+	fs := flag.NewFlagSet("scan", flag.ExitOnError)
+	passes := fs.Int("passes", 3, "Number of times to read each register, to find changing ones")
+	interval := fs.Duration("interval", 200*time.Millisecond, "Pause between passes")
+	all := fs.Bool("all", false, "List every address, not only those with data")
+	save := fs.String("save", "", "Save the scan to a JSON file")
+	compare := fs.String("compare", "", "Compare the scan with one saved earlier")
+	openUSB := fs.String("open-usb", "", "USB VID and PID to use when opening (format: VID,PID)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: aioc-util scan [options]\n")
+		fmt.Fprintf(os.Stderr, "       aioc-util scan diff OLD.json NEW.json\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *passes < 1 {
+		fmt.Fprintf(os.Stderr, "Invalid --passes value: %d\n", *passes)
+		os.Exit(1)
+	}
+	var old *scanResult
+	if *compare != "" {
+		var err error
+		if old, err = loadScan(*compare); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load scan: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	dev := openDevice(*openUSB)
+	defer dev.Close()
+
+	res, err := scanDevice(dev, *passes, *interval)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Scan failed: %v\n", err)
+		os.Exit(1)
+	}
+	if *save != "" {
+		data, _ := json.MarshalIndent(res, "", "  ")
+		if err := os.WriteFile(*save, append(data, '\n'), 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to save scan: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "Scan saved to %s\n", *save)
+	}
+
+	if old != nil {
+		printScanDiff(old, res)
+		return
+	}
+	printScan(res, *all)
+}
+
+// runScanDiff implements "aioc-util scan diff"
+func runScanDiff(args []string) {
+	if len(args) != 2 {
+		fmt.Fprintf(os.Stderr, "Usage: aioc-util scan diff OLD.json NEW.json\n")
+		os.Exit(1)
+	}
+	var scans [2]*scanResult
+	for i, path := range args {
+		res, err := loadScan(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load scan: %v\n", err)
+			os.Exit(1)
+		}
+		scans[i] = res
+	}
+	printScanDiff(scans[0], scans[1])
+}
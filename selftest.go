@@ -0,0 +1,297 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rampa069/aioc-util/aioc"
+)
+
+// selftestResult is the outcome of one self-test check
+type selftestResult struct {
+	name   string
+	status string // PASS, FAIL or SKIP
+}
+
+// selftest runs the checks against one device and times every transaction
+type selftest struct {
+	dev       *aioc.Device
+	results   []selftestResult
+	latencies []time.Duration
+
+	mu      sync.Mutex // held across every write, so interrupt can take over
+	changed *aioc.Register
+	orig    uint32
+}
+
+// changing records that reg is about to be overwritten, or with nil that
+// it has been restored
+func (t *selftest) changing(reg *aioc.Register, orig uint32) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.changed, t.orig = reg, orig
+}
+
+// interrupted restores the register being changed and releases both PTT
+// channels. The lock is kept so that no test write follows; the caller
+// exits.
+func (t *selftest) interrupted() {
+	t.mu.Lock()
+	if t.changed != nil {
+		if err := t.dev.Write(*t.changed, t.orig); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to restore %s to 0x%08x: %v\n", *t.changed, t.orig, err)
+		} else {
+			fmt.Fprintf(os.Stderr, "Restored %s to 0x%08x\n", *t.changed, t.orig)
+		}
+	}
+	t.dev.SetPTTState(aioc.PTTChannel1, false)
+	t.dev.SetPTTState(aioc.PTTChannel2, false)
+}
+
+// report records and prints the outcome of a check
+func (t *selftest) report(name, status, format string, args ...any) {
+	t.results = append(t.results, selftestResult{name, status})
+	fmt.Printf("%-4s  %-22s %s\n", status, name, fmt.Sprintf(format, args...))
+}
+
+// read reads a register and records the transaction time
+func (t *selftest) read(reg aioc.Register) (uint32, error) {
+	start := time.Now()
+	val, err := t.dev.Read(reg)
+	t.latencies = append(t.latencies, time.Since(start))
+	return val, err
+}
+
+// write writes a register in RAM and records the transaction time
+func (t *selftest) write(reg aioc.Register, val uint32) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	start := time.Now()
+	err := t.dev.Write(reg, val)
+	t.latencies = append(t.latencies, time.Since(start))
+	return err
+}
+
+// roundTrip checks that repeated reads of MAGIC return the AIOC magic
+func (t *selftest) roundTrip(n int) {
+	for i := 0; i < n; i++ {
+		val, err := t.read(aioc.RegMAGIC)
+		if err != nil {
+			t.report("feature report", "FAIL", "read %d of %d: %v", i+1, n, err)
+			return
+		}
+		if magic := aioc.DecodeMagic(val); magic != aioc.Magic {
+			t.report("feature report", "FAIL", "read %d of %d returned magic %q", i+1, n, magic)
+			return
+		}
+	}
+	t.report("feature report", "PASS", "%d reads of MAGIC returned %q", n, aioc.Magic)
+}
+
+// patterns writes each value to reg, reads it back and restores the
+// original value. Nothing is stored to flash.
+func (t *selftest) patterns(reg aioc.Register, values []uint32) {
+	name := "register " + reg.String()
+	orig, err := t.read(reg)
+	if err != nil {
+		t.report(name, "FAIL", "read: %v", err)
+		return
+	}
+	t.changing(&reg, orig)
+	var failure error
+	for _, val := range values {
+		if err := t.write(reg, val); err != nil {
+			failure = fmt.Errorf("write 0x%08x: %w", val, err)
+			break
+		}
+		got, err := t.read(reg)
+		if err != nil {
+			failure = fmt.Errorf("read back 0x%08x: %w", val, err)
+			break
+		}
+		if got != val {
+			failure = fmt.Errorf("wrote 0x%08x, read back 0x%08x", val, got)
+			break
+		}
+	}
+
+	// Restore even after a failure, unless the device stopped responding
+	if t.dev.Broken() == nil {
+		err := t.write(reg, orig)
+		if err == nil {
+			var got uint32
+			got, err = t.read(reg)
+			if err == nil && got != orig {
+				err = fmt.Errorf("read back 0x%08x", got)
+			}
+		}
+		if err != nil {
+			failure = errors.Join(failure, fmt.Errorf("restoring 0x%08x failed: %w", orig, err))
+		} else {
+			t.changing(nil, 0)
+		}
+	}
+	if failure != nil {
+		t.report(name, "FAIL", "%v", failure)
+		return
+	}
+	t.report(name, "PASS", "%d patterns, restored 0x%08x", len(values), orig)
+}
+
+// setPTT keys or releases a PTT channel and records the transaction time
+func (t *selftest) setPTT(ch int, on bool) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	start := time.Now()
+	err := t.dev.SetPTTState(ch, on)
+	t.latencies = append(t.latencies, time.Since(start))
+	return err
+}
+
+// ptt keys and releases each PTT channel
+func (t *selftest) ptt(hold time.Duration) {
+	for _, ch := range []int{aioc.PTTChannel1, aioc.PTTChannel2} {
+		name := fmt.Sprintf("PTT%d", ch-aioc.PTTChannel1+1)
+		err := t.setPTT(ch, true)
+		if err == nil {
+			time.Sleep(hold)
+		}
+		releaseErr := t.setPTT(ch, false)
+		if err = errors.Join(err, releaseErr); err != nil {
+			t.report(name, "FAIL", "%v", err)
+			continue
+		}
+		t.report(name, "PASS", "keyed for %s and released", hold)
+	}
+}
+
+// latency checks the transaction times recorded by the other checks
+func (t *selftest) latency(limit time.Duration) {
+	if len(t.latencies) == 0 {
+		t.report("latency", "SKIP", "no transactions")
+		return
+	}
+	sorted := append([]time.Duration(nil), t.latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	var total time.Duration
+	for _, d := range sorted {
+		total += d
+	}
+	p95 := sorted[(len(sorted)*95+99)/100-1]
+	worst := sorted[len(sorted)-1]
+	details := fmt.Sprintf("%d transactions: min %s, avg %s, p95 %s, max %s",
+		len(sorted), sorted[0].Round(time.Microsecond), (total / time.Duration(len(sorted))).Round(time.Microsecond),
+		p95.Round(time.Microsecond), worst.Round(time.Microsecond))
+	if worst > limit {
+		t.report("latency", "FAIL", "%s (limit %s)", details, limit)
+		return
+	}
+	t.report("latency", "PASS", "%s", details)
+}
+
+// runSelftest implements "aioc-util selftest"
+func runSelftest(args []string) {
+	fs := flag.NewFlagSet("selftest", flag.ExitOnError)
+	allowTX := fs.Bool("allow-tx", false, "Key each PTT channel and cycle the CM108 button routing; the radio transmits and the host may see COS")
+	hold := fs.Duration("hold", 200*time.Millisecond, "How long to key each PTT channel with --allow-tx")
+	reads := fs.Int("reads", 50, "Number of MAGIC reads in the feature report test")
+	maxLatency := fs.Duration("max-latency", 50*time.Millisecond, "Slowest transaction allowed")
+	openUSB := fs.String("open-usb", "", "USB VID and PID to use when opening (format: VID,PID)")
+	fs.Parse(args)
+
+	dev := openDevice(*openUSB)
+	defer dev.Close()
+
+	t := &selftest{dev: dev}
+
+	// Never leave a test pattern in a register or a PTT keyed, even when
+	// interrupted
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		t.interrupted()
+		os.Exit(1)
+	}()
+
+	serial, _ := dev.GetSerialNumber()
+	fmt.Printf("Self-test of AIOC %s, firmware %s\n\n", serial, dev.FirmwareVersion())
+
+	t.roundTrip(*reads)
+
+	// Features the firmware is known to lack are skipped, not failed
+	caps, err := dev.Capabilities()
+	if err != nil {
+		t.report("capabilities", "FAIL", "%v", err)
+	}
+	missing := func(c aioc.Capability) bool {
+		return caps.Support(c) == aioc.Unsupported
+	}
+
+	// Registers that are safe to change briefly: none of them can key
+	// the radio. PTT routing, VPTT and USB ID are left alone.
+	if missing(aioc.CapAudio) {
+		t.report("audio", "SKIP", "not supported by firmware %s", caps.Version)
+	} else {
+		t.patterns(aioc.RegAUDIORX, []uint32{
+			uint32(aioc.RXGain1X), uint32(aioc.RXGain2X), uint32(aioc.RXGain4X), uint32(aioc.RXGain8X), uint32(aioc.RXGain16X),
+		})
+		t.patterns(aioc.RegAUDIOTX, []uint32{uint32(aioc.TXBoostOFF), uint32(aioc.TXBoostON)})
+	}
+	// The CM108 button routing decides which inputs reach the HID
+	// buttons, which ASL and SvxLink read as COS: a pattern can make the
+	// host see a carrier and key a repeater
+	if *allowTX {
+		for _, reg := range []aioc.Register{aioc.RegCM108IOMUX0, aioc.RegCM108IOMUX1, aioc.RegCM108IOMUX2, aioc.RegCM108IOMUX3} {
+			t.patterns(reg, []uint32{
+				uint32(aioc.CM108ButtonSourceIN1), uint32(aioc.CM108ButtonSourceIN2),
+				uint32(aioc.CM108ButtonSourceVCOS), uint32(aioc.CM108ButtonSourceNONE),
+			})
+		}
+	} else {
+		t.report("CM108 button routing", "SKIP", "can signal COS to the host, needs --allow-tx")
+	}
+	// An active beacon would send the test patterns over the air
+	var foxhunt uint32
+	if !missing(aioc.CapFoxhunt) {
+		foxhunt, err = t.read(aioc.RegFOXHUNTCTRL)
+	}
+	switch {
+	case missing(aioc.CapFoxhunt):
+		t.report("foxhunt message", "SKIP", "not supported by firmware %s", caps.Version)
+	case err != nil:
+		t.report("foxhunt message", "FAIL", "read FOXHUNT_CTRL: %v", err)
+	case aioc.DecodeFoxhuntCtrl(foxhunt).Interval != 0:
+		t.report("foxhunt message", "SKIP", "foxhunt beacon is active")
+	default:
+		for _, reg := range aioc.FoxhuntMessageRegisters {
+			t.patterns(reg, []uint32{0x00000000, 0xFFFFFFFF, 0x55AA55AA, 0xAA55AA55, 0x01020408, 0x80402010})
+		}
+	}
+
+	if *allowTX {
+		t.ptt(*hold)
+	} else {
+		t.report("PTT", "SKIP", "keying the radio needs --allow-tx")
+	}
+	t.latency(*maxLatency)
+
+	failed := 0
+	for _, r := range t.results {
+		if r.status == "FAIL" {
+			failed++
+		}
+	}
+	if failed > 0 {
+		fmt.Printf("\nFAIL: %d of %d checks failed\n", failed, len(t.results))
+		os.Exit(1)
+	}
+	fmt.Printf("\nPASS: all %d checks passed\n", len(t.results))
+}
@@ -0,0 +1,30 @@
+package main
+
+import "io"
+
+// Parity settings for comPort.SetParity
+type parity int
+
+const (
+	parityNone parity = iota
+	parityOdd
+	parityEven
+)
+
+// comPort is a serial port with line control, as needed to bridge the AIOC
+// CDC port and to key PTT through its DTR/RTS lines
+type comPort interface {
+	io.ReadWriteCloser
+	SetBaudRate(baud int) error
+	SetDataBits(bits int) error
+	SetParity(p parity) error
+	SetStopBits(bits int) error
+	SetDTR(on bool) error
+	SetRTS(on bool) error
+	// SetModemLines sets DTR and RTS together in a single update
+	SetModemLines(dtr, rts bool) error
+	// Purge discards buffered data in the given directions
+	Purge(input, output bool) error
+	// SetHangupOnClose selects whether DTR/RTS drop when the port is closed
+	SetHangupOnClose(on bool) error
+}
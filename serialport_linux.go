@@ -0,0 +1,216 @@
+//go:build linux && (386 || amd64 || arm || arm64 || loong64 || riscv64 || s390x)
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// serialPort is a Linux tty driven through termios and modem ioctls
+type serialPort struct {
+	f *os.File
+}
+
+// openSerialPort opens a tty in raw 8N1 mode with DTR and RTS released.
+// The file is kept in non-blocking mode internally so Close interrupts a
+// pending Read.
+//
+// Linux raises DTR and RTS when a tty is opened, before they can be
+// released, so a PTT keyed by either line sees a pulse of a few
+// milliseconds on every open.
+func openSerialPort(path string) (comPort, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, err
+	}
+	p := &serialPort{f: f}
+
+	err = p.SetModemLines(false, false)
+	if err == nil {
+		err = p.control(func(fd uintptr) error {
+			if err := makeRaw(fd); err != nil {
+				return err
+			}
+			return p.modifyTermios(fd, func(t *termios) error {
+				t.Cflag |= termCREAD | termCLOCAL
+				return nil
+			})
+		})
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to configure %s: %w", path, err)
+	}
+	return p, nil
+}
+
+func (p *serialPort) Read(b []byte) (int, error)  { return p.f.Read(b) }
+func (p *serialPort) Write(b []byte) (int, error) { return p.f.Write(b) }
+func (p *serialPort) Close() error                { return p.f.Close() }
+
+// control runs fn with the raw file descriptor without switching the file
+// to blocking mode the way (*os.File).Fd does
+func (p *serialPort) control(fn func(fd uintptr) error) error {
+	rc, err := p.f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var fnErr error
+	if err := rc.Control(func(fd uintptr) { fnErr = fn(fd) }); err != nil {
+		return err
+	}
+	return fnErr
+}
+
+func (p *serialPort) modifyTermios(fd uintptr, fn func(t *termios) error) error {
+	t, err := getTermios(fd)
+	if err != nil {
+		return err
+	}
+	if err := fn(t); err != nil {
+		return err
+	}
+	return setTermios(fd, t)
+}
+
+func (p *serialPort) updateTermios(fn func(t *termios) error) error {
+	return p.control(func(fd uintptr) error {
+		return p.modifyTermios(fd, fn)
+	})
+}
+
+func (p *serialPort) SetBaudRate(baud int) error {
+	code, ok := termBaudRates[baud]
+	if !ok {
+		return fmt.Errorf("unsupported baud rate %d", baud)
+	}
+	return p.updateTermios(func(t *termios) error {
+		t.Cflag = t.Cflag&^termCBAUD | code
+		return nil
+	})
+}
+
+func (p *serialPort) SetDataBits(bits int) error {
+	var size uint32
+	switch bits {
+	case 5:
+		size = termCS5
+	case 6:
+		size = termCS6
+	case 7:
+		size = termCS7
+	case 8:
+		size = termCS8
+	default:
+		return fmt.Errorf("unsupported data size %d", bits)
+	}
+	return p.updateTermios(func(t *termios) error {
+		t.Cflag = t.Cflag&^termCSIZE | size
+		return nil
+	})
+}
+
+func (p *serialPort) SetParity(par parity) error {
+	return p.updateTermios(func(t *termios) error {
+		t.Cflag &^= termPARENB | termPARODD
+		switch par {
+		case parityNone:
+		case parityOdd:
+			t.Cflag |= termPARENB | termPARODD
+		case parityEven:
+			t.Cflag |= termPARENB
+		default:
+			return fmt.Errorf("unsupported parity %d", par)
+		}
+		return nil
+	})
+}
+
+func (p *serialPort) SetStopBits(bits int) error {
+	return p.updateTermios(func(t *termios) error {
+		switch bits {
+		case 1:
+			t.Cflag &^= termCSTOPB
+		case 2:
+			t.Cflag |= termCSTOPB
+		default:
+			return fmt.Errorf("unsupported stop bits %d", bits)
+		}
+		return nil
+	})
+}
+
+// modemLines updates the modem control lines with TIOCMGET/TIOCMSET
+func (p *serialPort) modemLines(set, clear int) error {
+	return p.control(func(fd uintptr) error {
+		var bits int32
+		if err := ioctl(fd, syscall.TIOCMGET, unsafe.Pointer(&bits)); err != nil {
+			return err
+		}
+		bits = bits&^int32(clear) | int32(set)
+		return ioctl(fd, syscall.TIOCMSET, unsafe.Pointer(&bits))
+	})
+}
+
+func (p *serialPort) SetDTR(on bool) error {
+	if on {
+		return p.modemLines(syscall.TIOCM_DTR, 0)
+	}
+	return p.modemLines(0, syscall.TIOCM_DTR)
+}
+
+func (p *serialPort) SetRTS(on bool) error {
+	if on {
+		return p.modemLines(syscall.TIOCM_RTS, 0)
+	}
+	return p.modemLines(0, syscall.TIOCM_RTS)
+}
+
+func (p *serialPort) SetModemLines(dtr, rts bool) error {
+	set, clear := 0, 0
+	if dtr {
+		set |= syscall.TIOCM_DTR
+	} else {
+		clear |= syscall.TIOCM_DTR
+	}
+	if rts {
+		set |= syscall.TIOCM_RTS
+	} else {
+		clear |= syscall.TIOCM_RTS
+	}
+	return p.modemLines(set, clear)
+}
+
+func (p *serialPort) SetHangupOnClose(on bool) error {
+	return p.updateTermios(func(t *termios) error {
+		if on {
+			t.Cflag |= termHUPCL
+		} else {
+			t.Cflag &^= termHUPCL
+		}
+		return nil
+	})
+}
+
+func (p *serialPort) Purge(input, output bool) error {
+	var queue uintptr
+	switch {
+	case input && output:
+		queue = tcioflush
+	case input:
+		queue = tciflush
+	case output:
+		queue = tcoflush
+	default:
+		return nil
+	}
+	return p.control(func(fd uintptr) error {
+		if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, tcflsh, queue); errno != 0 {
+			return errno
+		}
+		return nil
+	})
+}
@@ -0,0 +1,12 @@
+//go:build !linux || !(386 || amd64 || arm || arm64 || loong64 || riscv64 || s390x)
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func openSerialPort(path string) (comPort, error) {
+	return nil, fmt.Errorf("serial port control is not supported on %s/%s", runtime.GOOS, runtime.GOARCH)
+}
@@ -0,0 +1,134 @@
+//go:build linux && (386 || amd64 || arm || arm64 || loong64 || riscv64 || s390x)
+
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// Terminal ioctls and flags from asm-generic/termbits.h and ioctls.h. The
+// syscall package only defines them for some architectures, so they are
+// spelled out here. alpha, mips, powerpc and sparc use different values and
+// a different struct termios, so the build constraint leaves them out.
+const (
+	tcgets = 0x5401
+	tcsets = 0x5402
+	tcflsh = 0x540b
+
+	tciflush  = 0
+	tcoflush  = 1
+	tcioflush = 2
+
+	termIGNBRK = 0x0001
+	termBRKINT = 0x0002
+	termPARMRK = 0x0008
+	termISTRIP = 0x0020
+	termINLCR  = 0x0040
+	termIGNCR  = 0x0080
+	termICRNL  = 0x0100
+	termIXON   = 0x0400
+
+	termOPOST = 0x0001
+
+	termCBAUD  = 0x100f
+	termCSIZE  = 0x0030
+	termCS5    = 0x0000
+	termCS6    = 0x0010
+	termCS7    = 0x0020
+	termCS8    = 0x0030
+	termCSTOPB = 0x0040
+	termCREAD  = 0x0080
+	termPARENB = 0x0100
+	termPARODD = 0x0200
+	termHUPCL  = 0x0400
+	termCLOCAL = 0x0800
+
+	termISIG   = 0x0001
+	termICANON = 0x0002
+	termECHO   = 0x0008
+	termECHONL = 0x0040
+	termIEXTEN = 0x8000
+
+	termVTIME = 5
+	termVMIN  = 6
+)
+
+// termBaudRates maps baud rates to their CBAUD encoding
+var termBaudRates = map[int]uint32{
+	50:      0x0001,
+	75:      0x0002,
+	110:     0x0003,
+	134:     0x0004,
+	150:     0x0005,
+	200:     0x0006,
+	300:     0x0007,
+	600:     0x0008,
+	1200:    0x0009,
+	1800:    0x000a,
+	2400:    0x000b,
+	4800:    0x000c,
+	9600:    0x000d,
+	19200:   0x000e,
+	38400:   0x000f,
+	57600:   0x1001,
+	115200:  0x1002,
+	230400:  0x1003,
+	460800:  0x1004,
+	500000:  0x1005,
+	576000:  0x1006,
+	921600:  0x1007,
+	1000000: 0x1008,
+	1152000: 0x1009,
+	1500000: 0x100a,
+	2000000: 0x100b,
+	2500000: 0x100c,
+	3000000: 0x100d,
+	3500000: 0x100e,
+	4000000: 0x100f,
+}
+
+// termios mirrors the kernel's struct termios used by TCGETS/TCSETS
+type termios struct {
+	Iflag uint32
+	Oflag uint32
+	Cflag uint32
+	Lflag uint32
+	Line  uint8
+	Cc    [19]uint8
+}
+
+func ioctl(fd uintptr, req uintptr, arg unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func getTermios(fd uintptr) (*termios, error) {
+	t := &termios{}
+	if err := ioctl(fd, tcgets, unsafe.Pointer(t)); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func setTermios(fd uintptr, t *termios) error {
+	return ioctl(fd, tcsets, unsafe.Pointer(t))
+}
+
+// makeRaw puts a terminal into raw mode, like cfmakeraw(3)
+func makeRaw(fd uintptr) error {
+	t, err := getTermios(fd)
+	if err != nil {
+		return err
+	}
+	t.Iflag &^= termIGNBRK | termBRKINT | termPARMRK | termISTRIP | termINLCR | termIGNCR | termICRNL | termIXON
+	t.Oflag &^= termOPOST
+	t.Lflag &^= termECHO | termECHONL | termICANON | termISIG | termIEXTEN
+	t.Cflag &^= termCSIZE | termPARENB
+	t.Cflag |= termCS8
+	t.Cc[termVMIN] = 1
+	t.Cc[termVTIME] = 0
+	return setTermios(fd, t)
+}
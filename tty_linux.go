@@ -0,0 +1,59 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// findSerialPort returns the CDC ACM tty that belongs to the AIOC with the
+// given USB IDs. If serial is empty the first matching device is used,
+// mirroring how Open picks a HID device.
+func findSerialPort(vid, pid uint16, serial string) (string, error) {
+	ttys, err := filepath.Glob("/sys/class/tty/ttyACM*")
+	if err != nil {
+		return "", err
+	}
+
+	for _, tty := range ttys {
+		// device points at the USB interface, its parent is the USB device
+		iface, err := filepath.EvalSymlinks(filepath.Join(tty, "device"))
+		if err != nil {
+			continue
+		}
+		usbdev := filepath.Dir(iface)
+
+		if readSysfsHex(usbdev, "idVendor") != int(vid) || readSysfsHex(usbdev, "idProduct") != int(pid) {
+			continue
+		}
+		if serial != "" && readSysfsString(usbdev, "serial") != serial {
+			continue
+		}
+		return "/dev/" + filepath.Base(tty), nil
+	}
+
+	if serial != "" {
+		return "", fmt.Errorf("no serial port found for device %04x:%04x with serial %s", vid, pid, serial)
+	}
+	return "", fmt.Errorf("no serial port found for device %04x:%04x", vid, pid)
+}
+
+func readSysfsString(dir, name string) string {
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func readSysfsHex(dir, name string) int {
+	val, err := strconv.ParseInt(readSysfsString(dir, name), 16, 32)
+	if err != nil {
+		return -1
+	}
+	return int(val)
+}
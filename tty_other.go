@@ -0,0 +1,12 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func findSerialPort(vid, pid uint16, serial string) (string, error) {
+	return "", fmt.Errorf("locating the AIOC serial port is not supported on %s, use --port", runtime.GOOS)
+}
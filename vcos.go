@@ -0,0 +1,75 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/rampa069/aioc-util/aioc"
+	"github.com/rampa069/aioc-util/vdetect"
+)
+
+// vcosCommands are the subcommands of "aioc-util vcos"
+var vcosCommands = map[string]func([]string){
+	"simulate": runVCOSSimulate,
+}
+
+// runVCOS implements "aioc-util vcos"
+func runVCOS(args []string) {
+	if len(args) > 0 {
+		if run, ok := vcosCommands[args[0]]; ok {
+			run(args[1:])
+			return
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Usage: aioc-util vcos <command> [options]\n\n")
+	fmt.Fprintf(os.Stderr, "Commands:\n")
+	fmt.Fprintf(os.Stderr, "  simulate  Show when virtual COS opens on a recorded WAV file\n")
+	os.Exit(1)
+}
+
+// runVCOSSimulate implements "aioc-util vcos simulate"
+func runVCOSSimulate(args []string) {
+	var hang *time.Duration
+	var sep vdetect.Separation
+	runDetectorSimulate(detectorCommand{
+		name:          "vcos",
+		capability:    aioc.CapVCOS,
+		lvlReg:        aioc.RegVCOSLVLCTRL,
+		timReg:        aioc.RegVCOSTIMCTRL,
+		verb:          "opened",
+		wavHelp:       "WAV file recorded from the AIOC with the radio's receive audio",
+		recommendHelp: "Search for register values separating signal from noise",
+		bridgeHelp:    "Dropouts shorter than this should not close COS",
+		bridge:        time.Second,
+		flags: func(fs *flag.FlagSet) {
+			hang = fs.Duration("hang", 500*time.Millisecond, "Hang time wanted after a signal ends when recommending")
+		},
+		activity: func(levels vdetect.Levels, recommend bool) int {
+			var err error
+			sep, err = levels.Separate()
+			if err != nil && recommend {
+				fmt.Fprintf(os.Stderr, "Cannot recommend values: %v\n", err)
+				os.Exit(1)
+			}
+			// Audio above the loudest noise counts as a signal
+			return max(sep.NoiseCeil, 2*levels.Noise, 1)
+		},
+		recommend: func(samples []int16, rate int, levels vdetect.Levels, activity int, bridge time.Duration) vdetect.Detector {
+			fmt.Printf("\nLoudest noise: %d (%.1f dBFS), quietest signal: %d (%.1f dBFS)\n",
+				sep.NoiseCeil, vdetect.DB(sep.NoiseCeil), sep.SignalFloor, vdetect.DB(sep.SignalFloor))
+			if sep.Clean {
+				fmt.Printf("Separation: %.1f dB\n", vdetect.DB(sep.SignalFloor)-vdetect.DB(sep.NoiseCeil))
+			} else {
+				fmt.Fprintf(os.Stderr, "Warning: noise and signal overlap, expect false openings or missed signals (try a different RX gain)\n")
+			}
+
+			tail := max(*hang, vdetect.BridgeTail(vdetect.Gaps(samples, rate, sep.Threshold, 20*time.Millisecond), bridge, *hang))
+			if tail > *hang {
+				fmt.Printf("Tail raised above the %s hang time to ride through dropouts\n", *hang)
+			}
+			return vdetect.Detector{Threshold: sep.Threshold, Tail: tail}
+		},
+	}, args)
+}
@@ -0,0 +1,293 @@
+// Package vdetect models the level detectors behind the AIOC's virtual PTT
+// (VPTT) and virtual carrier-operated squelch (VCOS), so register values
+// can be tried on recorded audio instead of on the air.
+//
+// Both detectors work the same way: the output turns on as soon as the
+// magnitude of a 16-bit sample exceeds the threshold from the LVLCTRL
+// register, and turns off once no sample has exceeded it for the time in
+// the TIMCTRL register. The model follows that description; it is not
+// bit-exact with the firmware.
+package vdetect
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Register fields used by the model
+const (
+	ThresholdMask = 0xFFFF // LVLCTRL: threshold on the absolute 16-bit sample value
+	TailMask      = 0xFFFF // TIMCTRL: tail in milliseconds
+)
+
+// Detector is a threshold detector with a tail (hang) timer
+type Detector struct {
+	Threshold int
+	Tail      time.Duration
+}
+
+// FromRegisters returns the detector described by a LVLCTRL/TIMCTRL pair
+func FromRegisters(lvlctrl, timctrl uint32) Detector {
+	return Detector{
+		Threshold: int(lvlctrl & ThresholdMask),
+		Tail:      time.Duration(timctrl&TailMask) * time.Millisecond,
+	}
+}
+
+// Registers returns the LVLCTRL/TIMCTRL values for the detector
+func (d Detector) Registers() (lvlctrl, timctrl uint32, err error) {
+	if d.Threshold < 0 || d.Threshold > ThresholdMask {
+		return 0, 0, fmt.Errorf("threshold %d out of range 0-%d", d.Threshold, ThresholdMask)
+	}
+	ms := d.Tail.Milliseconds()
+	if ms < 0 || ms > TailMask {
+		return 0, 0, fmt.Errorf("tail %s out of range 0-%d ms", d.Tail, TailMask)
+	}
+	return uint32(d.Threshold), uint32(ms), nil
+}
+
+// Interval is a span of time during which the detector output is on
+type Interval struct {
+	Start, End time.Duration
+}
+
+// Duration returns the length of the interval
+func (i Interval) Duration() time.Duration {
+	return i.End - i.Start
+}
+
+// sampleTime converts a sample index to a time offset
+func sampleTime(i, sampleRate int) time.Duration {
+	return time.Duration(int64(i) * int64(time.Second) / int64(sampleRate))
+}
+
+// magnitude returns |s| without overflowing on -32768
+func magnitude(s int16) int {
+	if s < 0 {
+		return -int(s)
+	}
+	return int(s)
+}
+
+// Run feeds samples through the detector and returns the intervals during
+// which its output is on. An interval still open at the end of the audio
+// ends one tail after the last loud sample.
+func (d Detector) Run(samples []int16, sampleRate int) []Interval {
+	tail := int(d.Tail.Seconds() * float64(sampleRate))
+	var intervals []Interval
+	active := false
+	start, last := 0, 0
+	for i, s := range samples {
+		if magnitude(s) > d.Threshold {
+			if !active {
+				active = true
+				start = i
+			}
+			last = i
+		} else if active && i-last > tail {
+			intervals = append(intervals, Interval{sampleTime(start, sampleRate), sampleTime(last+1, sampleRate) + d.Tail})
+			active = false
+		}
+	}
+	if active {
+		intervals = append(intervals, Interval{sampleTime(start, sampleRate), sampleTime(last+1, sampleRate) + d.Tail})
+	}
+	return intervals
+}
+
+// Gaps returns the lengths of the pauses between samples louder than
+// threshold, ignoring pauses shorter than shortest (e.g. zero crossings)
+func Gaps(samples []int16, sampleRate, threshold int, shortest time.Duration) []time.Duration {
+	var gaps []time.Duration
+	last := -1
+	for i, s := range samples {
+		if magnitude(s) <= threshold {
+			continue
+		}
+		if last >= 0 {
+			if gap := sampleTime(i-last-1, sampleRate); gap >= shortest {
+				gaps = append(gaps, gap)
+			}
+		}
+		last = i
+	}
+	return gaps
+}
+
+// Levels holds the peak magnitude of consecutive blocks of audio
+type Levels struct {
+	Block  time.Duration
+	Peaks  []int
+	Noise  int // typical peak of the quiet blocks (10th percentile)
+	Signal int // typical peak of the loud blocks (95th percentile)
+}
+
+// Analyze splits the audio into blocks and measures their peak levels
+func Analyze(samples []int16, sampleRate int, block time.Duration) Levels {
+	n := int(block.Seconds() * float64(sampleRate))
+	if n < 1 {
+		n = 1
+	}
+	l := Levels{Block: sampleTime(n, sampleRate)}
+	for i := 0; i < len(samples); i += n {
+		peak := 0
+		for _, s := range samples[i:min(i+n, len(samples))] {
+			peak = max(peak, magnitude(s))
+		}
+		l.Peaks = append(l.Peaks, peak)
+	}
+	if len(l.Peaks) == 0 {
+		return l
+	}
+	sorted := append([]int(nil), l.Peaks...)
+	sort.Ints(sorted)
+	l.Noise = sorted[len(sorted)*10/100]
+	l.Signal = sorted[min(len(sorted)*95/100, len(sorted)-1)]
+	return l
+}
+
+// OnsetClip returns how long audio louder than activity was already
+// present before the interval started, i.e. how much of the first syllable
+// or of the carrier is lost. The result has block resolution.
+func (l Levels) OnsetClip(iv Interval, activity int) time.Duration {
+	first := min(int(iv.Start/l.Block), len(l.Peaks))
+	b := first
+	for b > 0 && l.Peaks[b-1] > activity {
+		b--
+	}
+	if b == first {
+		return 0
+	}
+	return iv.Start - time.Duration(b)*l.Block
+}
+
+// SearchThreshold returns the highest threshold from low upwards, in 1 dB
+// steps up to high, at which no interval loses more than maxClip of its
+// onset. Audio louder than low counts as activity. The worst onset clip at
+// the returned threshold is returned too.
+func (l Levels) SearchThreshold(samples []int16, sampleRate, low, high int, tail, maxClip time.Duration) (int, time.Duration) {
+	best, bestClip := low, time.Duration(0)
+	for t := float64(low); int(t) <= high; t *= math.Pow(10, 1.0/20) {
+		worst := time.Duration(0)
+		for _, iv := range (Detector{Threshold: int(t), Tail: tail}).Run(samples, sampleRate) {
+			worst = max(worst, l.OnsetClip(iv, low))
+		}
+		if worst > maxClip {
+			break
+		}
+		best, bestClip = int(t), worst
+	}
+	return best, bestClip
+}
+
+// BridgeTail returns a tail that bridges every gap up to bridge with 10%
+// margin, rounded up to 10 ms and at least shortest. Longer gaps are
+// treated as the end of a transmission.
+func BridgeTail(gaps []time.Duration, bridge, shortest time.Duration) time.Duration {
+	longest := time.Duration(0)
+	for _, g := range gaps {
+		if g <= bridge {
+			longest = max(longest, g)
+		}
+	}
+	tail := (longest*11/10 + 10*time.Millisecond - 1).Truncate(10 * time.Millisecond)
+	return min(max(tail, shortest), bridge)
+}
+
+// DB returns a 16-bit level in dBFS
+func DB(level int) float64 {
+	if level <= 0 {
+		return math.Inf(-1)
+	}
+	return 20 * math.Log10(float64(level)/32768)
+}
+
+// Timeline draws the intervals as a line of width characters covering
+// total, '#' where the output is on and '.' where it is off
+func Timeline(intervals []Interval, total time.Duration, width int) string {
+	if width < 1 || total <= 0 {
+		return ""
+	}
+	line := []byte(strings.Repeat(".", width))
+	for _, iv := range intervals {
+		from := int(int64(iv.Start) * int64(width) / int64(total))
+		to := int((int64(iv.End)*int64(width) + int64(total) - 1) / int64(total))
+		for c := max(from, 0); c < min(to, width); c++ {
+			line[c] = '#'
+		}
+	}
+	return string(line)
+}
+
+// Separation describes how well the block peaks split into a quiet class
+// (noise) and a loud class (signal)
+type Separation struct {
+	Threshold   int  // recommended threshold between the classes
+	NoiseCeil   int  // 99th percentile peak of the noise class
+	SignalFloor int  // 5th percentile peak of the signal class
+	Clean       bool // the classes do not overlap
+}
+
+// Separate splits the block peaks into noise and signal with Otsu's method
+// on their levels in dB and places the threshold halfway (in dB) between
+// the loudest noise and the quietest signal
+func (l Levels) Separate() (Separation, error) {
+	const bins = 97 // 1 dB bins from -96 to 0 dBFS
+	bin := func(peak int) int {
+		return min(max(int(math.Round(DB(max(peak, 1))))+96, 0), bins-1)
+	}
+	var hist [bins]int
+	for _, p := range l.Peaks {
+		hist[bin(p)]++
+	}
+
+	// Otsu: pick the split maximising the between-class variance
+	total, sum := 0, 0
+	for i, n := range hist {
+		total += n
+		sum += i * n
+	}
+	split, best := -1, 0.0
+	w0, sum0 := 0, 0
+	for i := 0; i < bins-1; i++ {
+		w0 += hist[i]
+		sum0 += i * hist[i]
+		w1 := total - w0
+		if w0 == 0 || w1 == 0 {
+			continue
+		}
+		m0 := float64(sum0) / float64(w0)
+		m1 := float64(sum-sum0) / float64(w1)
+		if v := float64(w0) * float64(w1) * (m0 - m1) * (m0 - m1); v > best {
+			split, best = i, v
+		}
+	}
+	if split < 0 {
+		return Separation{}, fmt.Errorf("the recording has a single level, it needs both noise and signal")
+	}
+
+	var noise, signal []int
+	for _, p := range l.Peaks {
+		if bin(p) <= split {
+			noise = append(noise, p)
+		} else {
+			signal = append(signal, p)
+		}
+	}
+	sort.Ints(noise)
+	sort.Ints(signal)
+	s := Separation{
+		NoiseCeil:   noise[min(len(noise)*99/100, len(noise)-1)],
+		SignalFloor: signal[len(signal)*5/100],
+	}
+	s.Clean = s.SignalFloor > s.NoiseCeil
+	if s.Clean {
+		s.Threshold = int(math.Sqrt(float64(max(s.NoiseCeil, 1)) * float64(s.SignalFloor)))
+	} else {
+		s.Threshold = int(32768 * math.Pow(10, float64(split+1-96)/20))
+	}
+	return s, nil
+}
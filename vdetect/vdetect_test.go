@@ -0,0 +1,179 @@
+package vdetect
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// bursts returns n samples at 1 kHz, one per millisecond, that are level
+// within the spans and silent elsewhere
+func bursts(n int, level int16, spans ...[2]int) []int16 {
+	s := make([]int16, n)
+	for _, sp := range spans {
+		for i := sp[0]; i < sp[1]; i++ {
+			s[i] = level
+		}
+	}
+	return s
+}
+
+func TestRegisters(t *testing.T) {
+	d := FromRegisters(0xABCD03E8, 0x00010320)
+	if d.Threshold != 1000 || d.Tail != 800*time.Millisecond {
+		t.Errorf("FromRegisters decoded %+v", d)
+	}
+	lvl, tim, err := d.Registers()
+	if err != nil || lvl != 0x03E8 || tim != 0x0320 {
+		t.Errorf("Registers returned 0x%x, 0x%x, %v", lvl, tim, err)
+	}
+
+	for _, d := range []Detector{
+		{Threshold: -1},
+		{Threshold: ThresholdMask + 1},
+		{Tail: -time.Millisecond},
+		{Tail: (TailMask + 1) * time.Millisecond},
+	} {
+		if _, _, err := d.Registers(); err == nil {
+			t.Errorf("Registers accepted %+v", d)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	// A 20 ms pause is bridged by the tail, the recording ends while the
+	// third burst's tail is still running
+	samples := bursts(700, -32768, [2]int{100, 200}, [2]int{220, 300}, [2]int{600, 650})
+	got := Detector{Threshold: 100, Tail: 50 * time.Millisecond}.Run(samples, 1000)
+	want := []Interval{
+		{100 * time.Millisecond, 350 * time.Millisecond},
+		{600 * time.Millisecond, 700 * time.Millisecond},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Run returned %v, want %v", got, want)
+	}
+
+	if got := (Detector{Threshold: 32768}).Run(samples, 1000); len(got) != 0 {
+		t.Errorf("detector above full scale turned on: %v", got)
+	}
+}
+
+func TestGaps(t *testing.T) {
+	samples := bursts(700, 1000, [2]int{100, 200}, [2]int{220, 300}, [2]int{600, 650})
+	for _, tc := range []struct {
+		shortest time.Duration
+		want     []time.Duration
+	}{
+		{10 * time.Millisecond, []time.Duration{20 * time.Millisecond, 300 * time.Millisecond}},
+		{25 * time.Millisecond, []time.Duration{300 * time.Millisecond}},
+	} {
+		if got := Gaps(samples, 1000, 100, tc.shortest); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("shortest %s: got %v, want %v", tc.shortest, got, tc.want)
+		}
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	// Block i peaks at 10*i
+	samples := make([]int16, 1000)
+	for b := 0; b < 100; b++ {
+		samples[b*10+3] = int16(-10 * b)
+	}
+	l := Analyze(samples, 1000, 10*time.Millisecond)
+	if l.Block != 10*time.Millisecond || len(l.Peaks) != 100 {
+		t.Fatalf("block %s, %d peaks", l.Block, len(l.Peaks))
+	}
+	if l.Peaks[42] != 420 || l.Noise != 100 || l.Signal != 950 {
+		t.Errorf("peak 42 is %d, noise %d, signal %d", l.Peaks[42], l.Noise, l.Signal)
+	}
+}
+
+func TestOnsetClip(t *testing.T) {
+	l := Levels{Block: 10 * time.Millisecond, Peaks: []int{0, 0, 500, 500, 500, 0}}
+	for _, tc := range []struct {
+		start, want time.Duration
+	}{
+		{20 * time.Millisecond, 0},
+		{40 * time.Millisecond, 20 * time.Millisecond},
+		{45 * time.Millisecond, 25 * time.Millisecond},
+	} {
+		if got := l.OnsetClip(Interval{Start: tc.start, End: time.Second}, 100); got != tc.want {
+			t.Errorf("start %s: clip %s, want %s", tc.start, got, tc.want)
+		}
+	}
+}
+
+func TestSearchThreshold(t *testing.T) {
+	// Each burst fades in over 30 ms: thresholds above the first step
+	// clip the onset
+	samples := make([]int16, 1000)
+	for _, start := range []int{100, 500} {
+		for i := 0; i < 200; i++ {
+			samples[start+i] = int16(1000 * min(i/10+1, 4))
+		}
+	}
+	l := Analyze(samples, 1000, 5*time.Millisecond)
+	threshold, clip := l.SearchThreshold(samples, 1000, 500, 4000, 100*time.Millisecond, 15*time.Millisecond)
+	if threshold < 1000 || threshold >= 3000 || clip > 15*time.Millisecond {
+		t.Errorf("threshold %d, clip %s", threshold, clip)
+	}
+}
+
+func TestBridgeTail(t *testing.T) {
+	for _, tc := range []struct {
+		gaps []time.Duration
+		want time.Duration
+	}{
+		{nil, 50 * time.Millisecond},
+		{[]time.Duration{20 * time.Millisecond}, 50 * time.Millisecond},
+		{[]time.Duration{20 * time.Millisecond, 300 * time.Millisecond, 600 * time.Millisecond}, 330 * time.Millisecond},
+		{[]time.Duration{301 * time.Millisecond}, 340 * time.Millisecond},
+		{[]time.Duration{480 * time.Millisecond}, 500 * time.Millisecond},
+	} {
+		if got := BridgeTail(tc.gaps, 500*time.Millisecond, 50*time.Millisecond); got != tc.want {
+			t.Errorf("gaps %v: tail %s, want %s", tc.gaps, got, tc.want)
+		}
+	}
+}
+
+func TestSeparate(t *testing.T) {
+	var l Levels
+	for i := 0; i < 50; i++ {
+		l.Peaks = append(l.Peaks, 100, 10000)
+	}
+	s, err := l.Separate()
+	if err != nil {
+		t.Fatalf("Separate: %v", err)
+	}
+	want := Separation{Threshold: 1000, NoiseCeil: 100, SignalFloor: 10000, Clean: true}
+	if s != want {
+		t.Errorf("Separate returned %+v, want %+v", s, want)
+	}
+
+	if _, err := (Levels{Peaks: []int{100, 100, 100}}).Separate(); err == nil {
+		t.Errorf("Separate of a single level succeeded")
+	}
+}
+
+func TestTimeline(t *testing.T) {
+	intervals := []Interval{{0, 250 * time.Millisecond}, {500 * time.Millisecond, 2 * time.Second}}
+	if got, want := Timeline(intervals, time.Second, 8), "##..####"; got != want {
+		t.Errorf("Timeline returned %q, want %q", got, want)
+	}
+	if got := Timeline(intervals, 0, 8); got != "" {
+		t.Errorf("Timeline of nothing returned %q", got)
+	}
+}
+
+func TestDB(t *testing.T) {
+	if got := DB(32768); got != 0 {
+		t.Errorf("DB(32768) = %f", got)
+	}
+	if got := DB(16384); math.Abs(got+6.02) > 0.01 {
+		t.Errorf("DB(16384) = %f", got)
+	}
+	if got := DB(0); !math.IsInf(got, -1) {
+		t.Errorf("DB(0) = %f", got)
+	}
+}
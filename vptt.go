@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/rampa069/aioc-util/aioc"
+	"github.com/rampa069/aioc-util/vdetect"
+)
+
+// vpttCommands are the subcommands of "aioc-util vptt"
+var vpttCommands = map[string]func([]string){
+	"simulate": runVPTTSimulate,
+}
+
+// runVPTT implements "aioc-util vptt"
+func runVPTT(args []string) {
+	if len(args) > 0 {
+		if run, ok := vpttCommands[args[0]]; ok {
+			run(args[1:])
+			return
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Usage: aioc-util vptt <command> [options]\n\n")
+	fmt.Fprintf(os.Stderr, "Commands:\n")
+	fmt.Fprintf(os.Stderr, "  simulate  Show when virtual PTT keys on a recorded WAV file\n")
+	os.Exit(1)
+}
+
+// runVPTTSimulate implements "aioc-util vptt simulate"
+func runVPTTSimulate(args []string) {
+	var maxClip *time.Duration
+	runDetectorSimulate(detectorCommand{
+		name:          "vptt",
+		capability:    aioc.CapVPTT,
+		lvlReg:        aioc.RegVPTTLVLCTRL,
+		timReg:        aioc.RegVPTTTIMCTRL,
+		verb:          "keyed",
+		wavHelp:       "WAV file with the audio sent to the AIOC",
+		recommendHelp: "Search for register values suiting the recording",
+		bridgeHelp:    "Pauses shorter than this should not drop PTT",
+		bridge:        500 * time.Millisecond,
+		flags: func(fs *flag.FlagSet) {
+			maxClip = fs.Duration("max-clip", 20*time.Millisecond, "Onset clipping allowed when recommending")
+		},
+		activity: func(levels vdetect.Levels, recommend bool) int {
+			// Anything 6 dB above the noise counts as speech
+			return max(2*levels.Noise, 1)
+		},
+		recommend: func(samples []int16, rate int, levels vdetect.Levels, activity int, bridge time.Duration) vdetect.Detector {
+			if levels.Signal < 4*levels.Noise {
+				fmt.Fprintf(os.Stderr, "Warning: less than 12 dB between noise and signal, the recommendation is unreliable\n")
+			}
+			threshold, _ := levels.SearchThreshold(samples, rate, activity, levels.Signal/2, bridge, *maxClip)
+			tail := vdetect.BridgeTail(vdetect.Gaps(samples, rate, threshold, 20*time.Millisecond), bridge, 50*time.Millisecond)
+			return vdetect.Detector{Threshold: threshold, Tail: tail}
+		},
+	}, args)
+}
@@ -0,0 +1,240 @@
+// Package wav reads and writes WAV files. Files are written as mono 16-bit
+// PCM; reading accepts PCM and IEEE float files and mixes them down to the
+// same format.
+package wav
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// WAVE format tags
+const (
+	formatPCM        = 0x0001
+	formatFloat      = 0x0003
+	formatExtensible = 0xFFFE
+)
+
+// ErrNotWAV is returned when a stream does not start with a RIFF/WAVE header
+var ErrNotWAV = errors.New("not a WAV file")
+
+// Write writes mono 16-bit PCM samples as a WAV stream
+func Write(w io.Writer, sampleRate int, samples []int16) error {
+	dataSize := uint32(len(samples) * 2)
+
+	header := struct {
+		RIFF          [4]byte
+		ChunkSize     uint32
+		WAVE          [4]byte
+		Fmt           [4]byte
+		FmtSize       uint32
+		AudioFormat   uint16
+		Channels      uint16
+		SampleRate    uint32
+		ByteRate      uint32
+		BlockAlign    uint16
+		BitsPerSample uint16
+		Data          [4]byte
+		DataSize      uint32
+	}{
+		RIFF:          [4]byte{'R', 'I', 'F', 'F'},
+		ChunkSize:     36 + dataSize,
+		WAVE:          [4]byte{'W', 'A', 'V', 'E'},
+		Fmt:           [4]byte{'f', 'm', 't', ' '},
+		FmtSize:       16,
+		AudioFormat:   1, // PCM
+		Channels:      1,
+		SampleRate:    uint32(sampleRate),
+		ByteRate:      uint32(sampleRate * 2),
+		BlockAlign:    2,
+		BitsPerSample: 16,
+		Data:          [4]byte{'d', 'a', 't', 'a'},
+		DataSize:      dataSize,
+	}
+
+	if err := binary.Write(w, binary.LittleEndian, &header); err != nil {
+		return err
+	}
+	return binary.Write(w, binary.LittleEndian, samples)
+}
+
+// WriteFile writes mono 16-bit PCM samples to a WAV file
+func WriteFile(path string, sampleRate int, samples []int16) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(f)
+	if err := Write(bw, sampleRate, samples); err != nil {
+		f.Close()
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// format is the part of the fmt chunk needed to decode samples
+type format struct {
+	tag        uint16
+	channels   int
+	sampleRate int
+	bits       int
+}
+
+// Read reads a PCM (8, 16, 24 or 32 bit) or IEEE float (32 or 64 bit) WAV
+// stream. It returns the sample rate and the samples mixed down to mono
+// 16-bit.
+func Read(r io.Reader) (int, []int16, error) {
+	var riff [12]byte
+	if _, err := io.ReadFull(r, riff[:]); err != nil {
+		return 0, nil, ErrNotWAV
+	}
+	if string(riff[0:4]) != "RIFF" || string(riff[8:12]) != "WAVE" {
+		return 0, nil, ErrNotWAV
+	}
+
+	var f *format
+	for {
+		var hdr [8]byte
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			return 0, nil, fmt.Errorf("no data chunk found")
+		}
+		id := string(hdr[0:4])
+		size := binary.LittleEndian.Uint32(hdr[4:8])
+
+		switch id {
+		case "fmt ":
+			chunk := make([]byte, size)
+			if _, err := io.ReadFull(r, chunk); err != nil {
+				return 0, nil, fmt.Errorf("short fmt chunk: %w", err)
+			}
+			var err error
+			if f, err = parseFormat(chunk); err != nil {
+				return 0, nil, err
+			}
+		case "data":
+			if f == nil {
+				return 0, nil, fmt.Errorf("data chunk before fmt chunk")
+			}
+			// Streamed files may carry a placeholder size, read what is there
+			data, err := io.ReadAll(io.LimitReader(r, int64(size)))
+			if err != nil {
+				return 0, nil, err
+			}
+			return f.sampleRate, f.decode(data), nil
+		default:
+			if _, err := io.CopyN(io.Discard, r, int64(size)); err != nil {
+				return 0, nil, fmt.Errorf("short %q chunk: %w", id, err)
+			}
+		}
+		// Chunks are padded to an even size
+		if size%2 == 1 {
+			if _, err := io.CopyN(io.Discard, r, 1); err != nil {
+				return 0, nil, fmt.Errorf("no data chunk found")
+			}
+		}
+	}
+}
+
+// ReadFile reads a WAV file, see Read
+func ReadFile(path string) (int, []int16, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer f.Close()
+	return Read(bufio.NewReader(f))
+}
+
+// parseFormat parses and checks a fmt chunk
+func parseFormat(chunk []byte) (*format, error) {
+	if len(chunk) < 16 {
+		return nil, fmt.Errorf("fmt chunk too short")
+	}
+	f := &format{
+		tag:        binary.LittleEndian.Uint16(chunk[0:2]),
+		channels:   int(binary.LittleEndian.Uint16(chunk[2:4])),
+		sampleRate: int(binary.LittleEndian.Uint32(chunk[4:8])),
+		bits:       int(binary.LittleEndian.Uint16(chunk[14:16])),
+	}
+	if f.tag == formatExtensible {
+		if len(chunk) < 26 {
+			return nil, fmt.Errorf("extensible fmt chunk too short")
+		}
+		// The sub-format GUID starts with the plain format tag
+		f.tag = binary.LittleEndian.Uint16(chunk[24:26])
+	}
+	if f.channels < 1 || f.sampleRate < 1 {
+		return nil, fmt.Errorf("invalid format: %d channels at %d Hz", f.channels, f.sampleRate)
+	}
+	switch {
+	case f.tag == formatPCM && (f.bits == 8 || f.bits == 16 || f.bits == 24 || f.bits == 32):
+	case f.tag == formatFloat && (f.bits == 32 || f.bits == 64):
+	default:
+		return nil, fmt.Errorf("unsupported format %#04x with %d bits per sample", f.tag, f.bits)
+	}
+	return f, nil
+}
+
+// decode converts interleaved sample data to mono 16-bit samples
+func (f *format) decode(data []byte) []int16 {
+	width := f.bits / 8
+	frame := width * f.channels
+	samples := make([]int16, len(data)/frame)
+	for i := range samples {
+		sum := 0.0
+		for c := 0; c < f.channels; c++ {
+			sum += f.sample(data[i*frame+c*width:])
+		}
+		v := math.Round(sum / float64(f.channels) * 32768)
+		samples[i] = int16(math.Max(-32768, math.Min(32767, v)))
+	}
+	return samples
+}
+
+// sample decodes one sample as a value between -1 and 1
+func (f *format) sample(b []byte) float64 {
+	if f.tag == formatFloat {
+		if f.bits == 32 {
+			return float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(b))
+	}
+	switch f.bits {
+	case 8:
+		return float64(int(b[0])-128) / 128
+	case 16:
+		return float64(int16(binary.LittleEndian.Uint16(b))) / 32768
+	case 24:
+		return float64(int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24)>>8) / (1 << 23)
+	default:
+		return float64(int32(binary.LittleEndian.Uint32(b))) / (1 << 31)
+	}
+}
+
+// WriteRaw writes samples as headerless signed 16-bit little-endian PCM
+func WriteRaw(w io.Writer, samples []int16) error {
+	return binary.Write(w, binary.LittleEndian, samples)
+}
+
+// ReadRaw reads headerless signed 16-bit little-endian PCM, as written by
+// "arecord -t raw -f S16_LE", and mixes the channels down to mono
+func ReadRaw(r io.Reader, channels int) ([]int16, error) {
+	if channels < 1 {
+		return nil, fmt.Errorf("invalid channel count %d", channels)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	f := &format{tag: formatPCM, channels: channels, bits: 16}
+	return f.decode(data), nil
+}